@@ -0,0 +1,288 @@
+package ntlm_parser
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+// AvID
+//
+// reference: https://learn.microsoft.com/en-us/openspecs/windows_protocols/ms-nlmp/83f5e789-660d-4781-8491-5f8c6641f75e
+type AvID uint16
+
+const (
+	MsvAvEOL             AvID = 0x0000
+	MsvAvNbComputerName  AvID = 0x0001
+	MsvAvNbDomainName    AvID = 0x0002
+	MsvAvDnsComputerName AvID = 0x0003
+	MsvAvDnsDomainName   AvID = 0x0004
+	MsvAvDnsTreeName     AvID = 0x0005
+	MsvAvFlags           AvID = 0x0006
+	MsvAvTimestamp       AvID = 0x0007
+	MsvAvSingleHost      AvID = 0x0008
+	MsvAvTargetName      AvID = 0x0009
+	MsvAvChannelBindings AvID = 0x000A
+)
+
+var avIDNames = map[AvID]string{
+	MsvAvEOL:             "MsvAvEOL",
+	MsvAvNbComputerName:  "MsvAvNbComputerName",
+	MsvAvNbDomainName:    "MsvAvNbDomainName",
+	MsvAvDnsComputerName: "MsvAvDnsComputerName",
+	MsvAvDnsDomainName:   "MsvAvDnsDomainName",
+	MsvAvDnsTreeName:     "MsvAvDnsTreeName",
+	MsvAvFlags:           "MsvAvFlags",
+	MsvAvTimestamp:       "MsvAvTimestamp",
+	MsvAvSingleHost:      "MsvAvSingleHost",
+	MsvAvTargetName:      "MsvAvTargetName",
+	MsvAvChannelBindings: "MsvAvChannelBindings",
+}
+
+// String returns the MS-NLMP name of the AvId, or its number when unknown.
+func (a AvID) String() string {
+	if name, ok := avIDNames[a]; ok {
+		return name
+	}
+	return fmt.Sprintf("0x%04x", uint16(a))
+}
+
+// AvPair is the decoded value of one AV_PAIR, the concrete type is picked by
+// AvID and AvRaw is used for unknown or malformed values.
+type AvPair interface {
+	AvID() AvID
+	String() string
+
+	// encodeValue returns the AV_PAIR Value, without AvId and AvLen
+	encodeValue() []byte
+}
+
+type AvEOL struct{}
+
+type AvNbComputerName string
+
+type AvNbDomainName string
+
+type AvDnsComputerName string
+
+type AvDnsDomainName string
+
+type AvDnsTreeName string
+
+// AvFlags is the MsvAvFlags bitmask.
+type AvFlags uint32
+
+const (
+	AvFlagsAccountAuthConstrained AvFlags = 0x1 // the account authentication is constrained
+	AvFlagsMICProvided            AvFlags = 0x2 // the client is providing a MIC
+	AvFlagsSPNUntrusted           AvFlags = 0x4 // the SPN was supplied by an untrusted source
+)
+
+type AvTimestamp struct {
+	Time time.Time
+}
+
+// AvSingleHost is the Single_Host_Data structure.
+//
+// reference: https://learn.microsoft.com/en-us/openspecs/windows_protocols/ms-nlmp/f221c061-cc40-4471-95da-d2ff71c85c5b
+type AvSingleHost struct {
+	Size       uint32
+	Z4         uint32
+	CustomData [8]byte
+	MachineID  [32]byte
+}
+
+type AvTargetName string
+
+// AvChannelBindings is the MD5 hash of a gss_channel_bindings_struct.
+type AvChannelBindings [16]byte
+
+// AvRaw keeps the raw value of an AV pair that couldn't be decoded.
+type AvRaw struct {
+	ID    AvID
+	Value []byte
+}
+
+func (a AvEOL) AvID() AvID             { return MsvAvEOL }
+func (a AvNbComputerName) AvID() AvID  { return MsvAvNbComputerName }
+func (a AvNbDomainName) AvID() AvID    { return MsvAvNbDomainName }
+func (a AvDnsComputerName) AvID() AvID { return MsvAvDnsComputerName }
+func (a AvDnsDomainName) AvID() AvID   { return MsvAvDnsDomainName }
+func (a AvDnsTreeName) AvID() AvID     { return MsvAvDnsTreeName }
+func (a AvFlags) AvID() AvID           { return MsvAvFlags }
+func (a AvTimestamp) AvID() AvID       { return MsvAvTimestamp }
+func (a AvSingleHost) AvID() AvID      { return MsvAvSingleHost }
+func (a AvTargetName) AvID() AvID      { return MsvAvTargetName }
+func (a AvChannelBindings) AvID() AvID { return MsvAvChannelBindings }
+func (a AvRaw) AvID() AvID             { return a.ID }
+
+func (a AvEOL) String() string             { return "" }
+func (a AvNbComputerName) String() string  { return string(a) }
+func (a AvNbDomainName) String() string    { return string(a) }
+func (a AvDnsComputerName) String() string { return string(a) }
+func (a AvDnsDomainName) String() string   { return string(a) }
+func (a AvDnsTreeName) String() string     { return string(a) }
+func (a AvFlags) String() string           { return fmt.Sprintf("0x%08x", uint32(a)) }
+func (a AvTimestamp) String() string {
+	return a.Time.UTC().Format(`2006-01-02T15:04:05.999Z`) // 2020-11-18T19:08:09.844Z
+}
+func (a AvSingleHost) String() string {
+	return fmt.Sprintf("custom-data=%x machine-id=%x", a.CustomData, a.MachineID)
+}
+func (a AvTargetName) String() string      { return string(a) }
+func (a AvChannelBindings) String() string { return hex.EncodeToString(a[:]) }
+func (a AvRaw) String() string             { return hex.EncodeToString(a.Value) }
+
+func (a AvEOL) encodeValue() []byte             { return nil }
+func (a AvNbComputerName) encodeValue() []byte  { return stringToUCS2(string(a)) }
+func (a AvNbDomainName) encodeValue() []byte    { return stringToUCS2(string(a)) }
+func (a AvDnsComputerName) encodeValue() []byte { return stringToUCS2(string(a)) }
+func (a AvDnsDomainName) encodeValue() []byte   { return stringToUCS2(string(a)) }
+func (a AvDnsTreeName) encodeValue() []byte     { return stringToUCS2(string(a)) }
+func (a AvFlags) encodeValue() []byte           { return binary.LittleEndian.AppendUint32(nil, uint32(a)) }
+func (a AvTimestamp) encodeValue() []byte {
+	return binary.LittleEndian.AppendUint64(nil, dateToFileTime(a.Time))
+}
+func (a AvSingleHost) encodeValue() []byte {
+	var result = binary.LittleEndian.AppendUint32(nil, a.Size)
+	result = binary.LittleEndian.AppendUint32(result, a.Z4)
+	result = append(result, a.CustomData[:]...)
+	return append(result, a.MachineID[:]...)
+}
+func (a AvTargetName) encodeValue() []byte      { return stringToUCS2(string(a)) }
+func (a AvChannelBindings) encodeValue() []byte { return append([]byte(nil), a[:]...) }
+func (a AvRaw) encodeValue() []byte             { return append([]byte(nil), a.Value...) }
+
+func (a AvFlags) Has(flag AvFlags) bool {
+	return a&flag == flag
+}
+
+// decodeAvPair falls back to AvRaw when the id is unknown or the value
+// doesn't have the length required by the spec.
+func decodeAvPair(id AvID, value []byte) AvPair {
+	switch id {
+	case MsvAvEOL:
+		if len(value) == 0 {
+			return AvEOL{}
+		}
+	case MsvAvNbComputerName:
+		return AvNbComputerName(bytesToUCS2(value))
+	case MsvAvNbDomainName:
+		return AvNbDomainName(bytesToUCS2(value))
+	case MsvAvDnsComputerName:
+		return AvDnsComputerName(bytesToUCS2(value))
+	case MsvAvDnsDomainName:
+		return AvDnsDomainName(bytesToUCS2(value))
+	case MsvAvDnsTreeName:
+		return AvDnsTreeName(bytesToUCS2(value))
+	case MsvAvFlags:
+		if len(value) == 4 {
+			return AvFlags(binary.LittleEndian.Uint32(value))
+		}
+	case MsvAvTimestamp:
+		if len(value) == 8 {
+			return AvTimestamp{Time: fileTimeToDate(binary.LittleEndian.Uint64(value))}
+		}
+	case MsvAvSingleHost:
+		if len(value) == 48 {
+			var result = AvSingleHost{
+				Size: binary.LittleEndian.Uint32(value[0:4]),
+				Z4:   binary.LittleEndian.Uint32(value[4:8]),
+			}
+			copy(result.CustomData[:], value[8:16])
+			copy(result.MachineID[:], value[16:48])
+			return result
+		}
+	case MsvAvTargetName:
+		return AvTargetName(bytesToUCS2(value))
+	case MsvAvChannelBindings:
+		if len(value) == 16 {
+			var result AvChannelBindings
+			copy(result[:], value)
+			return result
+		}
+	}
+
+	return AvRaw{ID: id, Value: append([]byte(nil), value...)}
+}
+
+// AvPairList is a decoded AV_PAIR list, see TargetInfo.
+type AvPairList []TargetInfo
+
+// Get returns the first AV pair with the given id.
+func (l AvPairList) Get(id AvID) (AvPair, bool) {
+	for _, info := range l {
+		if info.Value != nil && info.Value.AvID() == id {
+			return info.Value, true
+		}
+	}
+	return nil, false
+}
+
+func (l AvPairList) AvPairs() []AvPair {
+	var result []AvPair
+	for _, info := range l {
+		result = append(result, info.Value)
+	}
+	return result
+}
+
+func (l AvPairList) NbComputerName() (string, bool) {
+	return l.getString(MsvAvNbComputerName)
+}
+
+func (l AvPairList) NbDomainName() (string, bool) {
+	return l.getString(MsvAvNbDomainName)
+}
+
+func (l AvPairList) DnsComputerName() (string, bool) {
+	return l.getString(MsvAvDnsComputerName)
+}
+
+func (l AvPairList) DnsDomainName() (string, bool) {
+	return l.getString(MsvAvDnsDomainName)
+}
+
+func (l AvPairList) DnsTreeName() (string, bool) {
+	return l.getString(MsvAvDnsTreeName)
+}
+
+func (l AvPairList) TargetName() (string, bool) {
+	return l.getString(MsvAvTargetName)
+}
+
+func (l AvPairList) Flags() (AvFlags, bool) {
+	var v, ok = l.Get(MsvAvFlags)
+	var flags, valid = v.(AvFlags)
+	return flags, ok && valid
+}
+
+func (l AvPairList) Timestamp() (time.Time, bool) {
+	var v, ok = l.Get(MsvAvTimestamp)
+	var timestamp, valid = v.(AvTimestamp)
+	return timestamp.Time, ok && valid
+}
+
+func (l AvPairList) SingleHost() (AvSingleHost, bool) {
+	var v, ok = l.Get(MsvAvSingleHost)
+	var singleHost, valid = v.(AvSingleHost)
+	return singleHost, ok && valid
+}
+
+func (l AvPairList) ChannelBindings() (AvChannelBindings, bool) {
+	var v, ok = l.Get(MsvAvChannelBindings)
+	var channelBindings, valid = v.(AvChannelBindings)
+	return channelBindings, ok && valid
+}
+
+func (l AvPairList) getString(id AvID) (string, bool) {
+	var v, ok = l.Get(id)
+	if !ok {
+		return "", false
+	}
+	if _, raw := v.(AvRaw); raw {
+		return "", false
+	}
+	return v.String(), true
+}
@@ -0,0 +1,43 @@
+package ntlm_parser
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseAvPairs(t *testing.T) {
+	var buf = []byte{
+		0x06, 0x00, 0x04, 0x00, 0x02, 0x00, 0x00, 0x00, // MsvAvFlags
+		0x07, 0x00, 0x08, 0x00, 0x40, 0x7e, 0x94, 0x27, 0xde, 0xbd, 0xd6, 0x01, // MsvAvTimestamp
+		0x42, 0x00, 0x02, 0x00, 0xaa, 0xbb, // unknown
+		0x06, 0x00, 0x01, 0x00, 0xff, // malformed MsvAvFlags
+		0x00, 0x00, 0x00, 0x00, // MsvAvEOL
+		0x00, 0x00, 0x00, 0x00, // padding
+	}
+
+	var got, err = parseAvPairs(buf)
+	if err != nil {
+		t.Fatalf("parseAvPairs() error = %v", err)
+	}
+	var want = []AvPair{
+		AvFlags(AvFlagsMICProvided),
+		AvTimestamp{Time: time.Date(2020, 11, 18, 19, 8, 9, 844076800, time.UTC)},
+		AvRaw{ID: 0x42, Value: []byte{0xaa, 0xbb}},
+		AvRaw{ID: MsvAvFlags, Value: []byte{0xff}},
+		AvEOL{},
+	}
+	if !reflect.DeepEqual(got.AvPairs(), want) {
+		t.Errorf("parseAvPairs() got = %v, want %v", got.AvPairs(), want)
+	}
+
+	if flags, ok := got.Flags(); !ok || !flags.Has(AvFlagsMICProvided) {
+		t.Errorf("Flags() got = %v, %v", flags, ok)
+	}
+	if timestamp, ok := (NTLMType2{TargetInfoData: got}).Timestamp(); !ok || timestamp.Year() != 2020 {
+		t.Errorf("Timestamp() got = %v, %v", timestamp, ok)
+	}
+	if _, ok := got.DnsDomainName(); ok {
+		t.Errorf("DnsDomainName() ok = true, want false")
+	}
+}
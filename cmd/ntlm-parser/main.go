@@ -0,0 +1,241 @@
+// Command ntlm-parser decodes NTLM messages.
+//
+//	ntlm-parser [flags] [token ...]
+//
+// Every argument is a token, base64, hex or a whole Authorization or
+// WWW-Authenticate header line. Without arguments nor -file the tokens are
+// read from stdin, one per line, or as a single raw message when the input
+// starts with the NTLMSSP signature.
+//
+// The exit code is 0 when every token could be decoded, 1 when one of them
+// couldn't and 2 for usage errors.
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+
+	parser "github.com/wux1an/ntlm-parser"
+)
+
+const (
+	exitOK    = 0
+	exitParse = 1
+	exitUsage = 2
+)
+
+var formats = []string{"text", "json", "tree", "hexdump"}
+
+var encodings = []string{"auto", "base64", "hex", "raw"}
+
+type files []string
+
+func (f *files) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *files) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+type options struct {
+	format   string
+	encoding string
+	color    bool
+	parse    parser.ParseOptions
+}
+
+// input is one token to decode, source tells where it comes from in error
+// messages.
+type input struct {
+	source string
+	data   []byte
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	var flags = flag.NewFlagSet("ntlm-parser", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprintf(stderr, "usage: ntlm-parser [flags] [token ...]\n\n")
+		fmt.Fprintf(stderr, "Decodes NTLM messages given as arguments, in files or on stdin.\n\n")
+		flags.PrintDefaults()
+	}
+
+	var opts options
+	var inputFiles files
+	flags.StringVar(&opts.format, "format", "text", "output format: "+strings.Join(formats, ", "))
+	flags.StringVar(&opts.encoding, "encoding", "auto", "input encoding: "+strings.Join(encodings, ", "))
+	flags.BoolVar(&opts.color, "color", false, "color the hexdump output")
+	flags.BoolVar(&opts.parse.Lenient, "lenient", false, "decode what can be decoded and print warnings")
+	flags.Var(&inputFiles, "file", "read tokens from `path`, - for stdin, can be repeated")
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+	if !contains(formats, opts.format) {
+		fmt.Fprintf(stderr, "ntlm-parser: unknown format %q\n", opts.format)
+		return exitUsage
+	}
+	if !contains(encodings, opts.encoding) {
+		fmt.Fprintf(stderr, "ntlm-parser: unknown encoding %q\n", opts.encoding)
+		return exitUsage
+	}
+
+	var inputs []input
+	for i, arg := range flags.Args() {
+		inputs = append(inputs, input{source: fmt.Sprintf("argument %d", i+1), data: []byte(arg)})
+	}
+	if len(flags.Args()) == 0 && len(inputFiles) == 0 {
+		inputFiles = append(inputFiles, "-")
+	}
+	for _, path := range inputFiles {
+		var data, err = readFile(path, stdin)
+		if err != nil {
+			fmt.Fprintf(stderr, "ntlm-parser: %v\n", err)
+			return exitUsage
+		}
+		inputs = append(inputs, splitInput(path, data, opts.encoding)...)
+	}
+
+	var code = exitOK
+	for i, in := range inputs {
+		if i > 0 && opts.format != "json" {
+			fmt.Fprintln(stdout)
+		}
+		if err := decode(stdout, in, opts); err != nil {
+			fmt.Fprintf(stderr, "ntlm-parser: %s: %v\n", in.source, err)
+			code = exitParse
+		}
+	}
+	return code
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+func readFile(path string, stdin io.Reader) ([]byte, error) {
+	if path == "-" {
+		return io.ReadAll(stdin)
+	}
+	return os.ReadFile(path)
+}
+
+// splitInput returns one input per non-empty line, or the whole data for a
+// raw message.
+func splitInput(path string, data []byte, encoding string) []input {
+	var name = path
+	if path == "-" {
+		name = "stdin"
+	}
+	if encoding == "raw" || encoding == "auto" && bytes.HasPrefix(data, []byte("NTLMSSP\x00")) {
+		return []input{{source: name, data: data}}
+	}
+
+	var result []input
+	for i, line := range strings.Split(string(data), "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		result = append(result, input{source: fmt.Sprintf("%s line %d", name, i+1), data: []byte(line)})
+	}
+	return result
+}
+
+// headerLine matches an HTTP authentication header, or only its value, and
+// captures the token.
+var headerLine = regexp.MustCompile(`(?i)^\s*(?:(?:proxy-)?(?:authorization|www-authenticate|authenticate)\s*:\s*)?(?:ntlm|negotiate)\s+(\S+)\s*$`)
+
+// tokenBytes decodes a token with the given encoding, auto tries raw, hex
+// and then base64.
+func tokenBytes(data []byte, encoding string) ([]byte, error) {
+	if encoding == "raw" || encoding == "auto" && bytes.HasPrefix(data, []byte("NTLMSSP\x00")) {
+		return data, nil
+	}
+
+	var token = strings.TrimSpace(string(data))
+	if match := headerLine.FindStringSubmatch(token); match != nil {
+		token = match[1]
+	}
+
+	switch encoding {
+	case "hex":
+		return hex.DecodeString(token)
+	case "base64":
+		return decodeBase64(token)
+	}
+	if result, err := hex.DecodeString(token); err == nil {
+		return result, nil
+	}
+	if result, err := decodeBase64(token); err == nil {
+		return result, nil
+	}
+	return nil, errors.New("token is neither base64 nor hex")
+}
+
+// decodeBase64 accepts the standard and URL alphabets, with or without
+// padding.
+func decodeBase64(token string) ([]byte, error) {
+	var result, err = base64.StdEncoding.DecodeString(token)
+	if err == nil {
+		return result, nil
+	}
+	for _, encoding := range []*base64.Encoding{base64.RawStdEncoding, base64.URLEncoding, base64.RawURLEncoding} {
+		if result, err := encoding.DecodeString(token); err == nil {
+			return result, nil
+		}
+	}
+	return nil, err
+}
+
+func decode(w io.Writer, in input, opts options) error {
+	var data, err = tokenBytes(in.data, opts.encoding)
+	if err != nil {
+		return err
+	}
+	msg, err := opts.parse.FromBytes(data)
+	if err != nil {
+		return err
+	}
+
+	switch opts.format {
+	case "json":
+		var result, err = json.MarshalIndent(msg, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "%s\n", result)
+		return err
+	case "tree":
+		var root, err = parser.Dissect(msg)
+		if err != nil {
+			return err
+		}
+		return root.WriteText(w)
+	case "hexdump":
+		var root, err = parser.Dissect(msg)
+		if err != nil {
+			return err
+		}
+		return root.WriteHexdump(w, parser.HexdumpOptions{Color: opts.color})
+	}
+	return writeText(w, msg)
+}
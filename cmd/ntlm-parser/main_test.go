@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+const type1 = "TlRMTVNTUAABAAAABzIAAAYABgAzAAAACwALACgAAAAFAJMIAAAAD1dPUktTVEFUSU9ORE9NQUlO"
+
+func TestRun(t *testing.T) {
+	var raw, _ = base64.StdEncoding.DecodeString(type1)
+	tests := []struct {
+		name     string
+		args     []string
+		stdin    string
+		code     int
+		contains string
+	}{
+		{"base64 argument", []string{type1}, "", exitOK, "Workstation:  WORKSTATION"},
+		{"hex argument", []string{"4e544c4d53535000010000000732000006000600330000000b000b0028000000050093080000000f574f524b53544154494f4e444f4d41494e"}, "", exitOK, "Domain:       DOMAIN"},
+		{"header line", []string{"Authorization: NTLM " + type1}, "", exitOK, "NEGOTIATE_MESSAGE (type 1)"},
+		{"stdin lines", nil, "\nWWW-Authenticate: Negotiate " + type1 + "\n", exitOK, "Version:      Windows 2000 (5.0.2195.15)"},
+		{"raw stdin", nil, string(raw), exitOK, "Workstation:  WORKSTATION"},
+		{"tree", []string{"-format", "tree", type1}, "", exitOK, "0028   11    WorkstationName: WORKSTATION"},
+		{"hexdump", []string{"-format", "hexdump", type1}, "", exitOK, "DomainName\n"},
+		{"parse failure", []string{"TlRMTVNTUAABAAAA"}, "", exitParse, ""},
+		{"not a token", []string{"%%%"}, "", exitParse, ""},
+		{"unknown format", []string{"-format", "xml", type1}, "", exitUsage, ""},
+		{"missing file", []string{"-file", "does-not-exist"}, "", exitUsage, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			var code = run(tt.args, strings.NewReader(tt.stdin), &stdout, &stderr)
+			if code != tt.code {
+				t.Errorf("run() = %d, want %d, stderr %s", code, tt.code, stderr.String())
+			}
+			if !strings.Contains(stdout.String(), tt.contains) {
+				t.Errorf("run() stdout = %s, want %q", stdout.String(), tt.contains)
+			}
+			if (code != exitOK) != (stderr.Len() > 0) {
+				t.Errorf("run() stderr = %q", stderr.String())
+			}
+		})
+	}
+}
+
+func TestRunJSON(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"-format", "json", type1}, nil, &stdout, &stderr); code != exitOK {
+		t.Fatalf("run() = %d, stderr %s", code, stderr.String())
+	}
+	var got map[string]interface{}
+	if err := json.Unmarshal(stdout.Bytes(), &got); err != nil {
+		t.Fatalf("json.Unmarshal() error = %v", err)
+	}
+	if got["messageType"] != "NEGOTIATE_MESSAGE" || got["workstation"] != "WORKSTATION" {
+		t.Errorf("run() stdout = %s", stdout.String())
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	parser "github.com/wux1an/ntlm-parser"
+)
+
+// writeText writes a summary of msg, one field per line.
+func writeText(w io.Writer, msg parser.NTLMMessage) error {
+	var tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	var line = func(name string, value interface{}) {
+		fmt.Fprintf(tw, "  %s:\t%v\n", name, value)
+	}
+
+	var warnings []*parser.ParseError
+	switch msg := msg.(type) {
+	case *parser.NTLMType1:
+		fmt.Fprintln(tw, msg.MessageType)
+		line("Flags", flagsText(msg.Flags))
+		line("Domain", msg.SuppliedDomainData)
+		line("Workstation", msg.SuppliedWorkstationData)
+		line("Version", msg.OsVersionStructure.LongString())
+		warnings = msg.Warnings
+	case *parser.NTLMType2:
+		fmt.Fprintln(tw, msg.MessageType)
+		line("Flags", flagsText(msg.Flags))
+		line("Target name", msg.TargetNameData)
+		line("Server challenge", msg.Challenge)
+		for _, info := range msg.TargetInfoData {
+			if info.Value != nil && info.Value.AvID() != parser.MsvAvEOL {
+				line(info.Value.AvID().String(), info.Content)
+			}
+		}
+		line("Version", msg.OsVersionStructure.LongString())
+		warnings = msg.Warnings
+	case *parser.NTLMType3v1:
+		writeType3(tw, line, msg, nil, nil)
+		warnings = msg.Warnings
+	case *parser.NTLMType3v2:
+		writeType3(tw, line, &msg.NTLMType3v1, msg, nil)
+		warnings = msg.Warnings
+	case *parser.NTLMType3v3:
+		writeType3(tw, line, &msg.NTLMType3v1, &msg.NTLMType3v2, msg)
+		warnings = msg.Warnings
+	}
+
+	for _, warning := range warnings {
+		line("Warning", warning)
+	}
+	return tw.Flush()
+}
+
+func writeType3(w io.Writer, line func(string, interface{}), msg *parser.NTLMType3v1, v2 *parser.NTLMType3v2, v3 *parser.NTLMType3v3) {
+	fmt.Fprintf(w, "%s, NTLM version %d\n", msg.MessageType, msg.Version)
+	if v2 != nil {
+		line("Flags", flagsText(v2.Flags))
+	}
+	line("Domain", msg.TargetNameData)
+	line("User", msg.UserNameData)
+	line("Workstation", msg.WorkstationNameData)
+	line("LM response", msg.LmResponseData.Hex)
+	if ntlmv2 := msg.NtlmResponseData.NTLMv2Response; ntlmv2 != nil {
+		line("NTProofStr", ntlmv2.NTProofStr)
+		line("Client challenge", ntlmv2.ClientChallenge)
+		line("Timestamp", ntlmv2.Timestamp.UTC().Format("2006-01-02T15:04:05.9999999Z"))
+		for _, info := range ntlmv2.AvPairs {
+			if info.Value != nil && info.Value.AvID() != parser.MsvAvEOL {
+				line(info.Value.AvID().String(), info.Content)
+			}
+		}
+	} else {
+		line("NT response", msg.NtlmResponseData.Hex)
+	}
+	if v2 != nil {
+		line("Session key", v2.SessionKeyData.Hex)
+	}
+	if v3 != nil {
+		line("Version", v3.OsVersionStructure.LongString())
+		if v3.MIC != "" {
+			line("MIC", v3.MIC)
+		}
+	}
+}
+
+func flagsText(flags parser.NegotiateFlags) string {
+	return fmt.Sprintf("0x%08x %s", uint32(flags), flags)
+}
@@ -0,0 +1,43 @@
+package ntlm_parser
+
+import (
+	"testing"
+)
+
+func TestCodePageDecode(t *testing.T) {
+	tests := []struct {
+		name     string
+		codePage CodePage
+		data     []byte
+		want     string
+	}{
+		{name: "CP437", codePage: CP437, data: []byte{'M', 0x81, 'L', 'L', 'E', 'R'}, want: "MüLLER"},
+		{name: "CP850", codePage: CP850, data: []byte{'M', 0x9a, 'L', 'L', 'E', 'R'}, want: "MÜLLER"},
+		{name: "CP866", codePage: CP866, data: []byte{0x8c, 0xa8, 0xe0}, want: "Мир"},
+		{name: "CP1252", codePage: CP1252, data: []byte{'M', 0xdc, 'L', 'L', 'E', 'R', 0x80}, want: "MÜLLER€"},
+		{name: "unknown", codePage: 1, data: []byte{'M', 0x81}, want: "Mü"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.codePage.Decode(tt.data); got != tt.want {
+				t.Errorf("Decode() got = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseOptionsCodePage(t *testing.T) {
+	// NTLM Type 1 with the OEM domain "DOMAI\x81"
+	var str = "4e544c4d53535000010000000732000006000600330000000b000b0028000000050093080000000f574f524b53544154494f4e444f4d414981"
+
+	var msg, err = ParseOptions{CodePage: CP866}.FromHex(str)
+	if err != nil {
+		t.Fatalf("FromHex() error = %v", err)
+	}
+	if got := msg.(*NTLMType1).SuppliedDomainData; got != "DOMAIБ" {
+		t.Errorf("SuppliedDomainData got = %q, want %q", got, "DOMAIБ")
+	}
+	if got := msg.(*NTLMType1).SuppliedDomainRaw; string(got) != "DOMAI\x81" {
+		t.Errorf("SuppliedDomainRaw got = %q, want %q", got, "DOMAI\x81")
+	}
+}
@@ -2,7 +2,6 @@ package ntlm_parser
 
 import (
 	"encoding/binary"
-	"fmt"
 	"time"
 )
 
@@ -16,45 +15,7 @@ var (
 
 type NTLMMessage interface {
 	Parse(buffer []byte) (NTLMMessage, error)
-}
-
-// osVersionMap
-//
-// reference: https://learn.microsoft.com/en-us/windows/win32/sysinfo/operating-system-version
-// https://www.gaijin.at/en/infos/windows-version-numbers
-var osVersionMap = map[string]string{
-	"5.2.3790":   "Windows Server 2003",
-	"6.0.6001":   "Windows Server 2008",
-	"6.0.6002":   "Windows Server 2008",
-	"6.0.6003":   "Windows Server 2008",
-	"6.1.7600":   "Windows Server 2008",
-	"6.1.7601":   "Windows Server 2008",
-	"6.2.9200":   "Windows Server 2012",
-	"6.3.9600":   "Windows Server 2012",
-	"10.0.14393": "Windows Server 2016",
-	"10.0.17763": "Windows Server 2019",
-	"10.0.20348": "Windows Server 2022",
-}
-
-type OSVersionStructure struct {
-	MajorVersion int
-	MinorVersion int
-	BuildNumber  int
-	Unknown      int
-}
-
-func (o OSVersionStructure) LongString() string {
-	var v = fmt.Sprintf("%d.%d.%d", o.MajorVersion, o.MinorVersion, o.BuildNumber)
-	var vv = osVersionMap[v]
-	if vv == "" {
-		vv = "Other"
-	}
-
-	return fmt.Sprintf("%s (%s)", vv, o.ShortString())
-}
-
-func (o OSVersionStructure) ShortString() string {
-	return fmt.Sprintf("%d.%d.%d.%d", o.MajorVersion, o.MinorVersion, o.BuildNumber, o.Unknown)
+	Bytes() ([]byte, error)
 }
 
 type SecurityBuffer struct {
@@ -71,23 +32,47 @@ func getSecBuf(buf []byte, offset int) SecurityBuffer {
 	}
 }
 
-func getSecBufDataWithFlag(buf []byte, secBuf SecurityBuffer, decodeFlag uint32) string {
-	var u = decodeFlag | 0x1 // NTLMSSP_NEGOTIATE_UNICODE
-	if u != 1 {              // don't use unicode， use ucs2
-		return bytesToUCS2(buf[secBuf.Offset : secBuf.Offset+secBuf.Length])
+// within tells whether the buffer fits in a message of size bytes. Offsets
+// of 2^31 and more are negative on 32-bit platforms, so nothing is added
+// before the checks.
+func (b SecurityBuffer) within(size int) bool {
+	return b.Offset >= 0 && b.Length >= 0 && b.Offset <= size && b.Length <= size-b.Offset
+}
+
+// getSecBufDataWithFlag decodes the payload as UCS-2 when
+// NTLMSSP_NEGOTIATE_UNICODE is set and with the OEM code page otherwise,
+// the raw bytes are returned as well.
+func getSecBufDataWithFlag(data []byte, decodeFlag NegotiateFlags, codePage CodePage) (string, []byte) {
+	var raw = append([]byte(nil), data...)
+	if decodeFlag.Has(NTLMSSP_NEGOTIATE_UNICODE) {
+		return bytesToUCS2(data), raw
 	}
-	return string(buf[secBuf.Offset : secBuf.Offset+secBuf.Length])
+	return codePage.Decode(data), raw
 }
 
+// fileTimeToDate converts a FILETIME, the number of 100-nanosecond
+// intervals since January 1, 1601 (UTC).
 func fileTimeToDate(timestamp uint64) time.Time {
-	return time.UnixMilli(int64(timestamp/10000 - 11644473600000)).UTC()
+	var seconds = int64(timestamp/10000000) - 11644473600
+	var nanoseconds = int64(timestamp%10000000) * 100
+	return time.Unix(seconds, nanoseconds).UTC()
+}
+
+// dateToFileTime is the reverse of fileTimeToDate.
+func dateToFileTime(date time.Time) uint64 {
+	if date.IsZero() {
+		return 0
+	}
+	return uint64(date.Unix()+11644473600)*10000000 + uint64(date.Nanosecond()/100)
 }
 
 func getOSVersionStructure(buf []byte, offset int) OSVersionStructure {
-	return OSVersionStructure{
-		MajorVersion: int(buf[offset]),
-		MinorVersion: int(buf[offset+1]),
-		BuildNumber:  int(binary.LittleEndian.Uint16(buf[offset+2 : offset+4])),
-		Unknown:      int(binary.BigEndian.Uint32(buf[offset+4 : offset+8])),
+	var result = OSVersionStructure{
+		MajorVersion:        int(buf[offset]),
+		MinorVersion:        int(buf[offset+1]),
+		BuildNumber:         int(binary.LittleEndian.Uint16(buf[offset+2 : offset+4])),
+		NTLMRevisionCurrent: int(buf[offset+7]),
 	}
+	copy(result.Reserved[:], buf[offset+4:offset+7])
+	return result
 }
@@ -0,0 +1,259 @@
+package ntlm_parser
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// DCERPCPacketType is the PTYPE of a connection-oriented PDU.
+//
+// reference: https://pubs.opengroup.org/onlinepubs/9629399/chap12.htm
+type DCERPCPacketType uint8
+
+const (
+	DCERPC_REQUEST            DCERPCPacketType = 0
+	DCERPC_RESPONSE           DCERPCPacketType = 2
+	DCERPC_FAULT              DCERPCPacketType = 3
+	DCERPC_BIND               DCERPCPacketType = 11
+	DCERPC_BIND_ACK           DCERPCPacketType = 12
+	DCERPC_BIND_NAK           DCERPCPacketType = 13
+	DCERPC_ALTER_CONTEXT      DCERPCPacketType = 14
+	DCERPC_ALTER_CONTEXT_RESP DCERPCPacketType = 15
+	DCERPC_AUTH3              DCERPCPacketType = 16
+	DCERPC_SHUTDOWN           DCERPCPacketType = 17
+	DCERPC_CO_CANCEL          DCERPCPacketType = 18
+	DCERPC_ORPHANED           DCERPCPacketType = 19
+)
+
+var dcerpcPacketTypeNames = map[DCERPCPacketType]string{
+	DCERPC_REQUEST:            "request",
+	DCERPC_RESPONSE:           "response",
+	DCERPC_FAULT:              "fault",
+	DCERPC_BIND:               "bind",
+	DCERPC_BIND_ACK:           "bind_ack",
+	DCERPC_BIND_NAK:           "bind_nak",
+	DCERPC_ALTER_CONTEXT:      "alter_context",
+	DCERPC_ALTER_CONTEXT_RESP: "alter_context_resp",
+	DCERPC_AUTH3:              "auth3",
+	DCERPC_SHUTDOWN:           "shutdown",
+	DCERPC_CO_CANCEL:          "co_cancel",
+	DCERPC_ORPHANED:           "orphaned",
+}
+
+func (t DCERPCPacketType) String() string {
+	if name, ok := dcerpcPacketTypeNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("ptype(%d)", uint8(t))
+}
+
+type DCERPCFlags uint8
+
+const (
+	PFC_FIRST_FRAG          DCERPCFlags = 0x01
+	PFC_LAST_FRAG           DCERPCFlags = 0x02
+	PFC_PENDING_CANCEL      DCERPCFlags = 0x04
+	PFC_CONC_MPX            DCERPCFlags = 0x10
+	PFC_DID_NOT_EXECUTE     DCERPCFlags = 0x20
+	PFC_MAYBE               DCERPCFlags = 0x40
+	PFC_OBJECT_UUID         DCERPCFlags = 0x80
+	PFC_SUPPORT_HEADER_SIGN DCERPCFlags = PFC_PENDING_CANCEL // in bind and alter_context
+)
+
+func (f DCERPCFlags) String() string {
+	return bitNames(uint32(f), []bitName{
+		{uint32(PFC_FIRST_FRAG), "PFC_FIRST_FRAG"},
+		{uint32(PFC_LAST_FRAG), "PFC_LAST_FRAG"},
+		{uint32(PFC_PENDING_CANCEL), "PFC_PENDING_CANCEL"},
+		{uint32(PFC_CONC_MPX), "PFC_CONC_MPX"},
+		{uint32(PFC_DID_NOT_EXECUTE), "PFC_DID_NOT_EXECUTE"},
+		{uint32(PFC_MAYBE), "PFC_MAYBE"},
+		{uint32(PFC_OBJECT_UUID), "PFC_OBJECT_UUID"},
+	})
+}
+
+// DCERPCAuthType is the auth_type of a sec_trailer, only WINNT and
+// GSS_NEGOTIATE carry NTLM.
+//
+// reference: https://learn.microsoft.com/en-us/openspecs/windows_protocols/ms-rpce/d4097450-c62f-484b-872f-ddf59a7a0d36
+type DCERPCAuthType uint8
+
+const (
+	RPC_C_AUTHN_NONE          DCERPCAuthType = 0
+	RPC_C_AUTHN_GSS_NEGOTIATE DCERPCAuthType = 9
+	RPC_C_AUTHN_WINNT         DCERPCAuthType = 10
+	RPC_C_AUTHN_GSS_SCHANNEL  DCERPCAuthType = 14
+	RPC_C_AUTHN_GSS_KERBEROS  DCERPCAuthType = 16
+	RPC_C_AUTHN_NETLOGON      DCERPCAuthType = 68
+	RPC_C_AUTHN_DEFAULT       DCERPCAuthType = 255
+)
+
+var dcerpcAuthTypeNames = map[DCERPCAuthType]string{
+	RPC_C_AUTHN_NONE:          "RPC_C_AUTHN_NONE",
+	RPC_C_AUTHN_GSS_NEGOTIATE: "RPC_C_AUTHN_GSS_NEGOTIATE",
+	RPC_C_AUTHN_WINNT:         "RPC_C_AUTHN_WINNT",
+	RPC_C_AUTHN_GSS_SCHANNEL:  "RPC_C_AUTHN_GSS_SCHANNEL",
+	RPC_C_AUTHN_GSS_KERBEROS:  "RPC_C_AUTHN_GSS_KERBEROS",
+	RPC_C_AUTHN_NETLOGON:      "RPC_C_AUTHN_NETLOGON",
+	RPC_C_AUTHN_DEFAULT:       "RPC_C_AUTHN_DEFAULT",
+}
+
+func (t DCERPCAuthType) String() string {
+	if name, ok := dcerpcAuthTypeNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("0x%02x", uint8(t))
+}
+
+// DCERPCAuthLevel is the auth_level of a sec_trailer.
+//
+// reference: https://learn.microsoft.com/en-us/openspecs/windows_protocols/ms-rpce/425a7c53-c33a-4868-8e5b-2a850d40dc73
+type DCERPCAuthLevel uint8
+
+const (
+	RPC_C_AUTHN_LEVEL_DEFAULT       DCERPCAuthLevel = 0
+	RPC_C_AUTHN_LEVEL_NONE          DCERPCAuthLevel = 1
+	RPC_C_AUTHN_LEVEL_CONNECT       DCERPCAuthLevel = 2
+	RPC_C_AUTHN_LEVEL_CALL          DCERPCAuthLevel = 3
+	RPC_C_AUTHN_LEVEL_PKT           DCERPCAuthLevel = 4
+	RPC_C_AUTHN_LEVEL_PKT_INTEGRITY DCERPCAuthLevel = 5
+	RPC_C_AUTHN_LEVEL_PKT_PRIVACY   DCERPCAuthLevel = 6
+)
+
+var dcerpcAuthLevelNames = map[DCERPCAuthLevel]string{
+	RPC_C_AUTHN_LEVEL_DEFAULT:       "RPC_C_AUTHN_LEVEL_DEFAULT",
+	RPC_C_AUTHN_LEVEL_NONE:          "RPC_C_AUTHN_LEVEL_NONE",
+	RPC_C_AUTHN_LEVEL_CONNECT:       "RPC_C_AUTHN_LEVEL_CONNECT",
+	RPC_C_AUTHN_LEVEL_CALL:          "RPC_C_AUTHN_LEVEL_CALL",
+	RPC_C_AUTHN_LEVEL_PKT:           "RPC_C_AUTHN_LEVEL_PKT",
+	RPC_C_AUTHN_LEVEL_PKT_INTEGRITY: "RPC_C_AUTHN_LEVEL_PKT_INTEGRITY",
+	RPC_C_AUTHN_LEVEL_PKT_PRIVACY:   "RPC_C_AUTHN_LEVEL_PKT_PRIVACY",
+}
+
+func (l DCERPCAuthLevel) String() string {
+	if name, ok := dcerpcAuthLevelNames[l]; ok {
+		return name
+	}
+	return fmt.Sprintf("0x%02x", uint8(l))
+}
+
+// DCERPCPacket is one connection-oriented PDU. The sec_trailer fields are
+// only set when the PDU has an auth_value.
+//
+// In bind, bind_ack, alter_context, alter_context_resp and auth3 the
+// auth_value is the GSS token and the NTLM message is decoded from it. In
+// request and response it is the message signature, kept as is in
+// AuthValue.
+//
+// reference: https://pubs.opengroup.org/onlinepubs/9629399/chap12.htm
+type DCERPCPacket struct {
+	Type   DCERPCPacketType
+	Flags  DCERPCFlags
+	CallID uint32
+
+	// bind, bind_ack, alter_context and alter_context_resp
+	MaxXmitFrag  uint16
+	MaxRecvFrag  uint16
+	AssocGroupID uint32
+
+	// request
+	Opnum uint16
+
+	// sec_trailer
+	AuthType      DCERPCAuthType
+	AuthLevel     DCERPCAuthLevel
+	AuthContextID uint32
+	AuthValue     []byte
+
+	SPNEGO *SPNEGOToken
+	NTLM   NTLMMessage
+}
+
+const (
+	dcerpcHeaderSize     = 16
+	dcerpcSecTrailerSize = 8
+)
+
+// Signed tells whether the calls on this connection are at least integrity
+// protected.
+func (p *DCERPCPacket) Signed() bool {
+	return p.AuthLevel >= RPC_C_AUTHN_LEVEL_PKT_INTEGRITY
+}
+
+func ParseDCERPC(data []byte) ([]*DCERPCPacket, error) {
+	return ParseOptions{}.ParseDCERPC(data)
+}
+
+// ParseDCERPC decodes the PDUs of an ncacn_ip_tcp stream, or of the
+// named pipe reads and writes concatenated.
+func (o ParseOptions) ParseDCERPC(data []byte) ([]*DCERPCPacket, error) {
+	var result []*DCERPCPacket
+	for len(data) > 0 {
+		if len(data) < dcerpcHeaderSize {
+			return nil, fmt.Errorf("%w: header truncated", ErrBadDCERPC)
+		}
+		var order binary.ByteOrder = binary.BigEndian
+		if data[4]&0x10 != 0 {
+			order = binary.LittleEndian
+		}
+		var length = int(order.Uint16(data[8:]))
+		if length < dcerpcHeaderSize || length > len(data) {
+			return nil, fmt.Errorf("%w: frag_length %d out of range", ErrBadDCERPC, length)
+		}
+		var p, err = o.parseDCERPC(data[:length], order)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, p)
+		data = data[length:]
+	}
+	return result, nil
+}
+
+func (o ParseOptions) parseDCERPC(data []byte, order binary.ByteOrder) (*DCERPCPacket, error) {
+	if data[0] != 5 {
+		return nil, fmt.Errorf("%w: rpc_vers %d.%d", ErrBadDCERPC, data[0], data[1])
+	}
+	var p = &DCERPCPacket{
+		Type:   DCERPCPacketType(data[2]),
+		Flags:  DCERPCFlags(data[3]),
+		CallID: order.Uint32(data[12:]),
+	}
+
+	var body = data[dcerpcHeaderSize:]
+	if authLength := int(order.Uint16(data[10:])); authLength > 0 {
+		var trailer = len(data) - authLength - dcerpcSecTrailerSize
+		if trailer < dcerpcHeaderSize {
+			return nil, fmt.Errorf("%w: auth_length %d out of range", ErrBadDCERPC, authLength)
+		}
+		p.AuthType = DCERPCAuthType(data[trailer])
+		p.AuthLevel = DCERPCAuthLevel(data[trailer+1])
+		p.AuthContextID = order.Uint32(data[trailer+4:])
+		p.AuthValue = data[trailer+dcerpcSecTrailerSize:]
+		body = data[dcerpcHeaderSize:trailer]
+	}
+
+	switch p.Type {
+	case DCERPC_BIND, DCERPC_BIND_ACK, DCERPC_ALTER_CONTEXT, DCERPC_ALTER_CONTEXT_RESP:
+		if len(body) < 8 {
+			return nil, fmt.Errorf("%w: %s truncated", ErrBadDCERPC, p.Type)
+		}
+		p.MaxXmitFrag = order.Uint16(body[0:])
+		p.MaxRecvFrag = order.Uint16(body[2:])
+		p.AssocGroupID = order.Uint32(body[4:])
+	case DCERPC_REQUEST:
+		if len(body) < 8 {
+			return nil, fmt.Errorf("%w: %s truncated", ErrBadDCERPC, p.Type)
+		}
+		p.Opnum = order.Uint16(body[6:])
+		return p, nil
+	case DCERPC_AUTH3:
+	default:
+		return p, nil
+	}
+
+	if p.AuthType != RPC_C_AUTHN_WINNT && p.AuthType != RPC_C_AUTHN_GSS_NEGOTIATE {
+		return p, nil
+	}
+	return p, o.parseSecurityBlob(p.AuthValue, &p.SPNEGO, &p.NTLM)
+}
@@ -0,0 +1,140 @@
+package ntlm_parser
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+// dcerpcPDU builds a little-endian PDU, the body is padded to 4 bytes
+// before the sec_trailer.
+func dcerpcPDU(ptype DCERPCPacketType, callID uint32, body []byte, authType DCERPCAuthType, authLevel DCERPCAuthLevel, authValue []byte) []byte {
+	var header = []byte{5, 0, byte(ptype), byte(PFC_FIRST_FRAG | PFC_LAST_FRAG), 0x10, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	var result = append(header, body...)
+	if authValue != nil {
+		var pad = (4 - len(body)%4) % 4
+		result = append(result, make([]byte, pad)...)
+		result = append(result, byte(authType), byte(authLevel), byte(pad), 0, 1, 0, 0, 0)
+		result = append(result, authValue...)
+		binary.LittleEndian.PutUint16(result[10:], uint16(len(authValue)))
+	}
+	binary.LittleEndian.PutUint16(result[8:], uint16(len(result)))
+	binary.LittleEndian.PutUint32(result[12:], callID)
+	return result
+}
+
+func bindBody(assocGroup uint32) []byte {
+	var body = make([]byte, 8)
+	binary.LittleEndian.PutUint16(body[0:], 4280)
+	binary.LittleEndian.PutUint16(body[2:], 4280)
+	binary.LittleEndian.PutUint32(body[4:], assocGroup)
+	// p_context_elem with a single presentation context
+	return append(body, 1, 0, 0, 0, 0, 0, 1, 0)
+}
+
+func TestParseDCERPC(t *testing.T) {
+	var type1, _ = base64.StdEncoding.DecodeString(scanType1)
+	var type2, _ = base64.StdEncoding.DecodeString(scanType2)
+	var type3, _ = base64.StdEncoding.DecodeString(scanType3)
+	var request = []byte{0x10, 0, 0, 0, 0, 0, 0x0f, 0}
+	var verifier = append([]byte{1, 0, 0, 0}, make([]byte, 12)...)
+
+	tests := []struct {
+		name   string
+		stream [][]byte
+		types  []DCERPCPacketType
+		want   []NTLMMessageType
+		level  DCERPCAuthLevel
+	}{
+		{
+			name: "WINNT",
+			stream: [][]byte{
+				dcerpcPDU(DCERPC_BIND, 2, bindBody(0), RPC_C_AUTHN_WINNT, RPC_C_AUTHN_LEVEL_PKT_PRIVACY, type1),
+				dcerpcPDU(DCERPC_BIND_ACK, 2, bindBody(0x1234), RPC_C_AUTHN_WINNT, RPC_C_AUTHN_LEVEL_PKT_PRIVACY, type2),
+				dcerpcPDU(DCERPC_AUTH3, 2, make([]byte, 4), RPC_C_AUTHN_WINNT, RPC_C_AUTHN_LEVEL_PKT_PRIVACY, type3),
+				dcerpcPDU(DCERPC_REQUEST, 3, append(request, 0xaa), RPC_C_AUTHN_WINNT, RPC_C_AUTHN_LEVEL_PKT_PRIVACY, verifier),
+				dcerpcPDU(DCERPC_RESPONSE, 3, request, RPC_C_AUTHN_WINNT, RPC_C_AUTHN_LEVEL_PKT_PRIVACY, verifier),
+			},
+			types: []DCERPCPacketType{DCERPC_BIND, DCERPC_BIND_ACK, DCERPC_AUTH3, DCERPC_REQUEST, DCERPC_RESPONSE},
+			want:  []NTLMMessageType{NEGOTIATE_MESSAGE, CHALLENGE_MESSAGE, AUTHENTICATE_MESSAGE, "", ""},
+			level: RPC_C_AUTHN_LEVEL_PKT_PRIVACY,
+		},
+		{
+			name: "GSS_NEGOTIATE",
+			stream: [][]byte{
+				dcerpcPDU(DCERPC_ALTER_CONTEXT, 5, bindBody(0), RPC_C_AUTHN_GSS_NEGOTIATE, RPC_C_AUTHN_LEVEL_CONNECT, mustWrap(t, scanType1)),
+				dcerpcPDU(DCERPC_ALTER_CONTEXT_RESP, 5, bindBody(0x1234), RPC_C_AUTHN_GSS_NEGOTIATE, RPC_C_AUTHN_LEVEL_CONNECT, mustWrap(t, scanType2)),
+				dcerpcPDU(DCERPC_ALTER_CONTEXT, 6, bindBody(0x1234), RPC_C_AUTHN_GSS_NEGOTIATE, RPC_C_AUTHN_LEVEL_CONNECT, mustWrap(t, scanType3)),
+			},
+			types: []DCERPCPacketType{DCERPC_ALTER_CONTEXT, DCERPC_ALTER_CONTEXT_RESP, DCERPC_ALTER_CONTEXT},
+			want:  []NTLMMessageType{NEGOTIATE_MESSAGE, CHALLENGE_MESSAGE, AUTHENTICATE_MESSAGE},
+			level: RPC_C_AUTHN_LEVEL_CONNECT,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stream []byte
+			for _, pdu := range tt.stream {
+				stream = append(stream, pdu...)
+			}
+			var packets, err = ParseDCERPC(stream)
+			if err != nil {
+				t.Fatalf("ParseDCERPC() error = %v", err)
+			}
+			if len(packets) != len(tt.want) {
+				t.Fatalf("ParseDCERPC() got %d packets, want %d", len(packets), len(tt.want))
+			}
+			for i, p := range packets {
+				if got := messageType(p.NTLM); got != tt.want[i] {
+					t.Errorf("packet %d: NTLM = %s, want %s", i, got, tt.want[i])
+				}
+				if p.Type != tt.types[i] || p.AuthLevel != tt.level || p.AuthContextID != 1 {
+					t.Errorf("packet %d: got %s %s %d", i, p.Type, p.AuthLevel, p.AuthContextID)
+				}
+			}
+			if got := packets[1].AssocGroupID; got != 0x1234 {
+				t.Errorf("AssocGroupID = %#x", got)
+			}
+		})
+	}
+}
+
+func TestParseDCERPCRequest(t *testing.T) {
+	var verifier = append([]byte{1, 0, 0, 0}, make([]byte, 12)...)
+	var packets, err = ParseDCERPC(dcerpcPDU(DCERPC_REQUEST, 3, []byte{0x10, 0, 0, 0, 0, 0, 0x0f, 0, 0xaa}, RPC_C_AUTHN_WINNT, RPC_C_AUTHN_LEVEL_PKT_INTEGRITY, verifier))
+	if err != nil {
+		t.Fatalf("ParseDCERPC() error = %v", err)
+	}
+	var p = packets[0]
+	if p.Opnum != 15 || p.CallID != 3 || !p.Signed() || string(p.AuthValue) != string(verifier) || p.NTLM != nil {
+		t.Errorf("ParseDCERPC() got = %+v", p)
+	}
+}
+
+func TestParseDCERPCErrors(t *testing.T) {
+	var bind = dcerpcPDU(DCERPC_BIND, 1, bindBody(0), RPC_C_AUTHN_WINNT, RPC_C_AUTHN_LEVEL_CONNECT, []byte("NTLMSSP\x00"))
+	var badAuthLength = append([]byte(nil), bind...)
+	binary.LittleEndian.PutUint16(badAuthLength[10:], 0x100)
+	var badVersion = append([]byte(nil), bind...)
+	badVersion[0] = 4
+
+	tests := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{name: "truncated header", data: bind[:10], want: ErrBadDCERPC},
+		{name: "truncated PDU", data: bind[:len(bind)-1], want: ErrBadDCERPC},
+		{name: "auth_length out of range", data: badAuthLength, want: ErrBadDCERPC},
+		{name: "rpc_vers", data: badVersion, want: ErrBadDCERPC},
+		{name: "truncated NTLM message", data: bind, want: ErrTruncated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseDCERPC(tt.data); !errors.Is(err, tt.want) {
+				t.Errorf("ParseDCERPC() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,332 @@
+package ntlm_parser
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Field is one node of the tree returned by Dissect. Offset and Length are
+// relative to the start of the message, Raw holds the bytes of the field
+// and is nil for a single flag bit.
+type Field struct {
+	Name     string
+	Offset   int
+	Length   int
+	Raw      []byte
+	Value    string
+	Children []*Field
+}
+
+// Dissect lays out every field of msg the way Wireshark does, in a tree
+// of the header fields followed by the payload. An unmodified message is
+// dissected from the buffer it was parsed from, a modified one from Bytes
+// parsed again, so the tree always matches the bytes it shows.
+func Dissect(msg NTLMMessage) (*Field, error) {
+	var buf []byte
+	if unmodified(msg) {
+		buf = msg.(interface{ Original() []byte }).Original()
+	} else {
+		var err error
+		if buf, err = msg.Bytes(); err != nil {
+			return nil, err
+		}
+		// Lenient keeps dissecting what a lenient parse accepted
+		if msg, err = (ParseOptions{Lenient: true, MaxMessageSize: -1}).FromBytes(buf); err != nil {
+			return nil, err
+		}
+	}
+
+	var d = dissector{buf: buf}
+	var root *Field
+	switch msg := msg.(type) {
+	case *NTLMType1:
+		root = d.type1(msg)
+	case *NTLMType2:
+		root = d.type2(msg)
+	case *NTLMType3v1:
+		root = d.type3(msg, nil, nil)
+	case *NTLMType3v2:
+		root = d.type3(&msg.NTLMType3v1, msg, nil)
+	case *NTLMType3v3:
+		root = d.type3(&msg.NTLMType3v1, &msg.NTLMType3v2, msg)
+	default:
+		return nil, fmt.Errorf("dissect %T: unknown message", msg)
+	}
+	return root, nil
+}
+
+type dissector struct {
+	buf []byte
+}
+
+// field returns the node for [offset, offset+length), cut to the end of the
+// buffer. It is nil when the buffer doesn't reach offset.
+func (d dissector) field(name string, offset, length int, value string, children ...*Field) *Field {
+	if offset < 0 || offset > len(d.buf) || offset == len(d.buf) && length > 0 {
+		return nil
+	}
+	if offset+length > len(d.buf) {
+		length = len(d.buf) - offset
+	}
+	return &Field{
+		Name:     name,
+		Offset:   offset,
+		Length:   length,
+		Raw:      d.buf[offset : offset+length],
+		Value:    value,
+		Children: compact(children),
+	}
+}
+
+func compact(fields []*Field) []*Field {
+	var result []*Field
+	for _, f := range fields {
+		if f != nil {
+			result = append(result, f)
+		}
+	}
+	return result
+}
+
+func (d dissector) message(messageType NTLMMessageType, header, payload []*Field) *Field {
+	payload = compact(payload)
+	sort.SliceStable(payload, func(i, j int) bool {
+		return payload[i].Offset < payload[j].Offset
+	})
+
+	var fields = []*Field{
+		d.field("Signature", 0, 8, strconv.QuoteToASCII(string(d.buf[0:8]))),
+		d.field("MessageType", 8, 4, strconv.Itoa(int(binary.LittleEndian.Uint32(d.buf[8:12])))),
+	}
+	fields = append(fields, header...)
+	fields = append(fields, payload...)
+	return &Field{
+		Name:     string(messageType),
+		Length:   len(d.buf),
+		Raw:      d.buf,
+		Children: compact(fields),
+	}
+}
+
+func (d dissector) flags(offset int, flags NegotiateFlags) *Field {
+	var bits []*Field
+	for _, flag := range ntlmFlags {
+		var value = "Not set"
+		if flags.Has(flag.value) {
+			value = "Set"
+		}
+		bits = append(bits, &Field{
+			Name:   fmt.Sprintf("%s (0x%08x)", flag.label, uint32(flag.value)),
+			Offset: offset,
+			Length: 4,
+			Value:  value,
+		})
+	}
+	var result = d.field("NegotiateFlags", offset, 4, fmt.Sprintf("0x%08x", uint32(flags)))
+	if result != nil {
+		result.Children = bits
+	}
+	return result
+}
+
+// secBuf returns the node of the Len/MaxLen/BufferOffset fields at offset.
+func (d dissector) secBuf(name string, offset int, secBuf SecurityBuffer) *Field {
+	return d.field(name, offset, 8,
+		fmt.Sprintf("Length %d, Allocated %d, Offset %d", secBuf.Length, secBuf.Allocated, secBuf.Offset),
+		d.field("Length", offset, 2, strconv.Itoa(secBuf.Length)),
+		d.field("Allocated", offset+2, 2, strconv.Itoa(secBuf.Allocated)),
+		d.field("Offset", offset+4, 4, strconv.Itoa(secBuf.Offset)),
+	)
+}
+
+// payload returns the node of the data of a security buffer, nil when it is
+// empty.
+func (d dissector) payload(name string, secBuf SecurityBuffer, value string, children ...*Field) *Field {
+	if secBuf.Length == 0 {
+		return nil
+	}
+	return d.field(name, secBuf.Offset, secBuf.Length, value, children...)
+}
+
+func (d dissector) version(offset int, v OSVersionStructure) *Field {
+	return d.field("Version", offset, 8, v.LongString(),
+		d.field("ProductMajorVersion", offset, 1, strconv.Itoa(v.MajorVersion)),
+		d.field("ProductMinorVersion", offset+1, 1, strconv.Itoa(v.MinorVersion)),
+		d.field("ProductBuild", offset+2, 2, strconv.Itoa(v.BuildNumber)),
+		d.field("Reserved", offset+4, 3, hex.EncodeToString(v.Reserved[:])),
+		d.field("NTLMRevisionCurrent", offset+7, 1, fmt.Sprintf("0x%02x", v.NTLMRevisionCurrent)),
+	)
+}
+
+// avPairs returns a node per AV pair found in [offset, offset+length).
+func (d dissector) avPairs(offset, length int) []*Field {
+	var result []*Field
+	var end = offset + length
+	if end > len(d.buf) {
+		end = len(d.buf)
+	}
+	for offset+4 <= end {
+		var id = AvID(binary.LittleEndian.Uint16(d.buf[offset : offset+2]))
+		var valueLength = int(binary.LittleEndian.Uint16(d.buf[offset+2 : offset+4]))
+		if offset+4+valueLength > end {
+			break
+		}
+
+		var value = decodeAvPair(id, d.buf[offset+4:offset+4+valueLength])
+		result = append(result, d.field(id.String(), offset, 4+valueLength, value.String(),
+			d.field("AvId", offset, 2, fmt.Sprintf("0x%04x", uint16(id))),
+			d.field("AvLen", offset+2, 2, strconv.Itoa(valueLength)),
+			d.field("Value", offset+4, valueLength, value.String()),
+		))
+
+		offset += 4 + valueLength
+		if id == MsvAvEOL {
+			break
+		}
+	}
+	return result
+}
+
+func (d dissector) type1(msg *NTLMType1) *Field {
+	var header = []*Field{d.flags(12, msg.Flags)}
+	var payload []*Field
+	if len(d.buf) > 16 {
+		header = append(header,
+			d.secBuf("DomainNameFields", 16, msg.SuppliedDomain),
+			d.secBuf("WorkstationFields", 24, msg.SuppliedWorkstation),
+		)
+		payload = append(payload,
+			d.payload("DomainName", msg.SuppliedDomain, msg.SuppliedDomainData),
+			d.payload("WorkstationName", msg.SuppliedWorkstation, msg.SuppliedWorkstationData),
+		)
+	}
+	if msg.SuppliedDomain.Offset != 32 && len(d.buf) > 16 {
+		header = append(header, d.version(32, msg.OsVersionStructure))
+	}
+	return d.message(NEGOTIATE_MESSAGE, header, payload)
+}
+
+func (d dissector) type2(msg *NTLMType2) *Field {
+	var header = []*Field{
+		d.secBuf("TargetNameFields", 12, msg.TargetNameSecBuf),
+		d.flags(20, msg.Flags),
+		d.field("ServerChallenge", 24, 8, msg.Challenge),
+	}
+	var payload = []*Field{d.payload("TargetName", msg.TargetNameSecBuf, msg.TargetNameData)}
+
+	var offset = msg.TargetNameSecBuf.Offset
+	if offset != 32 {
+		header = append(header,
+			d.field("Reserved", 32, 8, msg.Context),
+			d.secBuf("TargetInfoFields", 40, msg.TargetInfoSecBuf),
+		)
+		payload = append(payload, d.payload("TargetInfo", msg.TargetInfoSecBuf,
+			fmt.Sprintf("%d AV pairs", len(msg.TargetInfoData)),
+			d.avPairs(msg.TargetInfoSecBuf.Offset, msg.TargetInfoSecBuf.Length)...,
+		))
+	}
+	if offset != 32 && offset != 48 {
+		header = append(header, d.version(48, msg.OsVersionStructure))
+	}
+	return d.message(CHALLENGE_MESSAGE, header, payload)
+}
+
+// type3 dissects an AUTHENTICATE message, v2 and v3 are nil for the older
+// versions.
+func (d dissector) type3(msg *NTLMType3v1, v2 *NTLMType3v2, v3 *NTLMType3v3) *Field {
+	var header = []*Field{
+		d.secBuf("LmChallengeResponseFields", 12, msg.LmResponse),
+		d.secBuf("NtChallengeResponseFields", 20, msg.NtlmResponse),
+		d.secBuf("DomainNameFields", 28, msg.TargetName),
+		d.secBuf("UserNameFields", 36, msg.UserName),
+		d.secBuf("WorkstationFields", 44, msg.WorkstationName),
+	}
+	var payload = []*Field{
+		d.payload("LmChallengeResponse", msg.LmResponse, msg.LmResponseData.Hex),
+		d.ntResponse(msg.NtlmResponse, msg.NtlmResponseData),
+		d.payload("DomainName", msg.TargetName, msg.TargetNameData),
+		d.payload("UserName", msg.UserName, msg.UserNameData),
+		d.payload("Workstation", msg.WorkstationName, msg.WorkstationNameData),
+	}
+
+	if v2 != nil {
+		header = append(header,
+			d.secBuf("EncryptedRandomSessionKeyFields", 52, v2.SessionKey),
+			d.flags(60, v2.Flags),
+		)
+		payload = append(payload, d.payload("EncryptedRandomSessionKey", v2.SessionKey, v2.SessionKeyData.Hex))
+	}
+	if v3 != nil {
+		header = append(header, d.version(64, v3.OsVersionStructure))
+		if v3.MIC != "" {
+			header = append(header, d.field("MIC", 72, 16, v3.MIC))
+		}
+	}
+	return d.message(AUTHENTICATE_MESSAGE, header, payload)
+}
+
+func (d dissector) ntResponse(secBuf SecurityBuffer, data NTLMResponseData) *Field {
+	var ntlmv2 = data.NTLMv2Response
+	if ntlmv2 == nil {
+		return d.payload("NtChallengeResponse", secBuf, data.Hex)
+	}
+
+	var offset = secBuf.Offset
+	return d.payload("NtChallengeResponse", secBuf, "NTLMv2 response",
+		d.field("NTProofStr", offset, 16, ntlmv2.NTProofStr),
+		d.field("RespType", offset+16, 1, strconv.Itoa(ntlmv2.RespType)),
+		d.field("HiRespType", offset+17, 1, strconv.Itoa(ntlmv2.HiRespType)),
+		d.field("Reserved1", offset+18, 6, hex.EncodeToString(d.buf[offset+18:offset+24])),
+		d.field("TimeStamp", offset+24, 8, ntlmv2.Timestamp.UTC().Format("2006-01-02T15:04:05.9999999Z")),
+		d.field("ChallengeFromClient", offset+32, 8, ntlmv2.ClientChallenge),
+		d.field("Reserved2", offset+40, 4, hex.EncodeToString(d.buf[offset+40:offset+44])),
+		d.field("AvPairs", offset+44, secBuf.Length-44, fmt.Sprintf("%d AV pairs", len(ntlmv2.AvPairs)),
+			d.avPairs(offset+44, secBuf.Length-44)...),
+	)
+}
+
+// maxRawText is the number of bytes of Raw written per line by WriteText.
+const maxRawText = 16
+
+// WriteText renders the tree, one field per line with its offset, length,
+// the start of its raw bytes and its decoded value.
+func (f *Field) WriteText(w io.Writer) error {
+	return f.writeText(w, 0)
+}
+
+func (f *Field) writeText(w io.Writer, depth int) error {
+	var line = fmt.Sprintf("%04x %4d  %s%s", f.Offset, f.Length, strings.Repeat("  ", depth), f.Name)
+	if f.Value != "" {
+		line += ": " + f.Value
+	}
+	// the raw bytes are left out for the whole message and for values that
+	// are already written in hex
+	if f.Raw != nil && depth > 0 && f.Value != hex.EncodeToString(f.Raw) {
+		var raw = hex.EncodeToString(f.Raw)
+		if len(f.Raw) > maxRawText {
+			raw = hex.EncodeToString(f.Raw[:maxRawText]) + "..."
+		}
+		line += " [" + raw + "]"
+	}
+	if _, err := fmt.Fprintln(w, line); err != nil {
+		return err
+	}
+
+	for _, child := range f.Children {
+		if err := child.writeText(w, depth+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *Field) String() string {
+	var b strings.Builder
+	f.WriteText(&b)
+	return b.String()
+}
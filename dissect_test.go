@@ -0,0 +1,144 @@
+package ntlm_parser
+
+import (
+	"strings"
+	"testing"
+)
+
+// findField follows the path of names from f.
+func findField(f *Field, path ...string) *Field {
+	for _, name := range path {
+		var next *Field
+		for _, child := range f.Children {
+			if child.Name == name {
+				next = child
+				break
+			}
+		}
+		if next == nil {
+			return nil
+		}
+		f = next
+	}
+	return f
+}
+
+const dissectType3 = "TlRMTVNTUAADAAAAGAAYAHQAAAAiASIBjAAAAAAAAABYAAAADAAMAFgAAAAQABAAZAAAABAAEACuAQAANYKI4goAukcAAAAP1KMCweXeFIr6zmSmiHFWSWoAbABvAHUAaQBzAEMASABPAFUAQwBIAE8AVQAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAC5/Vhnk2GTLD131k8cNfZcAQEAAAAAAADSVClUh73WAX873ENT+QbPAAAAAAIABgBKAEwARwABABAAQwBIAE8AVQBDAEgATwBVAAQAEgBqAGwAZwAuAGwAbwBjAGEAbAADACQAYwBoAG8AdQBjAGgAbwB1AC4AagBsAGcALgBsAG8AYwBhAGwABQASAGoAbABnAC4AbABvAGMAYQBsAAcACADSVClUh73WAQYABAACAAAACAAwADAAAAAAAAAAAQAAAAAgAAC4YcwjyK/gKSgZikWqPXs8y5udtMrVNidXg4R7uFJFPgoAEAAAAAAAAAAAAAAAAAAAAAAACQAcAEgAVABUAFAALwBsAG8AYwBhAGwAaABvAHMAdAAAAAAAAAAAAAG7NbE8iPK1v5zqEu20+5Q="
+
+func TestDissect(t *testing.T) {
+	var msg, err = FromBase64(dissectType3)
+	if err != nil {
+		t.Fatalf("FromBase64() error = %v", err)
+	}
+	root, err := Dissect(msg)
+	if err != nil {
+		t.Fatalf("Dissect() error = %v", err)
+	}
+
+	tests := []struct {
+		path   []string
+		offset int
+		length int
+		value  string
+	}{
+		{[]string{"UserNameFields", "Offset"}, 40, 4, "88"},
+		{[]string{"UserName"}, 88, 12, "jlouis"},
+		{[]string{"NegotiateFlags", "NTLMSSP_NEGOTIATE_KEY_EXCH (0x40000000)"}, 60, 4, "Set"},
+		{[]string{"Version", "ProductBuild"}, 66, 2, "18362"},
+		{[]string{"MIC"}, 72, 16, "d4a302c1e5de148aface64a688715649"},
+		{[]string{"NtChallengeResponse", "AvPairs", "MsvAvTargetName", "Value"}, 394, 28, "HTTP/localhost"},
+		{[]string{"EncryptedRandomSessionKey"}, 430, 16, "01bb35b13c88f2b5bf9cea12edb4fb94"},
+	}
+	for _, tt := range tests {
+		var f = findField(root, tt.path...)
+		if f == nil {
+			t.Errorf("Dissect() has no %v", tt.path)
+			continue
+		}
+		if f.Offset != tt.offset || f.Length != tt.length || f.Value != tt.value || f.Raw != nil && len(f.Raw) != tt.length {
+			t.Errorf("Dissect() %v got = %d %d %q, want %d %d %q", tt.path, f.Offset, f.Length, f.Value, tt.offset, tt.length, tt.value)
+		}
+	}
+}
+
+func TestDissectModified(t *testing.T) {
+	var msg, err = FromBase64(dissectType3)
+	if err != nil {
+		t.Fatalf("FromBase64() error = %v", err)
+	}
+	var type3 = msg.(*NTLMType3v3)
+	type3.UserNameData = "administrator"
+
+	root, err := Dissect(type3)
+	if err != nil {
+		t.Fatalf("Dissect() error = %v", err)
+	}
+	var f = findField(root, "UserName")
+	if f == nil || f.Offset != 446 || f.Length != 26 || f.Value != "administrator" || len(f.Raw) != 26 {
+		t.Errorf("Dissect() UserName got = %+v", f)
+	}
+	if f = findField(root, "UserNameFields", "Offset"); f == nil || f.Value != "446" {
+		t.Errorf("Dissect() UserNameFields.Offset got = %+v", f)
+	}
+}
+
+func TestDissectTruncated(t *testing.T) {
+	// NTLM Type 1 cut after "DO", the DomainName claims 6 bytes
+	var msg, err = ParseOptions{Lenient: true}.FromHex("4e544c4d53535000010000000732000006000600330000000b000b0028000000050093080000000f574f524b53544154494f4e444f")
+	if err != nil {
+		t.Fatalf("FromHex() error = %v", err)
+	}
+	root, err := Dissect(msg)
+	if err != nil {
+		t.Fatalf("Dissect() error = %v", err)
+	}
+	var f = findField(root, "DomainName")
+	if f == nil || f.Offset != 51 || f.Length != 2 || f.Value != "DO" {
+		t.Errorf("Dissect() DomainName got = %+v", f)
+	}
+	if f = findField(root, "DomainNameFields", "Length"); f == nil || f.Value != "6" {
+		t.Errorf("Dissect() DomainNameFields.Length got = %+v", f)
+	}
+}
+
+func TestDissectText(t *testing.T) {
+	var msg = &NTLMType2{
+		Flags:          NTLMSSP_NEGOTIATE_UNICODE,
+		Challenge:      "0123456789abcdef",
+		TargetNameData: "D",
+		TargetInfoData: AvPairList{{Value: AvEOL{}}},
+	}
+	root, err := Dissect(msg)
+	if err != nil {
+		t.Fatalf("Dissect() error = %v", err)
+	}
+
+	var lines = strings.Split(root.String(), "\n")
+	var want = []string{
+		`0000   54  CHALLENGE_MESSAGE (type 2)`,
+		`0000    8    Signature: "NTLMSSP\x00" [4e544c4d53535000]`,
+		`0008    4    MessageType: 2 [02000000]`,
+		`000c    8    TargetNameFields: Length 2, Allocated 2, Offset 48 [0200020030000000]`,
+		`000c    2      Length: 2 [0200]`,
+		`000e    2      Allocated: 2 [0200]`,
+		`0010    4      Offset: 48 [30000000]`,
+		`0014    4    NegotiateFlags: 0x00000001 [01000000]`,
+		`0014    4      NTLMSSP_NEGOTIATE_UNICODE (0x00000001): Set`,
+	}
+	if len(lines) < len(want) || strings.Join(lines[:len(want)], "\n") != strings.Join(want, "\n") {
+		t.Errorf("String() got = %s, want it to start with %s", root, strings.Join(want, "\n"))
+	}
+
+	var wantTail = []string{
+		`0030    2    TargetName: D [4400]`,
+		`0032    4    TargetInfo: 1 AV pairs [00000000]`,
+		`0032    4      MsvAvEOL [00000000]`,
+		`0032    2        AvId: 0x0000 [0000]`,
+		`0034    2        AvLen: 0 [0000]`,
+		`0036    0        Value`,
+		``,
+	}
+	if got := lines[len(lines)-len(wantTail):]; strings.Join(got, "\n") != strings.Join(wantTail, "\n") {
+		t.Errorf("String() got = %s, want it to end with %s", strings.Join(got, "\n"), strings.Join(wantTail, "\n"))
+	}
+}
@@ -0,0 +1,47 @@
+package ntlm_parser
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrTruncated          = errors.New("message truncated")
+	ErrBadSignature       = errors.New("bad signature, expected NTLMSSP\\0")
+	ErrUnknownMessageType = errors.New("unknown message type")
+	ErrBufferOutOfRange   = errors.New("security buffer out of range")
+	ErrMessageTooLarge    = errors.New("message larger than MaxMessageSize")
+
+	ErrNoNTLMToken     = errors.New("no NTLM or Negotiate token")
+	ErrKerberosToken   = errors.New("negotiate token carries Kerberos, not NTLM")
+	ErrBadAuthenticate = errors.New("malformed authentication header")
+
+	ErrBadCapture = errors.New("not a pcap or pcapng capture")
+	ErrBadSMB     = errors.New("malformed SMB packet")
+	ErrBadLDAP    = errors.New("malformed LDAP message")
+	ErrBadDCERPC  = errors.New("malformed DCE/RPC PDU")
+)
+
+// ParseError tells which field of which message couldn't be parsed, the
+// cause is one of the Err* sentinels and works with errors.Is.
+type ParseError struct {
+	MessageType NTLMMessageType // empty when the message type isn't known yet
+	Field       string
+	Offset      int
+	Err         error
+}
+
+func (e *ParseError) Error() string {
+	var msg = "invalid ntlm message"
+	if e.MessageType != "" {
+		msg += " " + string(e.MessageType)
+	}
+	if e.Field != "" {
+		msg += fmt.Sprintf(": %s at offset %d", e.Field, e.Offset)
+	}
+	return msg + ": " + e.Err.Error()
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
@@ -1,57 +1,175 @@
 package ntlm_parser
 
 import (
+	"encoding/json"
+	"errors"
 	"strings"
 )
 
+// NegotiateFlags
+//
+// reference: https://learn.microsoft.com/en-us/openspecs/windows_protocols/ms-nlmp/99d90ff4-957f-4c8a-80e4-5bfe5a9a9832
+type NegotiateFlags uint32
+
+const (
+	NTLMSSP_NEGOTIATE_UNICODE                  NegotiateFlags = 0x1        // A
+	NTLMSSP_NEGOTIATE_OEM                      NegotiateFlags = 0x2        // B
+	NTLMSSP_REQUEST_TARGET                     NegotiateFlags = 0x4        // C
+	NTLMSSP_RESERVED_R10                       NegotiateFlags = 0x8        // r10 (0)
+	NTLMSSP_NEGOTIATE_SIGN                     NegotiateFlags = 0x10       // D
+	NTLMSSP_NEGOTIATE_SEAL                     NegotiateFlags = 0x20       // E
+	NTLMSSP_NEGOTIATE_DATAGRAM                 NegotiateFlags = 0x40       // F
+	NTLMSSP_NEGOTIATE_LM_KEY                   NegotiateFlags = 0x80       // G
+	NTLMSSP_RESERVED_R9                        NegotiateFlags = 0x100      // r9 (0)
+	NTLMSSP_NEGOTIATE_NTLM                     NegotiateFlags = 0x200      // H
+	NTLMSSP_RESERVED_R8                        NegotiateFlags = 0x400      // r8 (0)
+	NTLMSSP_ANONYMOUS                          NegotiateFlags = 0x800      // J
+	NTLMSSP_NEGOTIATE_OEM_DOMAIN_SUPPLIED      NegotiateFlags = 0x1000     // K
+	NTLMSSP_NEGOTIATE_OEM_WORKSTATION_SUPPLIED NegotiateFlags = 0x2000     // L
+	NTLMSSP_RESERVED_R7                        NegotiateFlags = 0x4000     // r7 (0)
+	NTLMSSP_NEGOTIATE_ALWAYS_SIGN              NegotiateFlags = 0x8000     // M
+	NTLMSSP_TARGET_TYPE_DOMAIN                 NegotiateFlags = 0x10000    // N
+	NTLMSSP_TARGET_TYPE_SERVER                 NegotiateFlags = 0x20000    // O
+	NTLMSSP_RESERVED_R6                        NegotiateFlags = 0x40000    // r6 (0)
+	NTLMSSP_NEGOTIATE_EXTENDED_SESSIONSECURITY NegotiateFlags = 0x80000    // P
+	NTLMSSP_NEGOTIATE_IDENTIFY                 NegotiateFlags = 0x100000   // Q
+	NTLMSSP_RESERVED_R5                        NegotiateFlags = 0x200000   // r5 (0)
+	NTLMSSP_REQUEST_NON_NT_SESSION_KEY         NegotiateFlags = 0x400000   // R
+	NTLMSSP_NEGOTIATE_TARGET_INFO              NegotiateFlags = 0x800000   // S
+	NTLMSSP_RESERVED_R4                        NegotiateFlags = 0x1000000  // r4 (0)
+	NTLMSSP_NEGOTIATE_VERSION                  NegotiateFlags = 0x2000000  // T
+	NTLMSSP_RESERVED_R3                        NegotiateFlags = 0x4000000  // r3 (0)
+	NTLMSSP_RESERVED_R2                        NegotiateFlags = 0x8000000  // r2 (0)
+	NTLMSSP_RESERVED_R1                        NegotiateFlags = 0x10000000 // r1 (0)
+	NTLMSSP_NEGOTIATE_128                      NegotiateFlags = 0x20000000 // U
+	NTLMSSP_NEGOTIATE_KEY_EXCH                 NegotiateFlags = 0x40000000 // V
+	NTLMSSP_NEGOTIATE_56                       NegotiateFlags = 0x80000000 // W
+)
+
 type Flag struct {
 	label string
-	value uint32
+	value NegotiateFlags
 }
 
 var ntlmFlags = []Flag{
-	{value: 0x1, label: "NTLMSSP_NEGOTIATE_UNICODE"},                      // A
-	{value: 0x2, label: "NTLMSSP_NEGOTIATE_OEM"},                          // B
-	{value: 0x4, label: "NTLMSSP_REQUEST_TARGET"},                         // C
-	{value: 0x8, label: "R10"},                                            // r10 (0)
-	{value: 0x10, label: "NTLMSSP_NEGOTIATE_SIGN"},                        // D
-	{value: 0x20, label: "NTLMSSP_NEGOTIATE_SEAL"},                        // E
-	{value: 0x40, label: "NTLMSSP_NEGOTIATE_DATAGRAM"},                    // F
-	{value: 0x80, label: "NTLMSSP_NEGOTIATE_LM_KEY"},                      // G
-	{value: 0x100, label: "R9"},                                           // r9 (0)
-	{value: 0x200, label: "NTLMSSP_NEGOTIATE_NTLM"},                       // H
-	{value: 0x400, label: "R8"},                                           // r8 (0)
-	{value: 0x800, label: "ANONYMOUS_J"},                                  // J
-	{value: 0x1000, label: "NTLMSSP_NEGOTIATE_OEM_DOMAIN_SUPPLIED"},       // K
-	{value: 0x2000, label: "NTLMSSP_NEGOTIATE_OEM_WORKSTATION_SUPPLIED"},  // L
-	{value: 0x4000, label: "R7"},                                          // r7 (0)
-	{value: 0x8000, label: "NTLMSSP_NEGOTIATE_ALWAYS_SIGN"},               // M
-	{value: 0x10000, label: "NTLMSSP_TARGET_TYPE_DOMAIN"},                 // N
-	{value: 0x20000, label: "NTLMSSP_TARGET_TYPE_SERVER"},                 // O
-	{value: 0x40000, label: "R6"},                                         // r6 (0)
-	{value: 0x80000, label: "NTLMSSP_NEGOTIATE_EXTENDED_SESSIONSECURITY"}, // P
-	{value: 0x100000, label: "NTLMSSP_NEGOTIATE_IDENTIFY"},                // Q
-	{value: 0x200000, label: "R5"},                                        // r5 (0)
-	{value: 0x400000, label: "NTLMSSP_REQUEST_NON_NT_SESSION_KEY"},        // R
-	{value: 0x800000, label: "NTLMSSP_NEGOTIATE_TARGET_INFO"},             // S
-	{value: 0x1000000, label: "R4"},                                       // r4 (0)
-	{value: 0x2000000, label: "NTLMSSP_NEGOTIATE_VERSION"},                // T
-	{value: 0x4000000, label: "R3"},                                       // r3 (0)
-	{value: 0x8000000, label: "R2"},                                       // r2 (0)
-	{value: 0x10000000, label: "R1"},                                      // r1 (0)
-	{value: 0x20000000, label: "NTLMSSP_NEGOTIATE_128"},                   // U
-	{value: 0x40000000, label: "NTLMSSP_NEGOTIATE_KEY_EXCH"},              // V
-	{value: 0x80000000, label: "NTLMSSP_NEGOTIATE_56"},                    // W
-}
-
-func getFlags(value uint32) string {
+	{value: NTLMSSP_NEGOTIATE_UNICODE, label: "NTLMSSP_NEGOTIATE_UNICODE"},
+	{value: NTLMSSP_NEGOTIATE_OEM, label: "NTLMSSP_NEGOTIATE_OEM"},
+	{value: NTLMSSP_REQUEST_TARGET, label: "NTLMSSP_REQUEST_TARGET"},
+	{value: NTLMSSP_RESERVED_R10, label: "NTLMSSP_RESERVED_R10"},
+	{value: NTLMSSP_NEGOTIATE_SIGN, label: "NTLMSSP_NEGOTIATE_SIGN"},
+	{value: NTLMSSP_NEGOTIATE_SEAL, label: "NTLMSSP_NEGOTIATE_SEAL"},
+	{value: NTLMSSP_NEGOTIATE_DATAGRAM, label: "NTLMSSP_NEGOTIATE_DATAGRAM"},
+	{value: NTLMSSP_NEGOTIATE_LM_KEY, label: "NTLMSSP_NEGOTIATE_LM_KEY"},
+	{value: NTLMSSP_RESERVED_R9, label: "NTLMSSP_RESERVED_R9"},
+	{value: NTLMSSP_NEGOTIATE_NTLM, label: "NTLMSSP_NEGOTIATE_NTLM"},
+	{value: NTLMSSP_RESERVED_R8, label: "NTLMSSP_RESERVED_R8"},
+	{value: NTLMSSP_ANONYMOUS, label: "NTLMSSP_ANONYMOUS"},
+	{value: NTLMSSP_NEGOTIATE_OEM_DOMAIN_SUPPLIED, label: "NTLMSSP_NEGOTIATE_OEM_DOMAIN_SUPPLIED"},
+	{value: NTLMSSP_NEGOTIATE_OEM_WORKSTATION_SUPPLIED, label: "NTLMSSP_NEGOTIATE_OEM_WORKSTATION_SUPPLIED"},
+	{value: NTLMSSP_RESERVED_R7, label: "NTLMSSP_RESERVED_R7"},
+	{value: NTLMSSP_NEGOTIATE_ALWAYS_SIGN, label: "NTLMSSP_NEGOTIATE_ALWAYS_SIGN"},
+	{value: NTLMSSP_TARGET_TYPE_DOMAIN, label: "NTLMSSP_TARGET_TYPE_DOMAIN"},
+	{value: NTLMSSP_TARGET_TYPE_SERVER, label: "NTLMSSP_TARGET_TYPE_SERVER"},
+	{value: NTLMSSP_RESERVED_R6, label: "NTLMSSP_RESERVED_R6"},
+	{value: NTLMSSP_NEGOTIATE_EXTENDED_SESSIONSECURITY, label: "NTLMSSP_NEGOTIATE_EXTENDED_SESSIONSECURITY"},
+	{value: NTLMSSP_NEGOTIATE_IDENTIFY, label: "NTLMSSP_NEGOTIATE_IDENTIFY"},
+	{value: NTLMSSP_RESERVED_R5, label: "NTLMSSP_RESERVED_R5"},
+	{value: NTLMSSP_REQUEST_NON_NT_SESSION_KEY, label: "NTLMSSP_REQUEST_NON_NT_SESSION_KEY"},
+	{value: NTLMSSP_NEGOTIATE_TARGET_INFO, label: "NTLMSSP_NEGOTIATE_TARGET_INFO"},
+	{value: NTLMSSP_RESERVED_R4, label: "NTLMSSP_RESERVED_R4"},
+	{value: NTLMSSP_NEGOTIATE_VERSION, label: "NTLMSSP_NEGOTIATE_VERSION"},
+	{value: NTLMSSP_RESERVED_R3, label: "NTLMSSP_RESERVED_R3"},
+	{value: NTLMSSP_RESERVED_R2, label: "NTLMSSP_RESERVED_R2"},
+	{value: NTLMSSP_RESERVED_R1, label: "NTLMSSP_RESERVED_R1"},
+	{value: NTLMSSP_NEGOTIATE_128, label: "NTLMSSP_NEGOTIATE_128"},
+	{value: NTLMSSP_NEGOTIATE_KEY_EXCH, label: "NTLMSSP_NEGOTIATE_KEY_EXCH"},
+	{value: NTLMSSP_NEGOTIATE_56, label: "NTLMSSP_NEGOTIATE_56"},
+}
+
+// Has reports whether every bit of flag is set.
+func (f NegotiateFlags) Has(flag NegotiateFlags) bool {
+	return f&flag == flag
+}
+
+func (f NegotiateFlags) Set(flag NegotiateFlags) NegotiateFlags {
+	return f | flag
+}
+
+func (f NegotiateFlags) Clear(flag NegotiateFlags) NegotiateFlags {
+	return f &^ flag
+}
+
+// Names returns the label of every bit that is set, lowest bit first.
+func (f NegotiateFlags) Names() []string {
 	var labels []string
-	for _, f := range ntlmFlags {
-		if f.value&value != 0 {
-			labels = append(labels, f.label)
+	for _, flag := range ntlmFlags {
+		if f&flag.value != 0 {
+			labels = append(labels, flag.label)
 		}
 	}
+	return labels
+}
+
+func (f NegotiateFlags) String() string {
+	return strings.Join(f.Names(), " ")
+}
+
+type negotiateFlagsJSON struct {
+	Value *uint32  `json:"value"`
+	Names []string `json:"names"`
+}
+
+// MarshalJSON keeps both the raw value and the labels,
+// e.g. {"value":513,"names":["NTLMSSP_NEGOTIATE_UNICODE","NTLMSSP_NEGOTIATE_NTLM"]}
+func (f NegotiateFlags) MarshalJSON() ([]byte, error) {
+	var value = uint32(f)
+	var names = f.Names()
+	if names == nil {
+		names = []string{}
+	}
+	return json.Marshal(negotiateFlagsJSON{Value: &value, Names: names})
+}
+
+// UnmarshalJSON accepts the object written by MarshalJSON, a bare number
+// or a list of labels.
+func (f *NegotiateFlags) UnmarshalJSON(data []byte) error {
+	var value uint32
+	if err := json.Unmarshal(data, &value); err == nil {
+		*f = NegotiateFlags(value)
+		return nil
+	}
+
+	var names []string
+	if err := json.Unmarshal(data, &names); err == nil {
+		return f.setNames(names)
+	}
 
-	var result = strings.Join(labels, " ")
-	return strings.ReplaceAll(result, `NTLMSSP_NEGOTIATE_`, "")
+	var obj negotiateFlagsJSON
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return err
+	}
+	if obj.Value != nil {
+		*f = NegotiateFlags(*obj.Value)
+		return nil
+	}
+	return f.setNames(obj.Names)
+}
+
+func (f *NegotiateFlags) setNames(names []string) error {
+	var result NegotiateFlags
+	for _, name := range names {
+		var found = false
+		for _, flag := range ntlmFlags {
+			if flag.label == name {
+				result = result.Set(flag.value)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return errors.New("unknown negotiate flag: " + name)
+		}
+	}
+	*f = result
+	return nil
 }
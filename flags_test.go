@@ -0,0 +1,64 @@
+package ntlm_parser
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestNegotiateFlags(t *testing.T) {
+	var flags = NegotiateFlags(0x00000201)
+
+	if !flags.Has(NTLMSSP_NEGOTIATE_UNICODE | NTLMSSP_NEGOTIATE_NTLM) {
+		t.Errorf("Has() = false, want true")
+	}
+	if flags.Has(NTLMSSP_NEGOTIATE_UNICODE | NTLMSSP_NEGOTIATE_OEM) {
+		t.Errorf("Has() = true, want false")
+	}
+	if got := flags.Set(NTLMSSP_NEGOTIATE_56).Clear(NTLMSSP_NEGOTIATE_NTLM); got != 0x80000001 {
+		t.Errorf("Set().Clear() = %#x, want %#x", uint32(got), uint32(0x80000001))
+	}
+	if got, want := flags.String(), "NTLMSSP_NEGOTIATE_UNICODE NTLMSSP_NEGOTIATE_NTLM"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+}
+
+func TestNegotiateFlagsJSON(t *testing.T) {
+	tests := []struct {
+		name string
+		json string
+		want NegotiateFlags
+	}{
+		{
+			name: "object",
+			json: `{"value":513,"names":["NTLMSSP_NEGOTIATE_UNICODE","NTLMSSP_NEGOTIATE_NTLM"]}`,
+			want: NTLMSSP_NEGOTIATE_UNICODE | NTLMSSP_NEGOTIATE_NTLM,
+		},
+		{
+			name: "number",
+			json: `513`,
+			want: NTLMSSP_NEGOTIATE_UNICODE | NTLMSSP_NEGOTIATE_NTLM,
+		},
+		{
+			name: "names",
+			json: `["NTLMSSP_NEGOTIATE_UNICODE","NTLMSSP_NEGOTIATE_NTLM"]`,
+			want: NTLMSSP_NEGOTIATE_UNICODE | NTLMSSP_NEGOTIATE_NTLM,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got NegotiateFlags
+			if err := json.Unmarshal([]byte(tt.json), &got); err != nil {
+				t.Fatalf("json.Unmarshal() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("json.Unmarshal() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	var data, _ = json.Marshal(NTLMSSP_NEGOTIATE_UNICODE | NTLMSSP_NEGOTIATE_NTLM)
+	if got, want := string(data), tests[0].json; got != want {
+		t.Errorf("json.Marshal() got = %s, want %s", got, want)
+	}
+}
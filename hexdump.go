@@ -0,0 +1,131 @@
+package ntlm_parser
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Unreferenced is the label of the bytes that no field of the message
+// covers, such as padding between payloads.
+const Unreferenced = "(unreferenced)"
+
+type HexdumpOptions struct {
+	// Depth is how deep into the Dissect tree the bytes are labeled, 1 (the
+	// default) labels them with the header fields and payloads, 2 with
+	// their parts (e.g. the Length of a security buffer) and so on.
+	Depth int
+
+	// Color writes every region in its own ANSI color, the unreferenced
+	// bytes in bold red.
+	Color bool
+}
+
+var hexdumpColors = []string{"32", "33", "34", "35", "36", "92", "93", "94", "95", "96"}
+
+const (
+	colorUnreferenced = "1;31"
+	colorReset        = "\x1b[0m"
+)
+
+// Hexdump renders the bytes of msg, see Field.WriteHexdump.
+func Hexdump(msg NTLMMessage, opts HexdumpOptions) (string, error) {
+	var root, err = Dissect(msg)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	err = root.WriteHexdump(&b, opts)
+	return b.String(), err
+}
+
+// WriteHexdump writes the bytes of the dissected message, f being the root
+// returned by Dissect. Every run of bytes owned by the same field starts a
+// new line, up to 16 bytes per line, labeled with the path of the field.
+// Bytes that belong to overlapping fields are labeled with all of them.
+func (f *Field) WriteHexdump(w io.Writer, opts HexdumpOptions) error {
+	if opts.Depth <= 0 {
+		opts.Depth = 1
+	}
+
+	var owners = make([]string, len(f.Raw))
+	var levels = make([]int, len(f.Raw))
+	for _, child := range f.Children {
+		child.label(owners, levels, child.Name, 1, opts.Depth)
+	}
+
+	var colors = map[string]string{Unreferenced: colorUnreferenced}
+	for start := 0; start < len(owners); {
+		var end = start + 1
+		for end < len(owners) && owners[end] == owners[start] {
+			end++
+		}
+
+		var label = owners[start]
+		if label == "" {
+			label = Unreferenced
+		}
+		var color, ok = colors[label]
+		if !ok {
+			color = hexdumpColors[(len(colors)-1)%len(hexdumpColors)]
+			colors[label] = color
+		}
+
+		for offset := start; offset < end; offset += 16 {
+			var lineEnd = offset + 16
+			if lineEnd > end {
+				lineEnd = end
+			}
+			if offset != start {
+				label = ""
+			}
+			var line = hexdumpLine(f.Raw[offset:lineEnd], label)
+			if opts.Color {
+				line = "\x1b[" + color + "m" + line + colorReset
+			}
+			if _, err := fmt.Fprintf(w, "%04x  %s\n", offset, line); err != nil {
+				return err
+			}
+		}
+		start = end
+	}
+	return nil
+}
+
+// label marks the bytes of the field with its path, fields found at the same
+// depth that overlap share the bytes.
+func (f *Field) label(owners []string, levels []int, path string, depth, maxDepth int) {
+	if f.Raw == nil {
+		return
+	}
+	for i := f.Offset; i < f.Offset+f.Length && i < len(owners); i++ {
+		if levels[i] == depth && owners[i] != path {
+			owners[i] += ", " + path
+		} else {
+			owners[i] = path
+		}
+		levels[i] = depth
+	}
+
+	if depth < maxDepth {
+		for _, child := range f.Children {
+			child.label(owners, levels, path+"."+child.Name, depth+1, maxDepth)
+		}
+	}
+}
+
+func hexdumpLine(data []byte, label string) string {
+	var hexPart, asciiPart strings.Builder
+	for i, c := range data {
+		if i == 8 {
+			hexPart.WriteByte(' ')
+		}
+		fmt.Fprintf(&hexPart, "%02x ", c)
+		if c >= 0x20 && c < 0x7f {
+			asciiPart.WriteByte(c)
+		} else {
+			asciiPart.WriteByte('.')
+		}
+	}
+	return strings.TrimRight(fmt.Sprintf("%-49s %-16s  %s", hexPart.String(), asciiPart.String(), label), " ")
+}
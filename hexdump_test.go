@@ -0,0 +1,56 @@
+package ntlm_parser
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func TestHexdump(t *testing.T) {
+	var msg, err = FromBase64("TlRMTVNTUAABAAAABzIAAAYABgAzAAAACwALACgAAAAFAJMIAAAAD1dPUktTVEFUSU9ORE9NQUlO")
+	if err != nil {
+		t.Fatalf("FromBase64() error = %v", err)
+	}
+	got, err := Hexdump(msg, HexdumpOptions{})
+	if err != nil {
+		t.Fatalf("Hexdump() error = %v", err)
+	}
+
+	var want = `0000  4e 54 4c 4d 53 53 50 00                           NTLMSSP.          Signature
+0008  01 00 00 00                                       ....              MessageType
+000c  07 32 00 00                                       .2..              NegotiateFlags
+0010  06 00 06 00 33 00 00 00                           ....3...          DomainNameFields
+0018  0b 00 0b 00 28 00 00 00                           ....(...          WorkstationFields
+0020  05 00 93 08 00 00 00 0f                           ........          Version
+0028  57 4f 52 4b 53 54 41 54  49 4f 4e                 WORKSTATION       WorkstationName
+0033  44 4f 4d 41 49 4e                                 DOMAIN            DomainName
+`
+	if got != want {
+		t.Errorf("Hexdump() got =\n%s\nwant =\n%s", got, want)
+	}
+
+	got, _ = Hexdump(msg, HexdumpOptions{Depth: 2})
+	if !strings.Contains(got, "0014  33 00 00 00                                       3...              DomainNameFields.Offset\n") {
+		t.Errorf("Hexdump() got =\n%s\nwant the DomainNameFields.Offset line", got)
+	}
+}
+
+func TestHexdumpUnreferenced(t *testing.T) {
+	var data, _ = base64.StdEncoding.DecodeString("TlRMTVNTUAABAAAABzIAAAYABgAzAAAACwALACgAAAAFAJMIAAAAD1dPUktTVEFUSU9ORE9NQUlO")
+	msg, err := FromBytes(append(data, 0xaa, 0xbb))
+	if err != nil {
+		t.Fatalf("FromBytes() error = %v", err)
+	}
+
+	got, err := Hexdump(msg, HexdumpOptions{Color: true})
+	if err != nil {
+		t.Fatalf("Hexdump() error = %v", err)
+	}
+	var want = "0039  \x1b[1;31maa bb                                             ..                (unreferenced)\x1b[0m\n"
+	if !strings.HasSuffix(got, want) {
+		t.Errorf("Hexdump() got = %q, want it to end with %q", got, want)
+	}
+	if !strings.HasPrefix(got, "0000  \x1b[32m4e 54") {
+		t.Errorf("Hexdump() got = %q, want the signature in green", got)
+	}
+}
@@ -0,0 +1,267 @@
+package ntlm_parser
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Challenge is one challenge, or the credentials, of an HTTP authentication
+// header. A challenge has either a Token (token68) or Params.
+//
+// reference: https://www.rfc-editor.org/rfc/rfc7235#section-2.1
+type Challenge struct {
+	Scheme string
+	Token  string
+	Params map[string]string
+}
+
+// ParseChallenges parses the value of a WWW-Authenticate, Proxy-Authenticate,
+// Authorization or Proxy-Authorization header, which may hold several
+// comma separated challenges.
+func ParseChallenges(header string) ([]Challenge, error) {
+	var p = challengeParser{s: header}
+	var result []Challenge
+	for {
+		p.skip(" \t,")
+		if p.done() {
+			return result, nil
+		}
+
+		var challenge = Challenge{Scheme: p.token()}
+		if challenge.Scheme == "" {
+			return nil, p.fail("auth-scheme expected")
+		}
+		p.skip(" \t")
+
+		if token, ok := p.token68(); ok {
+			challenge.Token = token
+		} else {
+			for p.isParam() {
+				var name, value, err = p.param()
+				if err != nil {
+					return nil, err
+				}
+				if challenge.Params == nil {
+					challenge.Params = map[string]string{}
+				}
+				challenge.Params[strings.ToLower(name)] = value
+
+				// a comma separates the params and the challenges alike
+				p.skip(" \t")
+				var next = p.pos
+				p.skip(" \t,")
+				if next == p.pos || !p.isParam() {
+					p.pos = next
+					break
+				}
+			}
+		}
+		result = append(result, challenge)
+
+		p.skip(" \t")
+		if !p.done() && p.s[p.pos] != ',' {
+			return nil, p.fail("',' expected")
+		}
+	}
+}
+
+type challengeParser struct {
+	s   string
+	pos int
+}
+
+func (p *challengeParser) done() bool {
+	return p.pos >= len(p.s)
+}
+
+func (p *challengeParser) fail(msg string) error {
+	return fmt.Errorf("%w: %s at %d", ErrBadAuthenticate, msg, p.pos)
+}
+
+func (p *challengeParser) skip(chars string) {
+	for !p.done() && strings.IndexByte(chars, p.s[p.pos]) >= 0 {
+		p.pos++
+	}
+}
+
+// tchar, RFC 7230 section 3.2.6
+func isTokenChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' ||
+		strings.IndexByte("!#$%&'*+-.^_`|~", c) >= 0
+}
+
+func isToken68Char(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' ||
+		strings.IndexByte("-._~+/", c) >= 0
+}
+
+func (p *challengeParser) token() string {
+	var start = p.pos
+	for !p.done() && isTokenChar(p.s[p.pos]) {
+		p.pos++
+	}
+	return p.s[start:p.pos]
+}
+
+// token68 reads a token68 when it is the whole challenge, i.e. followed by
+// the end of the header or a comma.
+func (p *challengeParser) token68() (string, bool) {
+	var start, end = p.pos, p.pos
+	for end < len(p.s) && isToken68Char(p.s[end]) {
+		end++
+	}
+	if end == start {
+		return "", false
+	}
+	for end < len(p.s) && p.s[end] == '=' {
+		end++
+	}
+
+	var next = end
+	for next < len(p.s) && (p.s[next] == ' ' || p.s[next] == '\t') {
+		next++
+	}
+	if next < len(p.s) && p.s[next] != ',' {
+		return "", false
+	}
+	p.pos = end
+	return p.s[start:end], true
+}
+
+// isParam tells whether an auth-param, rather than the next challenge,
+// starts at the current position.
+func (p *challengeParser) isParam() bool {
+	var saved = p.pos
+	defer func() { p.pos = saved }()
+
+	if p.token() == "" {
+		return false
+	}
+	p.skip(" \t")
+	return !p.done() && p.s[p.pos] == '='
+}
+
+func (p *challengeParser) param() (string, string, error) {
+	var name = p.token()
+	p.skip(" \t")
+	p.pos++ // '='
+	p.skip(" \t")
+
+	if p.done() || p.s[p.pos] != '"' {
+		var value = p.token()
+		if value == "" {
+			return "", "", p.fail("auth-param value expected")
+		}
+		return name, value, nil
+	}
+
+	var value strings.Builder
+	for p.pos++; !p.done(); p.pos++ {
+		switch c := p.s[p.pos]; c {
+		case '"':
+			p.pos++
+			return name, value.String(), nil
+		case '\\':
+			if p.pos+1 < len(p.s) {
+				p.pos++
+			}
+			value.WriteByte(p.s[p.pos])
+		default:
+			value.WriteByte(c)
+		}
+	}
+	return "", "", p.fail("unterminated quoted-string")
+}
+
+// authHeaderNames are the header names FromHTTPHeader strips from a whole
+// header line.
+var authHeaderNames = []string{"WWW-Authenticate", "Proxy-Authenticate", "Authorization", "Proxy-Authorization"}
+
+func FromHTTPHeader(value string) (NTLMMessage, error) {
+	return ParseOptions{}.FromHTTPHeader(value)
+}
+
+func FromHTTPRequest(req *http.Request) (NTLMMessage, error) {
+	return ParseOptions{}.FromHTTPRequest(req)
+}
+
+func FromHTTPResponse(resp *http.Response) (NTLMMessage, error) {
+	return ParseOptions{}.FromHTTPResponse(resp)
+}
+
+// FromHTTPHeader parses the first NTLM or Negotiate token of a header value,
+// a whole "Name: value" line is accepted as well. Negotiate tokens are
+// unwrapped from SPNEGO.
+func (o ParseOptions) FromHTTPHeader(value string) (NTLMMessage, error) {
+	for _, name := range authHeaderNames {
+		if len(value) > len(name) && strings.EqualFold(value[:len(name)], name) && value[len(name)] == ':' {
+			value = value[len(name)+1:]
+			break
+		}
+	}
+	return o.fromHTTPHeaders([]string{value})
+}
+
+// FromHTTPRequest parses the Authorization header, or Proxy-Authorization
+// when there is none with an NTLM or Negotiate token.
+func (o ParseOptions) FromHTTPRequest(req *http.Request) (NTLMMessage, error) {
+	return o.fromHTTPHeaders(append(req.Header.Values("Authorization"), req.Header.Values("Proxy-Authorization")...))
+}
+
+// FromHTTPResponse parses the WWW-Authenticate headers, or the
+// Proxy-Authenticate ones when none has an NTLM or Negotiate token.
+func (o ParseOptions) FromHTTPResponse(resp *http.Response) (NTLMMessage, error) {
+	return o.fromHTTPHeaders(append(resp.Header.Values("WWW-Authenticate"), resp.Header.Values("Proxy-Authenticate")...))
+}
+
+// fromHTTPHeaders parses the first NTLM or Negotiate token of values. A
+// value that can't be parsed, or a token that isn't base64 or carries
+// Kerberos, is skipped and its error only returned when no other value has
+// a token.
+func (o ParseOptions) fromHTTPHeaders(values []string) (NTLMMessage, error) {
+	var firstErr error
+	var fail = func(err error) {
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	for _, value := range values {
+		var challenges, err = ParseChallenges(value)
+		if err != nil {
+			fail(err)
+			continue
+		}
+
+		for _, challenge := range challenges {
+			var negotiate = strings.EqualFold(challenge.Scheme, "Negotiate")
+			if !negotiate && !strings.EqualFold(challenge.Scheme, "NTLM") || challenge.Token == "" {
+				continue
+			}
+
+			var data, err = base64.StdEncoding.DecodeString(challenge.Token)
+			if err != nil {
+				fail(err)
+				continue
+			}
+			if negotiate && !bytes.HasPrefix(data, signature) && !isSPNEGO(data) {
+				fail(ErrKerberosToken)
+				continue
+			}
+			msg, err := o.fromNegotiateToken(data)
+			if errors.Is(err, ErrKerberosToken) {
+				fail(err)
+				continue
+			}
+			return msg, err
+		}
+	}
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return nil, ErrNoNTLMToken
+}
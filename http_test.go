@@ -0,0 +1,135 @@
+package ntlm_parser
+
+import (
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestParseChallenges(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  string
+		want    []Challenge
+		wantErr bool
+	}{
+		{
+			name:   "token68",
+			header: "NTLM TlRMTVNTUAABAAAAB4IIogAAAAAAAAAAAAAAAAAAAAAKALpHAAAADw==",
+			want:   []Challenge{{Scheme: "NTLM", Token: "TlRMTVNTUAABAAAAB4IIogAAAAAAAAAAAAAAAAAAAAAKALpHAAAADw=="}},
+		},
+		{
+			name:   "several challenges",
+			header: `Negotiate, NTLM, Basic realm="a \"b\", c" , Digest realm=x, nonce="y",Bearer abc=`,
+			want: []Challenge{
+				{Scheme: "Negotiate"},
+				{Scheme: "NTLM"},
+				{Scheme: "Basic", Params: map[string]string{"realm": `a "b", c`}},
+				{Scheme: "Digest", Params: map[string]string{"realm": "x", "nonce": "y"}},
+				{Scheme: "Bearer", Token: "abc="},
+			},
+		},
+		{
+			name:    "unterminated quoted-string",
+			header:  `Basic realm="x`,
+			wantErr: true,
+		},
+		{
+			name:    "garbage",
+			header:  `NTLM abc def`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseChallenges(tt.header)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseChallenges() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrBadAuthenticate) {
+				t.Errorf("ParseChallenges() error = %v, want ErrBadAuthenticate", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseChallenges() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFromHTTPHeader(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  string
+		want    NTLMMessageType
+		wantErr error
+	}{
+		{
+			name:   "NTLM",
+			header: "Negotiate, NTLM TlRMTVNTUAABAAAAB4IIogAAAAAAAAAAAAAAAAAAAAAKALpHAAAADw==",
+			want:   NEGOTIATE_MESSAGE,
+		},
+		{
+			name:   "header line",
+			header: "proxy-authenticate: NTLM TlRMTVNTUAABAAAAB4IIogAAAAAAAAAAAAAAAAAAAAAKALpHAAAADw==",
+			want:   NEGOTIATE_MESSAGE,
+		},
+		{
+			name:   "SPNEGO",
+			header: "WWW-Authenticate: Negotiate oYHcMIHZoAMKAQGhDAYKKwYBBAGCNwICCqKBwwSBwE5UTE1TU1AAAgAAAAYABgA4AAAANYKJ4mmghg1wkUTVAAAAAAAAAACCAIIAPgAAAAoAukcAAAAPSgBMAEcAAgAGAEoATABHAAEAEABDAEgATwBVAEMASABPAFUABAASAGoAbABnAC4AbABvAGMAYQBsAAMAJABjAGgAbwB1AGMAaABvAHUALgBqAGwAZwAuAGwAbwBjAGEAbAAFABIAagBsAGcALgBsAG8AYwBhAGwABwAIAEB+lCfevdYBAAAAAA==",
+			want:   CHALLENGE_MESSAGE,
+		},
+		{
+			name:    "no token",
+			header:  `Negotiate, NTLM, Basic realm="x"`,
+			wantErr: ErrNoNTLMToken,
+		},
+		{
+			name:    "Kerberos",
+			header:  "Negotiate YA8GCSqGSIb3EgECAgEAbgA=",
+			wantErr: ErrKerberosToken,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := FromHTTPHeader(tt.header)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("FromHTTPHeader() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && messageType(got) != tt.want {
+				t.Errorf("FromHTTPHeader() got = %T, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFromHTTPRequestResponse(t *testing.T) {
+	var req = &http.Request{Header: http.Header{}}
+	req.Header.Add("Proxy-Authorization", "NTLM TlRMTVNTUAABAAAAB4IIogAAAAAAAAAAAAAAAAAAAAAKALpHAAAADw==")
+	if msg, err := FromHTTPRequest(req); err != nil || messageType(msg) != NEGOTIATE_MESSAGE {
+		t.Errorf("FromHTTPRequest() got = %T, error = %v", msg, err)
+	}
+
+	var resp = &http.Response{Header: http.Header{}}
+	resp.Header.Add("WWW-Authenticate", "Negotiate")
+	resp.Header.Add("WWW-Authenticate", "NTLM TlRMTVNTUAACAAAADAAMADAAAAABAoEAASNFZ4mrze8AAAAAAAAAAGIAYgA8AAAARABPAE0AQQBJAE4AAgAMAEQATwBNAEEASQBOAAEADABTAEUAUgBWAEUAUgAEABQAZABvAG0AYQBpAG4ALgBjAG8AbQADACIAcwBlAHIAdgBlAHIALgBkAG8AbQBhAGkAbgAuAGMAbwBtAAAAAAA=")
+	if msg, err := FromHTTPResponse(resp); err != nil || messageType(msg) != CHALLENGE_MESSAGE {
+		t.Errorf("FromHTTPResponse() got = %T, error = %v", msg, err)
+	}
+
+	// the bad values are skipped, their first error is returned when no
+	// value has a token
+	req = &http.Request{Header: http.Header{}}
+	req.Header.Add("Authorization", `Basic realm="unterminated`)
+	req.Header.Add("Authorization", "NTLM not-base64")
+	req.Header.Add("Authorization", "NTLM TlRMTVNTUAABAAAAB4IIogAAAAAAAAAAAAAAAAAAAAAKALpHAAAADw==")
+	if msg, err := FromHTTPRequest(req); err != nil || messageType(msg) != NEGOTIATE_MESSAGE {
+		t.Errorf("FromHTTPRequest() got = %T, error = %v", msg, err)
+	}
+	req.Header.Del("Authorization")
+	req.Header.Add("Authorization", "NTLM not-base64")
+	req.Header.Add("Authorization", "Negotiate YA8GCSqGSIb3EgECAgEAbgA=")
+	if _, err := FromHTTPRequest(req); err == nil || errors.Is(err, ErrKerberosToken) {
+		t.Errorf("FromHTTPRequest() error = %v, want the base64 error", err)
+	}
+}
@@ -0,0 +1,562 @@
+package ntlm_parser
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// JSONSchemaVersion is written as schemaVersion by every MarshalJSON of this
+// package. Fields may be added within a version, renaming or removing one
+// or changing its type needs a new version.
+//
+// Every message is an object with the following members, a member that
+// doesn't belong to the message type is left out:
+//
+//	schemaVersion              number, JSONSchemaVersion
+//	messageType                "NEGOTIATE_MESSAGE", "CHALLENGE_MESSAGE" or "AUTHENTICATE_MESSAGE"
+//	ntlmVersion                AUTHENTICATE_MESSAGE only, 1, 2 or 3, see NTLMType3v1.Version
+//	flags                      NegotiateFlags, {"value":N,"names":[...]}, not in ntlmVersion 1
+//	codePage                   number, the CodePage of the OEM strings when not zero
+//	domainName                 string, NEGOTIATE_MESSAGE and AUTHENTICATE_MESSAGE
+//	workstation                string, NEGOTIATE_MESSAGE and AUTHENTICATE_MESSAGE
+//	targetName                 string, CHALLENGE_MESSAGE
+//	serverChallenge            hex, CHALLENGE_MESSAGE
+//	context                    hex, CHALLENGE_MESSAGE, empty when the message has no room for it
+//	targetInfo                 list of AV pairs, CHALLENGE_MESSAGE
+//	lmChallengeResponse        hex, AUTHENTICATE_MESSAGE
+//	ntChallengeResponse        hex, AUTHENTICATE_MESSAGE
+//	ntlmv2Response             NTLMv2Response, when ntChallengeResponse is an NTLMv2 response
+//	userName                   string, AUTHENTICATE_MESSAGE
+//	encryptedRandomSessionKey  hex, not in ntlmVersion 1
+//	mic                        hex, ntlmVersion 3 when the message has a MIC
+//	micPresent                 bool, ntlmVersion 3
+//	version                    OSVersionStructure, when the message has a VERSION structure
+//	warnings                   lenient parse warnings, ignored by UnmarshalJSON
+//
+// domainName, workstation, targetName and userName have a "...Raw" member
+// (hex) with the bytes on the wire when the string doesn't encode back to
+// them, e.g. an OEM string with bytes the code page doesn't map.
+//
+// An AV pair is {"id":N,"name":"MsvAv...","value":V} where V is a string
+// for names, a number for MsvAvFlags, an RFC 3339 time for MsvAvTimestamp,
+// hex for MsvAvChannelBindings, an object for MsvAvSingleHost and absent
+// for MsvAvEOL. A pair that couldn't be decoded has "raw" (hex) instead of
+// "value".
+const JSONSchemaVersion = 1
+
+var jsonMessageTypes = map[NTLMMessageType]string{
+	NEGOTIATE_MESSAGE:    "NEGOTIATE_MESSAGE",
+	CHALLENGE_MESSAGE:    "CHALLENGE_MESSAGE",
+	AUTHENTICATE_MESSAGE: "AUTHENTICATE_MESSAGE",
+}
+
+type messageJSON struct {
+	SchemaVersion int             `json:"schemaVersion"`
+	MessageType   string          `json:"messageType"`
+	NTLMVersion   int             `json:"ntlmVersion,omitempty"`
+	Flags         *NegotiateFlags `json:"flags,omitempty"`
+	CodePage      CodePage        `json:"codePage,omitempty"`
+
+	DomainName      *string     `json:"domainName,omitempty"`
+	DomainNameRaw   *string     `json:"domainNameRaw,omitempty"`
+	Workstation     *string     `json:"workstation,omitempty"`
+	WorkstationRaw  *string     `json:"workstationRaw,omitempty"`
+	TargetName      *string     `json:"targetName,omitempty"`
+	TargetNameRaw   *string     `json:"targetNameRaw,omitempty"`
+	ServerChallenge *string     `json:"serverChallenge,omitempty"`
+	Context         *string     `json:"context,omitempty"`
+	TargetInfo      *AvPairList `json:"targetInfo,omitempty"`
+
+	LmChallengeResponse       *string         `json:"lmChallengeResponse,omitempty"`
+	NtChallengeResponse       *string         `json:"ntChallengeResponse,omitempty"`
+	NTLMv2Response            *NTLMv2Response `json:"ntlmv2Response,omitempty"`
+	UserName                  *string         `json:"userName,omitempty"`
+	UserNameRaw               *string         `json:"userNameRaw,omitempty"`
+	EncryptedRandomSessionKey *string         `json:"encryptedRandomSessionKey,omitempty"`
+	MIC                       *string         `json:"mic,omitempty"`
+	MICPresent                *bool           `json:"micPresent,omitempty"`
+
+	Version  *OSVersionStructure `json:"version,omitempty"`
+	Warnings []warningJSON       `json:"warnings,omitempty"`
+}
+
+type warningJSON struct {
+	Field  string `json:"field"`
+	Offset int    `json:"offset"`
+	Error  string `json:"error"`
+}
+
+func newMessageJSON(messageType NTLMMessageType, warnings []*ParseError) *messageJSON {
+	var result = &messageJSON{
+		SchemaVersion: JSONSchemaVersion,
+		MessageType:   jsonMessageTypes[messageType],
+	}
+	for _, warning := range warnings {
+		result.Warnings = append(result.Warnings, warningJSON{
+			Field:  warning.Field,
+			Offset: warning.Offset,
+			Error:  warning.Err.Error(),
+		})
+	}
+	return result
+}
+
+// decodeMessageJSON decodes data and checks that it is a messageType
+// message of a known schema version.
+func decodeMessageJSON(data []byte, messageType NTLMMessageType) (*messageJSON, error) {
+	var result messageJSON
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, err
+	}
+	if result.SchemaVersion != JSONSchemaVersion {
+		return nil, fmt.Errorf("unsupported JSON schema version %d", result.SchemaVersion)
+	}
+	if result.MessageType != jsonMessageTypes[messageType] {
+		return nil, fmt.Errorf("messageType %q, want %q", result.MessageType, jsonMessageTypes[messageType])
+	}
+	return &result, nil
+}
+
+// FromJSON decodes a message written by MarshalJSON, the concrete type is
+// picked by messageType and ntlmVersion.
+func FromJSON(data []byte) (NTLMMessage, error) {
+	var header struct {
+		MessageType string `json:"messageType"`
+		NTLMVersion int    `json:"ntlmVersion"`
+	}
+	if err := json.Unmarshal(data, &header); err != nil {
+		return nil, err
+	}
+
+	var msg interface {
+		NTLMMessage
+		json.Unmarshaler
+	}
+	switch header.MessageType {
+	case "NEGOTIATE_MESSAGE":
+		msg = &NTLMType1{}
+	case "CHALLENGE_MESSAGE":
+		msg = &NTLMType2{}
+	case "AUTHENTICATE_MESSAGE":
+		switch header.NTLMVersion {
+		case 1:
+			msg = &NTLMType3v1{}
+		case 2:
+			msg = &NTLMType3v2{}
+		default:
+			msg = &NTLMType3v3{}
+		}
+	default:
+		return nil, fmt.Errorf("unknown messageType %q", header.MessageType)
+	}
+
+	if err := msg.UnmarshalJSON(data); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+func (N NTLMType1) MarshalJSON() ([]byte, error) {
+	var result = newMessageJSON(NEGOTIATE_MESSAGE, N.Warnings)
+	result.Flags = &N.Flags
+	result.CodePage = N.CodePage
+	result.DomainName = &N.SuppliedDomainData
+	result.DomainNameRaw = rawJSON(N.SuppliedDomainData, N.SuppliedDomainRaw, 0, N.CodePage)
+	result.Workstation = &N.SuppliedWorkstationData
+	result.WorkstationRaw = rawJSON(N.SuppliedWorkstationData, N.SuppliedWorkstationRaw, 0, N.CodePage)
+	if N.Flags.Has(NTLMSSP_NEGOTIATE_VERSION) || N.OsVersionStructure != (OSVersionStructure{}) {
+		result.Version = &N.OsVersionStructure
+	}
+	return json.Marshal(result)
+}
+
+func (N *NTLMType1) UnmarshalJSON(data []byte) error {
+	var msg, err = decodeMessageJSON(data, NEGOTIATE_MESSAGE)
+	if err != nil {
+		return err
+	}
+
+	*N = NTLMType1{MessageType: NEGOTIATE_MESSAGE, CodePage: msg.CodePage}
+	setJSON(&N.Flags, msg.Flags)
+	setJSON(&N.SuppliedDomainData, msg.DomainName)
+	setJSON(&N.SuppliedWorkstationData, msg.Workstation)
+	setJSON(&N.OsVersionStructure, msg.Version)
+	if err := setRawJSON(&N.SuppliedDomainRaw, msg.DomainNameRaw, "domainNameRaw"); err != nil {
+		return err
+	}
+	return setRawJSON(&N.SuppliedWorkstationRaw, msg.WorkstationRaw, "workstationRaw")
+}
+
+func (N NTLMType2) MarshalJSON() ([]byte, error) {
+	var result = newMessageJSON(CHALLENGE_MESSAGE, N.Warnings)
+	var targetInfo = N.TargetInfoData
+	if targetInfo == nil {
+		targetInfo = AvPairList{}
+	}
+	result.Flags = &N.Flags
+	result.CodePage = N.CodePage
+	result.TargetName = &N.TargetNameData
+	result.TargetNameRaw = rawJSON(N.TargetNameData, N.TargetNameRaw, N.Flags, N.CodePage)
+	result.ServerChallenge = &N.Challenge
+	result.Context = &N.Context
+	result.TargetInfo = &targetInfo
+	if N.Flags.Has(NTLMSSP_NEGOTIATE_VERSION) || N.OsVersionStructure != (OSVersionStructure{}) {
+		result.Version = &N.OsVersionStructure
+	}
+	return json.Marshal(result)
+}
+
+func (N *NTLMType2) UnmarshalJSON(data []byte) error {
+	var msg, err = decodeMessageJSON(data, CHALLENGE_MESSAGE)
+	if err != nil {
+		return err
+	}
+
+	*N = NTLMType2{MessageType: CHALLENGE_MESSAGE, CodePage: msg.CodePage}
+	setJSON(&N.Flags, msg.Flags)
+	setJSON(&N.TargetNameData, msg.TargetName)
+	setJSON(&N.Challenge, msg.ServerChallenge)
+	setJSON(&N.Context, msg.Context)
+	setJSON(&N.TargetInfoData, msg.TargetInfo)
+	setJSON(&N.OsVersionStructure, msg.Version)
+	return setRawJSON(&N.TargetNameRaw, msg.TargetNameRaw, "targetNameRaw")
+}
+
+func (N NTLMType3v1) MarshalJSON() ([]byte, error) {
+	return json.Marshal(N.messageJSON(1, NTLMSSP_NEGOTIATE_UNICODE))
+}
+
+// messageJSON fills in the members shared by the AUTHENTICATE_MESSAGE
+// versions, ntlmVersion is the one of the concrete type and flag tells how
+// its strings are encoded.
+func (N NTLMType3v1) messageJSON(version int, flag NegotiateFlags) *messageJSON {
+	var result = newMessageJSON(AUTHENTICATE_MESSAGE, N.Warnings)
+	result.NTLMVersion = version
+	result.CodePage = N.CodePage
+	result.LmChallengeResponse = &N.LmResponseData.Hex
+	result.NtChallengeResponse = &N.NtlmResponseData.Hex
+	result.NTLMv2Response = N.NtlmResponseData.NTLMv2Response
+	result.DomainName = &N.TargetNameData
+	result.DomainNameRaw = rawJSON(N.TargetNameData, N.TargetNameRaw, flag, N.CodePage)
+	result.UserName = &N.UserNameData
+	result.UserNameRaw = rawJSON(N.UserNameData, N.UserNameRaw, flag, N.CodePage)
+	result.Workstation = &N.WorkstationNameData
+	result.WorkstationRaw = rawJSON(N.WorkstationNameData, N.WorkstationNameRaw, flag, N.CodePage)
+	return result
+}
+
+func (N *NTLMType3v1) UnmarshalJSON(data []byte) error {
+	var msg, err = decodeMessageJSON(data, AUTHENTICATE_MESSAGE)
+	if err != nil {
+		return err
+	}
+	return N.setJSON(msg, 1)
+}
+
+func (N *NTLMType3v1) setJSON(msg *messageJSON, version int) error {
+	*N = NTLMType3v1{MessageType: AUTHENTICATE_MESSAGE, Version: version, CodePage: msg.CodePage}
+	setJSON(&N.LmResponseData.Hex, msg.LmChallengeResponse)
+	setJSON(&N.NtlmResponseData.Hex, msg.NtChallengeResponse)
+	N.NtlmResponseData.NTLMv2Response = msg.NTLMv2Response
+	setJSON(&N.TargetNameData, msg.DomainName)
+	setJSON(&N.UserNameData, msg.UserName)
+	setJSON(&N.WorkstationNameData, msg.Workstation)
+	if err := setRawJSON(&N.TargetNameRaw, msg.DomainNameRaw, "domainNameRaw"); err != nil {
+		return err
+	}
+	if err := setRawJSON(&N.UserNameRaw, msg.UserNameRaw, "userNameRaw"); err != nil {
+		return err
+	}
+	return setRawJSON(&N.WorkstationNameRaw, msg.WorkstationRaw, "workstationRaw")
+}
+
+func (N NTLMType3v2) MarshalJSON() ([]byte, error) {
+	return json.Marshal(N.messageJSON(2))
+}
+
+func (N NTLMType3v2) messageJSON(version int) *messageJSON {
+	var result = N.NTLMType3v1.messageJSON(version, N.Flags)
+	result.Flags = &N.Flags
+	result.EncryptedRandomSessionKey = &N.SessionKeyData.Hex
+	if N.SessionKeyData.Raw != nil {
+		var sessionKey = hex.EncodeToString(N.SessionKeyData.Raw)
+		result.EncryptedRandomSessionKey = &sessionKey
+	}
+	return result
+}
+
+func (N *NTLMType3v2) UnmarshalJSON(data []byte) error {
+	var msg, err = decodeMessageJSON(data, AUTHENTICATE_MESSAGE)
+	if err != nil {
+		return err
+	}
+	return N.setJSON(msg, 2)
+}
+
+func (N *NTLMType3v2) setJSON(msg *messageJSON, version int) error {
+	*N = NTLMType3v2{}
+	if err := N.NTLMType3v1.setJSON(msg, version); err != nil {
+		return err
+	}
+	setJSON(&N.Flags, msg.Flags)
+	setJSON(&N.SessionKeyData.Hex, msg.EncryptedRandomSessionKey)
+	N.SessionKeyData.KeyExch = N.Flags.Has(NTLMSSP_NEGOTIATE_KEY_EXCH)
+	return nil
+}
+
+func (N NTLMType3v3) MarshalJSON() ([]byte, error) {
+	var result = N.NTLMType3v2.messageJSON(3)
+	result.Version = &N.OsVersionStructure
+	if N.MIC != "" {
+		result.MIC = &N.MIC
+	}
+	result.MICPresent = &N.MICPresent
+	return json.Marshal(result)
+}
+
+func (N *NTLMType3v3) UnmarshalJSON(data []byte) error {
+	var msg, err = decodeMessageJSON(data, AUTHENTICATE_MESSAGE)
+	if err != nil {
+		return err
+	}
+
+	*N = NTLMType3v3{}
+	if err := N.NTLMType3v2.setJSON(msg, 3); err != nil {
+		return err
+	}
+	setJSON(&N.OsVersionStructure, msg.Version)
+	setJSON(&N.MIC, msg.MIC)
+	setJSON(&N.MICPresent, msg.MICPresent)
+	return nil
+}
+
+// setJSON copies a member that was present in the document.
+func setJSON[T any](dst *T, src *T) {
+	if src != nil {
+		*dst = *src
+	}
+}
+
+// rawJSON returns raw in hex when str doesn't encode back to it with flag
+// and codePage, nil when it does.
+func rawJSON(str string, raw []byte, flag NegotiateFlags, codePage CodePage) *string {
+	if raw == nil {
+		return nil
+	}
+	if data, err := encodeString(str, nil, flag, codePage); err == nil && bytes.Equal(data, raw) {
+		return nil
+	}
+	var result = hex.EncodeToString(raw)
+	return &result
+}
+
+// setRawJSON decodes a "...Raw" member that was present in the document.
+func setRawJSON(dst *[]byte, src *string, member string) error {
+	if src == nil {
+		return nil
+	}
+	var data, err = hex.DecodeString(*src)
+	if err != nil {
+		return fmt.Errorf("%s: %w", member, err)
+	}
+	*dst = data
+	return nil
+}
+
+type versionJSON struct {
+	Major        int    `json:"major"`
+	Minor        int    `json:"minor"`
+	Build        int    `json:"build"`
+	NTLMRevision int    `json:"ntlmRevision"`
+	Reserved     string `json:"reserved,omitempty"`
+	Product      string `json:"product,omitempty"`
+}
+
+// MarshalJSON writes the version numbers and, for reading only, the
+// product name given by LongString.
+func (o OSVersionStructure) MarshalJSON() ([]byte, error) {
+	var result = versionJSON{
+		Major:        o.MajorVersion,
+		Minor:        o.MinorVersion,
+		Build:        o.BuildNumber,
+		NTLMRevision: o.NTLMRevisionCurrent,
+		Product:      o.LongString(),
+	}
+	if o.Reserved != [3]byte{} {
+		result.Reserved = hex.EncodeToString(o.Reserved[:])
+	}
+	return json.Marshal(result)
+}
+
+func (o *OSVersionStructure) UnmarshalJSON(data []byte) error {
+	var v versionJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+
+	*o = OSVersionStructure{
+		MajorVersion:        v.Major,
+		MinorVersion:        v.Minor,
+		BuildNumber:         v.Build,
+		NTLMRevisionCurrent: v.NTLMRevision,
+	}
+	if v.Reserved != "" {
+		var reserved, err = hex.DecodeString(v.Reserved)
+		if err != nil || len(reserved) != 3 {
+			return fmt.Errorf("version reserved %q isn't 3 bytes of hex", v.Reserved)
+		}
+		copy(o.Reserved[:], reserved)
+	}
+	return nil
+}
+
+type avPairJSON struct {
+	ID    AvID            `json:"id"`
+	Name  string          `json:"name,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+	Raw   *string         `json:"raw,omitempty"`
+}
+
+type singleHostJSON struct {
+	Size       uint32 `json:"size"`
+	Z4         uint32 `json:"z4"`
+	CustomData string `json:"customData"`
+	MachineID  string `json:"machineId"`
+}
+
+// MarshalJSON writes the AV pair as described in JSONSchemaVersion, a
+// TargetInfo without Value is taken from Type and Content.
+func (t TargetInfo) MarshalJSON() ([]byte, error) {
+	var value = t.Value
+	if value == nil {
+		var data, err = encodeAvPairs(AvPairList{t})
+		if err != nil {
+			return nil, err
+		}
+		value = decodeAvPair(AvID(t.Type), data[4:])
+	}
+
+	var result = avPairJSON{ID: value.AvID(), Name: value.AvID().String()}
+	var v interface{}
+	switch value := value.(type) {
+	case AvEOL:
+	case AvRaw:
+		var raw = hex.EncodeToString(value.Value)
+		result.Raw = &raw
+	case AvFlags:
+		v = uint32(value)
+	case AvTimestamp:
+		v = value.Time
+	case AvSingleHost:
+		v = singleHostJSON{
+			Size:       value.Size,
+			Z4:         value.Z4,
+			CustomData: hex.EncodeToString(value.CustomData[:]),
+			MachineID:  hex.EncodeToString(value.MachineID[:]),
+		}
+	case AvChannelBindings:
+		v = value.String()
+	default:
+		v = value.String()
+	}
+	if v != nil {
+		var err error
+		if result.Value, err = json.Marshal(v); err != nil {
+			return nil, err
+		}
+	}
+	return json.Marshal(result)
+}
+
+func (t *TargetInfo) UnmarshalJSON(data []byte) error {
+	var v avPairJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+
+	var value, err = v.avPair()
+	if err != nil {
+		return fmt.Errorf("AV pair %d: %w", v.ID, err)
+	}
+	*t = TargetInfo{
+		Type:    int(value.AvID()),
+		Length:  len(value.encodeValue()),
+		Content: value.String(),
+		Value:   value,
+	}
+	return nil
+}
+
+func (v avPairJSON) avPair() (AvPair, error) {
+	if v.Raw != nil {
+		var raw, err = hex.DecodeString(*v.Raw)
+		return AvRaw{ID: v.ID, Value: raw}, err
+	}
+
+	switch v.ID {
+	case MsvAvEOL:
+		return AvEOL{}, nil
+	case MsvAvFlags:
+		var flags uint32
+		var err = json.Unmarshal(v.Value, &flags)
+		return AvFlags(flags), err
+	case MsvAvTimestamp:
+		var timestamp time.Time
+		var err = json.Unmarshal(v.Value, &timestamp)
+		return AvTimestamp{Time: timestamp.UTC()}, err
+	case MsvAvSingleHost:
+		var singleHost singleHostJSON
+		if err := json.Unmarshal(v.Value, &singleHost); err != nil {
+			return nil, err
+		}
+		var result = AvSingleHost{Size: singleHost.Size, Z4: singleHost.Z4}
+		if err := decodeHexArray(result.CustomData[:], singleHost.CustomData); err != nil {
+			return nil, err
+		}
+		return result, decodeHexArray(result.MachineID[:], singleHost.MachineID)
+	case MsvAvChannelBindings:
+		var str string
+		if err := json.Unmarshal(v.Value, &str); err != nil {
+			return nil, err
+		}
+		var result AvChannelBindings
+		return result, decodeHexArray(result[:], str)
+	}
+
+	var str string
+	if err := json.Unmarshal(v.Value, &str); err != nil {
+		return nil, err
+	}
+	switch v.ID {
+	case MsvAvNbComputerName:
+		return AvNbComputerName(str), nil
+	case MsvAvNbDomainName:
+		return AvNbDomainName(str), nil
+	case MsvAvDnsComputerName:
+		return AvDnsComputerName(str), nil
+	case MsvAvDnsDomainName:
+		return AvDnsDomainName(str), nil
+	case MsvAvDnsTreeName:
+		return AvDnsTreeName(str), nil
+	case MsvAvTargetName:
+		return AvTargetName(str), nil
+	}
+	return nil, errors.New(`unknown AV pair id without "raw"`)
+}
+
+// decodeHexArray decodes str into dst, str must be exactly len(dst) bytes.
+func decodeHexArray(dst []byte, str string) error {
+	var data, err = hex.DecodeString(str)
+	if err != nil {
+		return err
+	}
+	if len(data) != len(dst) {
+		return fmt.Errorf("%d bytes, want %d", len(data), len(dst))
+	}
+	copy(dst, data)
+	return nil
+}
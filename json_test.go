@@ -0,0 +1,168 @@
+package ntlm_parser
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestJSON(t *testing.T) {
+	var msg, err = FromBase64("TlRMTVNTUAABAAAAB4IIogAAAAAAAAAAAAAAAAAAAAAKALpHAAAADw==")
+	if err != nil {
+		t.Fatalf("FromBase64() error = %v", err)
+	}
+
+	data, err := json.Marshal(msg)
+	if err != nil {
+		t.Fatalf("json.Marshal() error = %v", err)
+	}
+	var want = `{"schemaVersion":1,"messageType":"NEGOTIATE_MESSAGE",` +
+		`"flags":{"value":2718466567,"names":["NTLMSSP_NEGOTIATE_UNICODE","NTLMSSP_NEGOTIATE_OEM","NTLMSSP_REQUEST_TARGET",` +
+		`"NTLMSSP_NEGOTIATE_NTLM","NTLMSSP_NEGOTIATE_ALWAYS_SIGN","NTLMSSP_NEGOTIATE_EXTENDED_SESSIONSECURITY",` +
+		`"NTLMSSP_NEGOTIATE_VERSION","NTLMSSP_NEGOTIATE_128","NTLMSSP_NEGOTIATE_56"]},` +
+		`"domainName":"","workstation":"",` +
+		`"version":{"major":10,"minor":0,"build":18362,"ntlmRevision":15,"product":"Windows 10 1903 or Windows Server 1903 (10.0.18362.15)"}}`
+	if string(data) != want {
+		t.Errorf("json.Marshal() got = %s, want %s", data, want)
+	}
+}
+
+func TestTargetInfoJSON(t *testing.T) {
+	var list = AvPairList{
+		{Value: AvNbDomainName("JLG")},
+		{Value: AvFlags(AvFlagsMICProvided)},
+		{Value: AvRaw{ID: MsvAvFlags, Value: []byte{0xff}}},
+		{Value: AvEOL{}},
+	}
+
+	data, err := json.Marshal(list)
+	if err != nil {
+		t.Fatalf("json.Marshal() error = %v", err)
+	}
+	var want = `[{"id":2,"name":"MsvAvNbDomainName","value":"JLG"},{"id":6,"name":"MsvAvFlags","value":2},` +
+		`{"id":6,"name":"MsvAvFlags","raw":"ff"},{"id":0,"name":"MsvAvEOL"}]`
+	if string(data) != want {
+		t.Errorf("json.Marshal() got = %s, want %s", data, want)
+	}
+
+	var got AvPairList
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("json.Unmarshal() error = %v", err)
+	}
+	if !reflect.DeepEqual(got.AvPairs(), list.AvPairs()) {
+		t.Errorf("json.Unmarshal() got = %v, want %v", got.AvPairs(), list.AvPairs())
+	}
+}
+
+func TestJSONRoundTrip(t *testing.T) {
+	for _, data := range []string{
+		"TlRMTVNTUAABAAAABzIAAAYABgAzAAAACwALACgAAAAFAJMIAAAAD1dPUktTVEFUSU9ORE9NQUlO",
+		"TlRMTVNTUAACAAAABgAGADgAAAA1goniaaCGDXCRRNUAAAAAAAAAAIIAggA+AAAACgC6RwAAAA9KAEwARwACAAYASgBMAEcAAQAQAEMASABPAFUAQwBIAE8AVQAEABIAagBsAGcALgBsAG8AYwBhAGwAAwAkAGMAaABvAHUAYwBoAG8AdQAuAGoAbABnAC4AbABvAGMAYQBsAAUAEgBqAGwAZwAuAGwAbwBjAGEAbAAHAAgAQH6UJ9691gEAAAAA",
+		"TlRMTVNTUAADAAAAGAAYAHQAAAAiASIBjAAAAAAAAABYAAAADAAMAFgAAAAQABAAZAAAABAAEACuAQAANYKI4goAukcAAAAP1KMCweXeFIr6zmSmiHFWSWoAbABvAHUAaQBzAEMASABPAFUAQwBIAE8AVQAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAC5/Vhnk2GTLD131k8cNfZcAQEAAAAAAADSVClUh73WAX873ENT+QbPAAAAAAIABgBKAEwARwABABAAQwBIAE8AVQBDAEgATwBVAAQAEgBqAGwAZwAuAGwAbwBjAGEAbAADACQAYwBoAG8AdQBjAGgAbwB1AC4AagBsAGcALgBsAG8AYwBhAGwABQASAGoAbABnAC4AbABvAGMAYQBsAAcACADSVClUh73WAQYABAACAAAACAAwADAAAAAAAAAAAQAAAAAgAAC4YcwjyK/gKSgZikWqPXs8y5udtMrVNidXg4R7uFJFPgoAEAAAAAAAAAAAAAAAAAAAAAAACQAcAEgAVABUAFAALwBsAG8AYwBhAGwAaABvAHMAdAAAAAAAAAAAAAG7NbE8iPK1v5zqEu20+5Q=",
+		"TlRMTVNTUAADAAAAGAAYAGoAAAAYABgAggAAAAwADABAAAAACAAIAEwAAAAWABYAVAAAAAAAAACaAAAAAQIAAEQATwBNAEEASQBOAHUAcwBlAHIAVwBPAFIASwBTAFQAQQBUAEkATwBOAMM3zVy9RPyXgqZnr21CfG3mfCDC0+d8ViWpjBwx6BhHRmspst9GgPOZWPuMITqcxg==",
+	} {
+		var raw, _ = base64.StdEncoding.DecodeString(data)
+		msg, err := FromBytes(raw)
+		if err != nil {
+			t.Fatalf("FromBytes() error = %v", err)
+		}
+		want, err := json.Marshal(msg)
+		if err != nil {
+			t.Fatalf("json.Marshal() error = %v", err)
+		}
+
+		// JSON -> message -> wire bytes -> message gives the same JSON back
+		fromJSON, err := FromJSON(want)
+		if err != nil {
+			t.Fatalf("FromJSON() error = %v", err)
+		}
+		if reflect.TypeOf(fromJSON) != reflect.TypeOf(msg) {
+			t.Errorf("FromJSON() got = %T, want %T", fromJSON, msg)
+		}
+		wire, err := fromJSON.Bytes()
+		if err != nil {
+			t.Fatalf("Bytes() error = %v", err)
+		}
+		reparsed, err := FromBytes(wire)
+		if err != nil {
+			t.Fatalf("FromBytes(Bytes()) error = %v", err)
+		}
+		got, _ := json.Marshal(reparsed)
+		if string(got) != string(want) {
+			t.Errorf("json.Marshal() got = %s, want %s", got, want)
+		}
+	}
+}
+
+func TestJSONRaw(t *testing.T) {
+	// the OEM domain "DOMAI\x81" and an unpaired surrogate as TargetName
+	var type1, _ = hex.DecodeString("4e544c4d53535000010000000732000006000600330000000b000b0028000000050093080000000f574f524b53544154494f4e444f4d414981")
+	var type2, _ = NTLMType2{
+		Flags:          NTLMSSP_NEGOTIATE_UNICODE,
+		Challenge:      "0123456789abcdef",
+		TargetNameData: "\ufffd",
+		TargetNameRaw:  []byte{0x00, 0xd8},
+	}.Bytes()
+
+	tests := []struct {
+		name   string
+		data   []byte
+		opts   ParseOptions
+		member string
+	}{
+		{name: "code page", data: type1, opts: ParseOptions{CodePage: CP866}, member: `"codePage":866`},
+		{name: "raw", data: type2, member: `"targetNameRaw":"00d8"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var msg, err = tt.opts.FromBytes(tt.data)
+			if err != nil {
+				t.Fatalf("FromBytes() error = %v", err)
+			}
+			doc, err := json.Marshal(msg)
+			if err != nil {
+				t.Fatalf("json.Marshal() error = %v", err)
+			}
+			if !strings.Contains(string(doc), tt.member) {
+				t.Errorf("json.Marshal() got = %s, want %s", doc, tt.member)
+			}
+
+			fromJSON, err := FromJSON(doc)
+			if err != nil {
+				t.Fatalf("FromJSON() error = %v", err)
+			}
+			wire, err := fromJSON.Bytes()
+			if err != nil {
+				t.Fatalf("Bytes() error = %v", err)
+			}
+			reparsed, err := tt.opts.FromBytes(wire)
+			if err != nil {
+				t.Fatalf("FromBytes(Bytes()) error = %v", err)
+			}
+			got, _ := json.Marshal(reparsed)
+			if string(got) != string(doc) {
+				t.Errorf("json.Marshal() got = %s, want %s", got, doc)
+			}
+		})
+	}
+}
+
+func TestJSONErrors(t *testing.T) {
+	for _, data := range []string{
+		`{"schemaVersion":2,"messageType":"NEGOTIATE_MESSAGE"}`,
+		`{"schemaVersion":1,"messageType":"UNKNOWN"}`,
+		`{"schemaVersion":1,"messageType":"CHALLENGE_MESSAGE","targetInfo":[{"id":66,"value":"x"}]}`,
+	} {
+		if _, err := FromJSON([]byte(data)); err == nil {
+			t.Errorf("FromJSON(%s) error = nil", data)
+		}
+	}
+
+	var type1 NTLMType1
+	if err := json.Unmarshal([]byte(`{"schemaVersion":1,"messageType":"CHALLENGE_MESSAGE"}`), &type1); err == nil {
+		t.Errorf("json.Unmarshal() error = nil, want a messageType error")
+	}
+}
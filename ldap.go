@@ -0,0 +1,334 @@
+package ntlm_parser
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// LDAPOperation is the protocolOp of an LDAPMessage, its APPLICATION tag.
+//
+// reference: https://www.rfc-editor.org/rfc/rfc4511#section-4.2
+type LDAPOperation int
+
+const (
+	LDAPBindRequest  LDAPOperation = 0
+	LDAPBindResponse LDAPOperation = 1
+	LDAPUnbind       LDAPOperation = 2
+)
+
+func (op LDAPOperation) String() string {
+	switch op {
+	case LDAPBindRequest:
+		return "bindRequest"
+	case LDAPBindResponse:
+		return "bindResponse"
+	case LDAPUnbind:
+		return "unbindRequest"
+	}
+	return fmt.Sprintf("protocolOp(%d)", int(op))
+}
+
+// LDAPAuthentication is the AuthenticationChoice of a BindRequest, its
+// context tag. Tags 9 to 11 are the Microsoft "sicily" NTLM bind.
+//
+// reference: https://learn.microsoft.com/en-us/openspecs/windows_protocols/ms-adts/8b9dbfb2-5b6a-497a-a533-7e709cb9a982
+type LDAPAuthentication int
+
+const (
+	LDAPAuthSimple                 LDAPAuthentication = 0
+	LDAPAuthSASL                   LDAPAuthentication = 3
+	LDAPAuthSicilyPackageDiscovery LDAPAuthentication = 9
+	LDAPAuthSicilyNegotiate        LDAPAuthentication = 10
+	LDAPAuthSicilyResponse         LDAPAuthentication = 11
+)
+
+func (a LDAPAuthentication) String() string {
+	switch a {
+	case LDAPAuthSimple:
+		return "simple"
+	case LDAPAuthSASL:
+		return "sasl"
+	case LDAPAuthSicilyPackageDiscovery:
+		return "sicilyPackageDiscovery"
+	case LDAPAuthSicilyNegotiate:
+		return "sicilyNegotiate"
+	case LDAPAuthSicilyResponse:
+		return "sicilyResponse"
+	}
+	return fmt.Sprintf("authentication(%d)", int(a))
+}
+
+// LDAPResultCode is the resultCode of an LDAPResult.
+type LDAPResultCode int
+
+const (
+	LDAPSuccess                     LDAPResultCode = 0
+	LDAPProtocolError               LDAPResultCode = 2
+	LDAPStrongerAuthRequired        LDAPResultCode = 8
+	LDAPSASLBindInProgress          LDAPResultCode = 14
+	LDAPInappropriateAuthentication LDAPResultCode = 48
+	LDAPInvalidCredentials          LDAPResultCode = 49
+	LDAPUnwillingToPerform          LDAPResultCode = 53
+)
+
+var ldapResultCodeNames = map[LDAPResultCode]string{
+	LDAPSuccess:                     "success",
+	LDAPProtocolError:               "protocolError",
+	LDAPStrongerAuthRequired:        "strongerAuthRequired",
+	LDAPSASLBindInProgress:          "saslBindInProgress",
+	LDAPInappropriateAuthentication: "inappropriateAuthentication",
+	LDAPInvalidCredentials:          "invalidCredentials",
+	LDAPUnwillingToPerform:          "unwillingToPerform",
+}
+
+func (c LDAPResultCode) String() string {
+	if name, ok := ldapResultCodeNames[c]; ok {
+		return name
+	}
+	return fmt.Sprintf("resultCode(%d)", int(c))
+}
+
+// LDAPMessage is one LDAP message, the fields past MessageID and Operation
+// are only set for BindRequest and BindResponse.
+//
+// The NTLM message is taken from the SASL credentials (GSS-SPNEGO, bare or
+// in SPNEGO), the sicily credentials, the serverSaslCreds and, for the
+// sicily bind, from the matchedDN of the BindResponse where the server puts
+// its CHALLENGE message.
+type LDAPMessage struct {
+	MessageID int64
+	Operation LDAPOperation
+
+	// BindRequest
+	Version        int
+	Name           string
+	Authentication LDAPAuthentication
+	Mechanism      string // SASL only
+
+	// Credentials of the BindRequest, or serverSaslCreds of the
+	// BindResponse.
+	Credentials []byte
+
+	// BindResponse
+	ResultCode        LDAPResultCode
+	MatchedDN         []byte
+	DiagnosticMessage string
+
+	SPNEGO *SPNEGOToken
+	NTLM   NTLMMessage
+}
+
+func ParseLDAP(data []byte) ([]*LDAPMessage, error) {
+	return ParseOptions{}.ParseLDAP(data)
+}
+
+// ParseLDAP decodes the LDAPMessages of a stream. Messages wrapped by a SASL
+// security layer after the bind can't be decoded and end the stream with an
+// error.
+func (o ParseOptions) ParseLDAP(data []byte) ([]*LDAPMessage, error) {
+	var result []*LDAPMessage
+	for len(data) > 0 {
+		var message, rest, err = readBER(data)
+		if err != nil {
+			return nil, err
+		}
+		if message.class != berUniversal || message.tag != 16 || !message.constructed {
+			return nil, fmt.Errorf("%w: LDAPMessage is not a SEQUENCE", ErrBadLDAP)
+		}
+		m, err := o.parseLDAPMessage(message.content)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, m)
+		data = rest
+	}
+	return result, nil
+}
+
+func (o ParseOptions) parseLDAPMessage(data []byte) (*LDAPMessage, error) {
+	var id, rest, err = readBER(data)
+	if err != nil {
+		return nil, err
+	}
+	if id.class != berUniversal || id.tag != 2 {
+		return nil, fmt.Errorf("%w: messageID is not an INTEGER", ErrBadLDAP)
+	}
+	op, _, err := readBER(rest)
+	if err != nil {
+		return nil, err
+	}
+	if op.class != berApplication {
+		return nil, fmt.Errorf("%w: protocolOp is not an APPLICATION tag", ErrBadLDAP)
+	}
+
+	var m = &LDAPMessage{MessageID: berInteger(id.content), Operation: LDAPOperation(op.tag)}
+	switch m.Operation {
+	case LDAPBindRequest:
+		err = o.parseBindRequest(m, op.content)
+	case LDAPBindResponse:
+		err = o.parseBindResponse(m, op.content)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (o ParseOptions) parseBindRequest(m *LDAPMessage, data []byte) error {
+	var fields, err = readBERList(data, 3)
+	if err != nil {
+		return err
+	}
+	m.Version = int(berInteger(fields[0].content))
+	m.Name = string(fields[1].content)
+
+	var auth = fields[2]
+	if auth.class != berContext {
+		return fmt.Errorf("%w: AuthenticationChoice is not a context tag", ErrBadLDAP)
+	}
+	m.Authentication = LDAPAuthentication(auth.tag)
+	switch m.Authentication {
+	case LDAPAuthSASL:
+		// SaslCredentials ::= SEQUENCE { mechanism, credentials OPTIONAL }
+		var mechanism, rest, err = readBER(auth.content)
+		if err != nil {
+			return err
+		}
+		m.Mechanism = string(mechanism.content)
+		if len(rest) > 0 {
+			credentials, _, err := readBER(rest)
+			if err != nil {
+				return err
+			}
+			m.Credentials = credentials.content
+		}
+	case LDAPAuthSimple:
+		// the password stays out of the result
+		return nil
+	default:
+		m.Credentials = auth.content
+	}
+
+	if len(m.Credentials) == 0 {
+		return nil
+	}
+	return o.parseSecurityBlob(m.Credentials, &m.SPNEGO, &m.NTLM)
+}
+
+func (o ParseOptions) parseBindResponse(m *LDAPMessage, data []byte) error {
+	var fields, err = readBERList(data, 3)
+	if err != nil {
+		return err
+	}
+	m.ResultCode = LDAPResultCode(berInteger(fields[0].content))
+	m.MatchedDN = fields[1].content
+	m.DiagnosticMessage = string(fields[2].content)
+
+	// referral [3] and serverSaslCreds [7]
+	for rest := data; len(rest) > 0; {
+		var field berValue
+		if field, rest, err = readBER(rest); err != nil {
+			return err
+		}
+		if field.class == berContext && field.tag == 7 {
+			m.Credentials = field.content
+		}
+	}
+
+	switch {
+	case len(m.Credentials) > 0:
+		return o.parseSecurityBlob(m.Credentials, &m.SPNEGO, &m.NTLM)
+	case bytes.HasPrefix(m.MatchedDN, signature):
+		return o.parseSecurityBlob(m.MatchedDN, &m.SPNEGO, &m.NTLM)
+	}
+	return nil
+}
+
+// BER classes
+const (
+	berUniversal   = 0
+	berApplication = 1
+	berContext     = 2
+)
+
+// berValue is one BER element.
+type berValue struct {
+	class       int
+	tag         int
+	constructed bool
+	content     []byte
+}
+
+// readBER reads the element at the start of data. Unlike encoding/asn1 it
+// accepts the non-minimal lengths Active Directory sends, e.g. 84 00 00 00 07.
+func readBER(data []byte) (berValue, []byte, error) {
+	if len(data) < 2 {
+		return berValue{}, nil, fmt.Errorf("%w: BER element truncated", ErrBadLDAP)
+	}
+	var v = berValue{class: int(data[0] >> 6), constructed: data[0]&0x20 != 0, tag: int(data[0] & 0x1f)}
+	var i = 1
+	if v.tag == 0x1f {
+		v.tag = 0
+		for {
+			if i >= len(data) || i > 4 {
+				return berValue{}, nil, fmt.Errorf("%w: BER tag truncated", ErrBadLDAP)
+			}
+			v.tag = v.tag<<7 | int(data[i]&0x7f)
+			i++
+			if data[i-1]&0x80 == 0 {
+				break
+			}
+		}
+	}
+
+	if i >= len(data) {
+		return berValue{}, nil, fmt.Errorf("%w: BER length truncated", ErrBadLDAP)
+	}
+	var length = int(data[i])
+	i++
+	if length&0x80 != 0 {
+		var n = length & 0x7f
+		if n == 0 || n > 4 {
+			return berValue{}, nil, fmt.Errorf("%w: unsupported BER length", ErrBadLDAP)
+		}
+		if i+n > len(data) {
+			return berValue{}, nil, fmt.Errorf("%w: BER length truncated", ErrBadLDAP)
+		}
+		length = 0
+		for _, b := range data[i : i+n] {
+			length = length<<8 | int(b)
+		}
+		i += n
+	}
+	if length > len(data)-i {
+		return berValue{}, nil, fmt.Errorf("%w: BER element of %d bytes truncated", ErrBadLDAP, length)
+	}
+	v.content = data[i : i+length]
+	return v, data[i+length:], nil
+}
+
+// readBERList reads the first n elements of data.
+func readBERList(data []byte, n int) ([]berValue, error) {
+	var result []berValue
+	for len(result) < n {
+		var v, rest, err = readBER(data)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, v)
+		data = rest
+	}
+	return result, nil
+}
+
+// berInteger decodes a two's complement INTEGER or ENUMERATED.
+func berInteger(data []byte) int64 {
+	var result int64
+	for i, b := range data {
+		if i == 0 && b&0x80 != 0 {
+			result = -1
+		}
+		result = result<<8 | int64(b)
+	}
+	return result
+}
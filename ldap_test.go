@@ -0,0 +1,133 @@
+package ntlm_parser
+
+import (
+	"encoding/base64"
+	"errors"
+	"testing"
+)
+
+// ber encodes an element with the four byte length Active Directory uses.
+func ber(tag byte, content ...[]byte) []byte {
+	var data []byte
+	for _, c := range content {
+		data = append(data, c...)
+	}
+	var n = len(data)
+	return append([]byte{tag, 0x84, byte(n >> 24), byte(n >> 16), byte(n >> 8), byte(n)}, data...)
+}
+
+func ldapMessage(id byte, op []byte) []byte {
+	return ber(0x30, []byte{0x02, 0x01, id}, op)
+}
+
+func bindRequest(name string, auth []byte) []byte {
+	return ber(0x60, []byte{0x02, 0x01, 0x03}, ber(0x04, []byte(name)), auth)
+}
+
+func bindResponse(code byte, matchedDN []byte, extra ...[]byte) []byte {
+	return ber(0x61, append([][]byte{{0x0a, 0x01, code}, ber(0x04, matchedDN), ber(0x04)}, extra...)...)
+}
+
+func TestParseLDAP(t *testing.T) {
+	var type1, _ = base64.StdEncoding.DecodeString(scanType1)
+	var type2, _ = base64.StdEncoding.DecodeString(scanType2)
+	var type3, _ = base64.StdEncoding.DecodeString(scanType3)
+
+	var sasl = func(creds []byte) []byte {
+		return ber(0xa3, ber(0x04, []byte("GSS-SPNEGO")), ber(0x04, creds))
+	}
+
+	tests := []struct {
+		name   string
+		stream [][]byte
+		auth   []LDAPAuthentication
+		codes  []LDAPResultCode
+		want   []NTLMMessageType
+	}{
+		{
+			name: "GSS-SPNEGO",
+			stream: [][]byte{
+				ldapMessage(1, bindRequest("", sasl(mustWrap(t, scanType1)))),
+				ldapMessage(1, bindResponse(14, nil, ber(0x87, mustWrap(t, scanType2)))),
+				ldapMessage(2, bindRequest("", sasl(mustWrap(t, scanType3)))),
+				ldapMessage(2, bindResponse(0, nil)),
+				ldapMessage(3, []byte{0x42, 0x00}),
+			},
+			auth:  []LDAPAuthentication{LDAPAuthSASL, 0, LDAPAuthSASL, 0, 0},
+			codes: []LDAPResultCode{0, LDAPSASLBindInProgress, 0, LDAPSuccess, 0},
+			want:  []NTLMMessageType{NEGOTIATE_MESSAGE, CHALLENGE_MESSAGE, AUTHENTICATE_MESSAGE, "", ""},
+		},
+		{
+			name: "GSS-SPNEGO raw NTLM",
+			stream: [][]byte{
+				ldapMessage(1, bindRequest("", sasl(type1))),
+				ldapMessage(1, bindResponse(14, nil, ber(0x87, type2))),
+			},
+			auth:  []LDAPAuthentication{LDAPAuthSASL, 0},
+			codes: []LDAPResultCode{0, LDAPSASLBindInProgress},
+			want:  []NTLMMessageType{NEGOTIATE_MESSAGE, CHALLENGE_MESSAGE},
+		},
+		{
+			name: "sicily",
+			stream: [][]byte{
+				ldapMessage(1, bindRequest("", ber(0x89))),
+				ldapMessage(1, bindResponse(0, []byte("NTLM;NEGOTIATE"))),
+				ldapMessage(2, bindRequest("NTLM", ber(0x8a, type1))),
+				ldapMessage(2, bindResponse(0, type2)),
+				ldapMessage(3, bindRequest("NTLM", ber(0x8b, type3))),
+				ldapMessage(3, bindResponse(49, nil)),
+			},
+			auth:  []LDAPAuthentication{LDAPAuthSicilyPackageDiscovery, 0, LDAPAuthSicilyNegotiate, 0, LDAPAuthSicilyResponse, 0},
+			codes: []LDAPResultCode{0, 0, 0, 0, 0, LDAPInvalidCredentials},
+			want:  []NTLMMessageType{"", "", NEGOTIATE_MESSAGE, CHALLENGE_MESSAGE, AUTHENTICATE_MESSAGE, ""},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stream []byte
+			for _, m := range tt.stream {
+				stream = append(stream, m...)
+			}
+			var messages, err = ParseLDAP(stream)
+			if err != nil {
+				t.Fatalf("ParseLDAP() error = %v", err)
+			}
+			if len(messages) != len(tt.want) {
+				t.Fatalf("ParseLDAP() got %d messages, want %d", len(messages), len(tt.want))
+			}
+			for i, m := range messages {
+				if got := messageType(m.NTLM); got != tt.want[i] {
+					t.Errorf("message %d: NTLM = %s, want %s", i, got, tt.want[i])
+				}
+				if m.Authentication != tt.auth[i] || m.ResultCode != tt.codes[i] {
+					t.Errorf("message %d: got %s %s, want %s %s", i, m.Authentication, m.ResultCode, tt.auth[i], tt.codes[i])
+				}
+				if m.MessageID != int64(i/2+1) {
+					t.Errorf("message %d: MessageID = %d", i, m.MessageID)
+				}
+			}
+		})
+	}
+}
+
+func TestParseLDAPErrors(t *testing.T) {
+	var bind = ldapMessage(1, bindRequest("", ber(0x8a, []byte("NTLMSSP\x00"))))
+	tests := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{name: "truncated", data: bind[:len(bind)-1], want: ErrBadLDAP},
+		{name: "not a SEQUENCE", data: []byte{0x04, 0x00}, want: ErrBadLDAP},
+		{name: "indefinite length", data: []byte{0x30, 0x80, 0x00, 0x00}, want: ErrBadLDAP},
+		{name: "missing protocolOp", data: ldapMessage(1, nil), want: ErrBadLDAP},
+		{name: "truncated NTLM message", data: bind, want: ErrTruncated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseLDAP(tt.data); !errors.Is(err, tt.want) {
+				t.Errorf("ParseLDAP() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,237 @@
+package ntlm_parser
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strings"
+)
+
+type MailProtocol int
+
+const (
+	SMTP MailProtocol = iota
+	IMAP
+	POP3
+)
+
+func (p MailProtocol) String() string {
+	switch p {
+	case SMTP:
+		return "SMTP"
+	case IMAP:
+		return "IMAP"
+	case POP3:
+		return "POP3"
+	}
+	return fmt.Sprintf("MailProtocol(%d)", int(p))
+}
+
+// MailExchange is one NTLM authentication of a mail transcript, from the
+// AUTH or AUTHENTICATE command to the server's final reply. The messages
+// are nil when the transcript doesn't have them, e.g. a cancelled or
+// truncated authentication.
+type MailExchange struct {
+	Protocol MailProtocol
+	Tag      string // IMAP only
+	Line     int    // line of the AUTH or AUTHENTICATE command, from 1
+
+	Negotiate    NTLMMessage
+	Challenge    NTLMMessage
+	Authenticate NTLMMessage
+
+	// Response is the final reply of the server, e.g. "235 2.7.0
+	// Authentication successful", empty when the transcript ends first.
+	Response string
+}
+
+// Messages returns the messages of the exchange in order, the missing ones
+// left out.
+func (e *MailExchange) Messages() []NTLMMessage {
+	var result []NTLMMessage
+	for _, msg := range []NTLMMessage{e.Negotiate, e.Challenge, e.Authenticate} {
+		if msg != nil {
+			result = append(result, msg)
+		}
+	}
+	return result
+}
+
+// Succeeded tells whether the server accepted the credentials.
+func (e *MailExchange) Succeeded() bool {
+	switch e.Protocol {
+	case SMTP:
+		return strings.HasPrefix(e.Response, "2")
+	case IMAP:
+		var status, _, _ = strings.Cut(strings.TrimPrefix(e.Response, e.Tag+" "), " ")
+		return strings.EqualFold(status, "OK")
+	case POP3:
+		return strings.HasPrefix(e.Response, "+OK")
+	}
+	return false
+}
+
+func ParseSMTP(r io.Reader) ([]MailExchange, error) {
+	return ParseOptions{}.ParseSMTP(r)
+}
+
+func ParseIMAP(r io.Reader) ([]MailExchange, error) {
+	return ParseOptions{}.ParseIMAP(r)
+}
+
+func ParsePOP3(r io.Reader) ([]MailExchange, error) {
+	return ParseOptions{}.ParsePOP3(r)
+}
+
+// ParseSMTP reads the AUTH NTLM exchanges of an SMTP transcript, the
+// client and server lines in the order they were sent. Lines may carry the
+// "C: " and "S: " prefixes of the RFC examples.
+//
+// reference: https://www.rfc-editor.org/rfc/rfc4954#section-4
+func (o ParseOptions) ParseSMTP(r io.Reader) ([]MailExchange, error) {
+	return o.parseMail(r, SMTP)
+}
+
+// ParseIMAP reads the AUTHENTICATE NTLM exchanges of an IMAP transcript,
+// see ParseSMTP.
+//
+// reference: https://www.rfc-editor.org/rfc/rfc3501#section-6.2.2
+func (o ParseOptions) ParseIMAP(r io.Reader) ([]MailExchange, error) {
+	return o.parseMail(r, IMAP)
+}
+
+// ParsePOP3 reads the AUTH NTLM exchanges of a POP3 transcript, see
+// ParseSMTP.
+//
+// reference: https://www.rfc-editor.org/rfc/rfc5034#section-4
+func (o ParseOptions) ParsePOP3(r io.Reader) ([]MailExchange, error) {
+	return o.parseMail(r, POP3)
+}
+
+// mail states, who sends the next line of an authentication
+const (
+	mailIdle = iota
+	mailServer
+	mailClient
+)
+
+func (o ParseOptions) parseMail(r io.Reader, protocol MailProtocol) ([]MailExchange, error) {
+	var lines = bufio.NewScanner(r)
+	// an AUTHENTICATE message of MaxMessageSize bytes, in base64
+	var size = o.maxMessageSize()
+	if size < 0 {
+		size = maxBlockSize
+	}
+	lines.Buffer(nil, size/3*4+1024)
+
+	var result []MailExchange
+	var exchange *MailExchange
+	var state = mailIdle
+	for n := 1; lines.Scan(); n++ {
+		var line = strings.TrimRight(lines.Text(), "\r")
+		line = strings.TrimPrefix(strings.TrimPrefix(line, "C: "), "S: ")
+
+		var payload string
+		switch state {
+		case mailIdle:
+			var tag, initial, ok = authCommand(protocol, line)
+			if !ok {
+				continue
+			}
+			result = append(result, MailExchange{Protocol: protocol, Tag: tag, Line: n})
+			exchange, state, payload = &result[len(result)-1], mailServer, initial
+		case mailServer:
+			if protocol == IMAP && strings.HasPrefix(line, "* ") {
+				continue // untagged response
+			}
+			var continuation, ok = mailContinuation(protocol, line)
+			if !ok {
+				exchange.Response = line
+				state = mailIdle
+				continue
+			}
+			state, payload = mailClient, continuation
+		case mailClient:
+			state, payload = mailServer, line
+			if payload == "*" {
+				continue // cancelled
+			}
+		}
+
+		if err := o.addMailMessage(exchange, payload); err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+	}
+	if err := lines.Err(); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// authCommand matches the command starting an NTLM authentication, and
+// returns the IMAP tag and the initial response.
+func authCommand(protocol MailProtocol, line string) (tag, initial string, ok bool) {
+	var fields = strings.Fields(line)
+	if protocol == IMAP {
+		if len(fields) < 1 {
+			return "", "", false
+		}
+		tag, fields = fields[0], fields[1:]
+	}
+
+	var command = "AUTH"
+	if protocol == IMAP {
+		command = "AUTHENTICATE"
+	}
+	if len(fields) < 2 || len(fields) > 3 || !strings.EqualFold(fields[0], command) || !strings.EqualFold(fields[1], "NTLM") {
+		return "", "", false
+	}
+	if len(fields) == 3 {
+		initial = fields[2]
+	}
+	return tag, initial, true
+}
+
+// mailContinuation matches a server continuation and returns its payload,
+// "334" for SMTP and "+" for IMAP and POP3.
+func mailContinuation(protocol MailProtocol, line string) (string, bool) {
+	var prefix = "+"
+	if protocol == SMTP {
+		prefix = "334"
+	}
+	if line == prefix {
+		return "", true
+	}
+	if strings.HasPrefix(line, prefix+" ") {
+		return strings.TrimSpace(line[len(prefix)+1:]), true
+	}
+	return "", false
+}
+
+// addMailMessage decodes a payload into its field of the exchange. Empty
+// payloads, "=" and text that isn't base64 such as "334 NTLM supported"
+// are left out.
+func (o ParseOptions) addMailMessage(exchange *MailExchange, payload string) error {
+	if payload == "" || payload == "=" {
+		return nil
+	}
+	var data, err = base64.StdEncoding.DecodeString(payload)
+	if err != nil {
+		return nil
+	}
+
+	msg, err := o.FromBytes(data)
+	if err != nil {
+		return err
+	}
+	switch messageType(msg) {
+	case NEGOTIATE_MESSAGE:
+		exchange.Negotiate = msg
+	case CHALLENGE_MESSAGE:
+		exchange.Challenge = msg
+	case AUTHENTICATE_MESSAGE:
+		exchange.Authenticate = msg
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package ntlm_parser
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseMail(t *testing.T) {
+	tests := []struct {
+		name       string
+		parse      func(ParseOptions, string) ([]MailExchange, error)
+		transcript []string
+		want       [][]NTLMMessageType
+		succeeded  []bool
+	}{
+		{
+			name: "SMTP",
+			parse: func(o ParseOptions, s string) ([]MailExchange, error) {
+				return o.ParseSMTP(strings.NewReader(s))
+			},
+			transcript: []string{
+				"S: 220 mail.example.com Microsoft ESMTP MAIL Service ready",
+				"C: EHLO client.example.com",
+				"S: 250-mail.example.com Hello",
+				"S: 250 AUTH NTLM",
+				"C: AUTH NTLM",
+				"S: 334 NTLM supported",
+				"C: " + scanType1,
+				"S: 334 " + scanType2,
+				"C: " + scanType3,
+				"S: 535 5.7.3 Authentication unsuccessful",
+				"C: AUTH NTLM " + scanType1,
+				"S: 334 " + scanType2,
+				"C: " + scanType3,
+				"S: 235 2.7.0 Authentication successful",
+				"C: QUIT",
+			},
+			want: [][]NTLMMessageType{
+				{NEGOTIATE_MESSAGE, CHALLENGE_MESSAGE, AUTHENTICATE_MESSAGE},
+				{NEGOTIATE_MESSAGE, CHALLENGE_MESSAGE, AUTHENTICATE_MESSAGE},
+			},
+			succeeded: []bool{false, true},
+		},
+		{
+			name: "IMAP",
+			parse: func(o ParseOptions, s string) ([]MailExchange, error) {
+				return o.ParseIMAP(strings.NewReader(s))
+			},
+			transcript: []string{
+				"* OK The Microsoft Exchange IMAP4 service is ready.",
+				"a1 CAPABILITY",
+				"* CAPABILITY IMAP4 IMAP4rev1 AUTH=NTLM SASL-IR",
+				"a1 OK CAPABILITY completed.",
+				"a2 AUTHENTICATE NTLM",
+				"+ ",
+				"*",
+				"a2 BAD Command Argument Error.",
+				"a3 AUTHENTICATE NTLM " + scanType1,
+				"+ " + scanType2,
+				scanType3,
+				"* CAPABILITY IMAP4rev1",
+				"a3 OK AUTHENTICATE completed.",
+			},
+			want:      [][]NTLMMessageType{nil, {NEGOTIATE_MESSAGE, CHALLENGE_MESSAGE, AUTHENTICATE_MESSAGE}},
+			succeeded: []bool{false, true},
+		},
+		{
+			name: "POP3",
+			parse: func(o ParseOptions, s string) ([]MailExchange, error) {
+				return o.ParsePOP3(strings.NewReader(s))
+			},
+			transcript: []string{
+				"+OK The Microsoft Exchange POP3 service is ready.",
+				"AUTH NTLM",
+				"+ ",
+				scanType1,
+				"+ " + scanType2,
+				scanType3,
+				"+OK User successfully authenticated.",
+				"auth ntlm",
+				"+",
+				scanType1,
+				"+ " + scanType2,
+			},
+			want:      [][]NTLMMessageType{{NEGOTIATE_MESSAGE, CHALLENGE_MESSAGE, AUTHENTICATE_MESSAGE}, {NEGOTIATE_MESSAGE, CHALLENGE_MESSAGE}},
+			succeeded: []bool{true, false},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var exchanges, err = tt.parse(ParseOptions{}, strings.Join(tt.transcript, "\r\n")+"\r\n")
+			if err != nil {
+				t.Fatalf("parse error = %v", err)
+			}
+			if len(exchanges) != len(tt.want) {
+				t.Fatalf("got %d exchanges, want %d", len(exchanges), len(tt.want))
+			}
+			for i, e := range exchanges {
+				var got []NTLMMessageType
+				for _, msg := range e.Messages() {
+					got = append(got, messageType(msg))
+				}
+				if !reflect.DeepEqual(got, tt.want[i]) {
+					t.Errorf("exchange %d: messages = %v, want %v", i, got, tt.want[i])
+				}
+				if e.Succeeded() != tt.succeeded[i] {
+					t.Errorf("exchange %d: Succeeded() = %v, response %q", i, e.Succeeded(), e.Response)
+				}
+			}
+		})
+	}
+}
+
+func TestParseMailErrors(t *testing.T) {
+	var _, err = ParseSMTP(strings.NewReader("AUTH NTLM\r\n334 TlRMTVNTUAAB\r\n"))
+	if !errors.Is(err, ErrTruncated) || !strings.HasPrefix(err.Error(), "line 2: ") {
+		t.Errorf("ParseSMTP() error = %v, want %v on line 2", err, ErrTruncated)
+	}
+
+	_, err = ParseOptions{MaxMessageSize: 16}.ParseIMAP(strings.NewReader("a1 AUTHENTICATE NTLM " + scanType1 + "\r\n"))
+	if !errors.Is(err, ErrMessageTooLarge) {
+		t.Errorf("ParseIMAP() error = %v, want %v", err, ErrMessageTooLarge)
+	}
+}
@@ -0,0 +1,369 @@
+package ntlm_parser
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// writer lays out one message, the fixed header first and then the
+// payload of every security buffer in the order they are written. The
+// first failure is kept in err.
+type writer struct {
+	buf []byte
+	err error
+
+	// original is set by patchWriter
+	original []byte
+
+	// codePage encodes the OEM strings
+	codePage CodePage
+}
+
+func newWriter(messageType uint32, headerSize int) *writer {
+	var w = &writer{buf: make([]byte, headerSize)}
+	copy(w.buf[0:8], signature)
+	binary.LittleEndian.PutUint32(w.buf[8:12], messageType)
+	return w
+}
+
+func (w *writer) fail(field string, err error) {
+	if w.err == nil {
+		w.err = fmt.Errorf("marshal %s: %w", field, err)
+	}
+}
+
+func (w *writer) uint32(offset int, value uint32) {
+	binary.LittleEndian.PutUint32(w.buf[offset:offset+4], value)
+}
+
+// hex writes a hex string that must decode to exactly length bytes, an
+// empty string is written as zeros.
+func (w *writer) hex(field string, offset, length int, str string) {
+	var data, err = hex.DecodeString(str)
+	if err == nil && len(data) != length && str != "" {
+		err = fmt.Errorf("%d bytes, want %d", len(data), length)
+	}
+	if err != nil {
+		w.fail(field, err)
+		return
+	}
+	copy(w.buf[offset:offset+length], data)
+}
+
+func (w *writer) version(offset int, v OSVersionStructure) {
+	w.buf[offset] = byte(v.MajorVersion)
+	w.buf[offset+1] = byte(v.MinorVersion)
+	binary.LittleEndian.PutUint16(w.buf[offset+2:offset+4], uint16(v.BuildNumber))
+	copy(w.buf[offset+4:offset+7], v.Reserved[:])
+	w.buf[offset+7] = byte(v.NTLMRevisionCurrent)
+}
+
+// payload appends data and writes the security buffer that points to it at
+// offset.
+func (w *writer) payload(field string, offset int, data []byte) SecurityBuffer {
+	if len(data) > math.MaxUint16 {
+		w.fail(field, errors.New("payload longer than 65535 bytes"))
+		data = nil
+	}
+	if w.original != nil {
+		return w.patch(offset, data)
+	}
+	return w.append(offset, data)
+}
+
+func (w *writer) append(offset int, data []byte) SecurityBuffer {
+	var secBuf = SecurityBuffer{Length: len(data), Allocated: len(data), Offset: len(w.buf)}
+	binary.LittleEndian.PutUint16(w.buf[offset:offset+2], uint16(secBuf.Length))
+	binary.LittleEndian.PutUint16(w.buf[offset+2:offset+4], uint16(secBuf.Allocated))
+	binary.LittleEndian.PutUint32(w.buf[offset+4:offset+8], uint32(secBuf.Offset))
+	w.buf = append(w.buf, data...)
+	return secBuf
+}
+
+func (w *writer) string(field string, str string, raw []byte, flag NegotiateFlags) []byte {
+	var data, err = encodeString(str, raw, flag, w.codePage)
+	if err != nil {
+		w.fail(field, err)
+	}
+	return data
+}
+
+func (w *writer) bytes() ([]byte, error) {
+	if w.err != nil {
+		return nil, w.err
+	}
+	return w.buf, nil
+}
+
+// encodeString is the reverse of getSecBufDataWithFlag. raw is kept when it
+// still decodes to str, so bytes that have several encodings survive.
+func encodeString(str string, raw []byte, flag NegotiateFlags, codePage CodePage) ([]byte, error) {
+	if flag.Has(NTLMSSP_NEGOTIATE_UNICODE) {
+		if raw != nil && bytesToUCS2(raw) == str {
+			return raw, nil
+		}
+		return stringToUCS2(str), nil
+	}
+
+	if raw != nil && codePage.Decode(raw) == str {
+		return raw, nil
+	}
+	return codePage.Encode(str)
+}
+
+// encodeAvPairs writes every AV pair as given, the AvId and Value come from
+// Value and a TargetInfo without Value is encoded from Type and Content.
+func encodeAvPairs(list AvPairList) ([]byte, error) {
+	var result []byte
+	for _, info := range list {
+		var id = AvID(info.Type)
+		var value []byte
+		switch {
+		case info.Value != nil:
+			id, value = info.Value.AvID(), info.Value.encodeValue()
+		case id == MsvAvEOL:
+		case id <= MsvAvDnsTreeName || id == MsvAvTargetName:
+			value = stringToUCS2(info.Content)
+		default:
+			return nil, fmt.Errorf("AV pair %d has no Value", info.Type)
+		}
+		if len(value) > math.MaxUint16 {
+			return nil, fmt.Errorf("AV pair %d longer than 65535 bytes", id)
+		}
+
+		result = binary.LittleEndian.AppendUint16(result, uint16(id))
+		result = binary.LittleEndian.AppendUint16(result, uint16(len(value)))
+		result = append(result, value...)
+	}
+	return result, nil
+}
+
+// Bytes encodes the message, the VERSION structure is written when
+// NTLMSSP_NEGOTIATE_VERSION is set or OsVersionStructure isn't empty.
+// Parsing the result gives back the same message with recomputed
+// SecurityBuffer fields. A parsed message is returned as it was parsed or
+// patched instead, see Original.
+func (N NTLMType1) Bytes() ([]byte, error) {
+	if unmodified(&N) {
+		return append([]byte(nil), N.original...), nil
+	}
+	var hasVersion = N.Flags.Has(NTLMSSP_NEGOTIATE_VERSION) || N.OsVersionStructure != OSVersionStructure{}
+	var headerSize = 32
+	if hasVersion {
+		headerSize = 40
+	}
+
+	// a parsed message is patched when its header has room for every field
+	// that is set, the flags don't matter
+	var need = 16
+	switch {
+	case N.OsVersionStructure != OSVersionStructure{}:
+		need = 40
+	case N.SuppliedDomainData != "" || N.SuppliedWorkstationData != "":
+		need = 32
+	}
+
+	var w = newWriter(1, headerSize)
+	if layout := type1HeaderSize(N.original); layout >= need {
+		w, headerSize = patchWriter(N.original), layout
+	}
+	w.codePage = N.CodePage
+	w.uint32(12, uint32(N.Flags))
+	if headerSize >= 40 {
+		w.version(32, N.OsVersionStructure)
+	}
+
+	// the supplied domain and workstation are always OEM encoded
+	if headerSize >= 32 {
+		w.payload("DomainName", 16, w.string("DomainName", N.SuppliedDomainData, N.SuppliedDomainRaw, 0))
+		w.payload("WorkstationName", 24, w.string("WorkstationName", N.SuppliedWorkstationData, N.SuppliedWorkstationRaw, 0))
+	}
+
+	return w.bytes()
+}
+
+func (N NTLMType1) MarshalBinary() ([]byte, error) {
+	return N.Bytes()
+}
+
+// Bytes encodes the message, Context and TargetInfo are written when any
+// of them is set or NTLMSSP_NEGOTIATE_TARGET_INFO is, the VERSION structure
+// when NTLMSSP_NEGOTIATE_VERSION is set or OsVersionStructure isn't empty.
+func (N NTLMType2) Bytes() ([]byte, error) {
+	if unmodified(&N) {
+		return append([]byte(nil), N.original...), nil
+	}
+	var hasVersion = N.Flags.Has(NTLMSSP_NEGOTIATE_VERSION) || N.OsVersionStructure != OSVersionStructure{}
+	var hasTargetInfo = hasVersion || N.Flags.Has(NTLMSSP_NEGOTIATE_TARGET_INFO) ||
+		len(N.TargetInfoData) != 0 || N.Context != ""
+	var headerSize = 32
+	if hasVersion {
+		headerSize = 56
+	} else if hasTargetInfo {
+		headerSize = 48
+	}
+
+	var need = 32
+	switch {
+	case N.OsVersionStructure != OSVersionStructure{}:
+		need = 56
+	case len(N.TargetInfoData) != 0 || N.Context != "":
+		need = 48
+	}
+
+	var w = newWriter(2, headerSize)
+	if layout := type2HeaderSize(N.original); layout >= need {
+		w, headerSize = patchWriter(N.original), layout
+	}
+	w.codePage = N.CodePage
+	w.uint32(20, uint32(N.Flags))
+	w.hex("ServerChallenge", 24, 8, N.Challenge)
+
+	w.payload("TargetName", 12, w.string("TargetName", N.TargetNameData, N.TargetNameRaw, N.Flags))
+
+	if headerSize >= 48 {
+		w.hex("Reserved", 32, 8, N.Context)
+		var targetInfo, err = patchAvPairs(N.TargetInfoData, w.previous(40))
+		if err != nil {
+			w.fail("TargetInfo", err)
+		}
+		w.payload("TargetInfo", 40, targetInfo)
+	}
+	if headerSize >= 56 {
+		w.version(48, N.OsVersionStructure)
+	}
+
+	return w.bytes()
+}
+
+func (N NTLMType2) MarshalBinary() ([]byte, error) {
+	return N.Bytes()
+}
+
+// Bytes encodes an AUTHENTICATE message without EncryptedRandomSessionKey,
+// NegotiateFlags and VERSION, its strings are always UCS-2.
+func (N NTLMType3v1) Bytes() ([]byte, error) {
+	if unmodified(&N) {
+		return append([]byte(nil), N.original...), nil
+	}
+	var w = type3Writer(N.original, 52)
+	N.writePayload(w, NTLMSSP_NEGOTIATE_UNICODE)
+	return w.bytes()
+}
+
+func (N NTLMType3v1) MarshalBinary() ([]byte, error) {
+	return N.Bytes()
+}
+
+func (N NTLMType3v2) Bytes() ([]byte, error) {
+	if unmodified(&N) {
+		return append([]byte(nil), N.original...), nil
+	}
+	var w = type3Writer(N.original, 64)
+	N.writeHeader(w)
+	N.writePayload(w, N.Flags)
+	return w.bytes()
+}
+
+func (N NTLMType3v2) MarshalBinary() ([]byte, error) {
+	return N.Bytes()
+}
+
+// Bytes encodes the message with the MIC when it is set.
+func (N NTLMType3v3) Bytes() ([]byte, error) {
+	if unmodified(&N) {
+		return append([]byte(nil), N.original...), nil
+	}
+	var headerSize = 72
+	if N.MIC != "" {
+		headerSize = 88
+	}
+
+	var w = type3Writer(N.original, headerSize)
+	N.writeHeader(w)
+	w.version(64, N.OsVersionStructure)
+	if N.MIC != "" {
+		w.hex("MIC", 72, 16, N.MIC)
+	}
+	N.writePayload(w, N.Flags)
+	return w.bytes()
+}
+
+func (N NTLMType3v3) MarshalBinary() ([]byte, error) {
+	return N.Bytes()
+}
+
+func (N NTLMType3v2) writeHeader(w *writer) {
+	w.uint32(60, uint32(N.Flags))
+}
+
+// writePayload writes the payload in the order Windows does.
+func (N NTLMType3v1) writePayload(w *writer, flag NegotiateFlags) {
+	w.codePage = N.CodePage
+	var targetName = w.string("DomainName", N.TargetNameData, N.TargetNameRaw, flag)
+	var userName = w.string("UserName", N.UserNameData, N.UserNameRaw, flag)
+	var workstationName = w.string("Workstation", N.WorkstationNameData, N.WorkstationNameRaw, flag)
+	lmResponse, err := hex.DecodeString(N.LmResponseData.Hex)
+	if err != nil {
+		w.fail("LmChallengeResponse", err)
+	}
+	ntlmResponse, err := N.NtlmResponseData.bytes(w.previous(20))
+	if err != nil {
+		w.fail("NtChallengeResponse", err)
+	}
+
+	w.payload("DomainName", 28, targetName)
+	w.payload("UserName", 36, userName)
+	w.payload("Workstation", 44, workstationName)
+	w.payload("LmChallengeResponse", 12, lmResponse)
+	w.payload("NtChallengeResponse", 20, ntlmResponse)
+}
+
+func (N NTLMType3v2) writePayload(w *writer, flag NegotiateFlags) {
+	N.NTLMType3v1.writePayload(w, flag)
+
+	var sessionKey = N.SessionKeyData.Raw
+	if sessionKey == nil {
+		var err error
+		if sessionKey, err = hex.DecodeString(N.SessionKeyData.Hex); err != nil {
+			w.fail("EncryptedRandomSessionKey", err)
+		}
+	}
+	w.payload("EncryptedRandomSessionKey", 52, sessionKey)
+}
+
+// bytes returns Hex, or the encoded NTLMv2Response when Hex is empty or
+// still the previous response.
+func (N NTLMResponseData) bytes(previous []byte) ([]byte, error) {
+	switch {
+	case N.NTLMv2Response == nil || N.Hex != "" && changed(N.Hex, previous):
+		return hex.DecodeString(N.Hex)
+	case previous != nil:
+		return N.NTLMv2Response.patch(previous)
+	}
+	return N.NTLMv2Response.Bytes()
+}
+
+// Bytes encodes the NTProofStr followed by the NTLMv2_CLIENT_CHALLENGE.
+func (N NTLMv2Response) Bytes() ([]byte, error) {
+	var w = &writer{buf: make([]byte, 44)}
+	N.writeHeader(w)
+
+	var avPairs, err = encodeAvPairs(N.AvPairs)
+	if err != nil {
+		return nil, err
+	}
+	w.buf = append(w.buf, avPairs...)
+	return w.bytes()
+}
+
+func (N NTLMv2Response) writeHeader(w *writer) {
+	w.hex("NTProofStr", 0, 16, N.NTProofStr)
+	w.buf[16] = byte(N.RespType)
+	w.buf[17] = byte(N.HiRespType)
+	binary.LittleEndian.PutUint64(w.buf[24:32], dateToFileTime(N.Timestamp))
+	w.hex("ChallengeFromClient", 32, 8, N.ClientChallenge)
+}
@@ -0,0 +1,288 @@
+package ntlm_parser
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestBytesRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{
+			name: "NTLM Type 1 (base64)",
+			data: "TlRMTVNTUAABAAAAB4IIogAAAAAAAAAAAAAAAAAAAAAKALpHAAAADw==",
+		},
+		{
+			name: "NTLM Type 1 (hex)",
+			data: "TlRMTVNTUAABAAAABzIAAAYABgAzAAAACwALACgAAAAFAJMIAAAAD1dPUktTVEFUSU9ORE9NQUlO",
+		},
+		{
+			name: "NTLM Type 2 (base64)",
+			data: "TlRMTVNTUAACAAAABgAGADgAAAA1goniaaCGDXCRRNUAAAAAAAAAAIIAggA+AAAACgC6RwAAAA9KAEwARwACAAYASgBMAEcAAQAQAEMASABPAFUAQwBIAE8AVQAEABIAagBsAGcALgBsAG8AYwBhAGwAAwAkAGMAaABvAHUAYwBoAG8AdQAuAGoAbABnAC4AbABvAGMAYQBsAAUAEgBqAGwAZwAuAGwAbwBjAGEAbAAHAAgAQH6UJ9691gEAAAAA",
+		},
+		{
+			name: "NTLM Type 3 (base64)",
+			data: "TlRMTVNTUAADAAAAGAAYAHQAAAAiASIBjAAAAAAAAABYAAAADAAMAFgAAAAQABAAZAAAABAAEACuAQAANYKI4goAukcAAAAP1KMCweXeFIr6zmSmiHFWSWoAbABvAHUAaQBzAEMASABPAFUAQwBIAE8AVQAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAC5/Vhnk2GTLD131k8cNfZcAQEAAAAAAADSVClUh73WAX873ENT+QbPAAAAAAIABgBKAEwARwABABAAQwBIAE8AVQBDAEgATwBVAAQAEgBqAGwAZwAuAGwAbwBjAGEAbAADACQAYwBoAG8AdQBjAGgAbwB1AC4AagBsAGcALgBsAG8AYwBhAGwABQASAGoAbABnAC4AbABvAGMAYQBsAAcACADSVClUh73WAQYABAACAAAACAAwADAAAAAAAAAAAQAAAAAgAAC4YcwjyK/gKSgZikWqPXs8y5udtMrVNidXg4R7uFJFPgoAEAAAAAAAAAAAAAAAAAAAAAAACQAcAEgAVABUAFAALwBsAG8AYwBhAGwAaABvAHMAdAAAAAAAAAAAAAG7NbE8iPK1v5zqEu20+5Q=",
+		},
+		{
+			name: "NTLM Type 3 (hex)",
+			data: "TlRMTVNTUAADAAAAGAAYAGoAAAAYABgAggAAAAwADABAAAAACAAIAEwAAAAWABYAVAAAAAAAAACaAAAAAQIAAEQATwBNAEEASQBOAHUAcwBlAHIAVwBPAFIASwBTAFQAQQBUAEkATwBOAMM3zVy9RPyXgqZnr21CfG3mfCDC0+d8ViWpjBwx6BhHRmspst9GgPOZWPuMITqcxg==",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var data, _ = base64.StdEncoding.DecodeString(tt.data)
+			msg, err := FromBytes(data)
+			if err != nil {
+				t.Fatalf("FromBytes() error = %v", err)
+			}
+
+			got, err := msg.Bytes()
+			if err != nil {
+				t.Fatalf("Bytes() error = %v", err)
+			}
+			if !bytes.Equal(got, data) {
+				t.Errorf("Bytes() got = %x, want %x", got, data)
+			}
+
+			msg2, err := FromBytes(got)
+			if err != nil {
+				t.Fatalf("FromBytes(Bytes()) error = %v", err)
+			}
+			got2, _ := msg2.Bytes()
+			if !bytes.Equal(got, got2) {
+				t.Errorf("Bytes(FromBytes(Bytes())) got = %x, want %x", got2, got)
+			}
+			if reflect.TypeOf(msg) != reflect.TypeOf(msg2) {
+				t.Errorf("FromBytes(Bytes()) got = %T, want %T", msg2, msg)
+			}
+		})
+	}
+}
+
+func TestNTLMType2Bytes(t *testing.T) {
+	var msg = NTLMType2{
+		Flags:          NTLMSSP_NEGOTIATE_UNICODE | NTLMSSP_NEGOTIATE_NTLM | NTLMSSP_NEGOTIATE_TARGET_INFO,
+		Challenge:      "0123456789abcdef",
+		TargetNameData: "DOMAIN",
+		TargetInfoData: AvPairList{
+			{Value: AvNbDomainName("DOMAIN")},
+			{Type: int(MsvAvDnsComputerName), Content: "server.domain.com"},
+			{Value: AvTimestamp{Time: time.Date(2020, 11, 18, 19, 8, 9, 844076800, time.UTC)}},
+			{Value: AvRaw{ID: 0x42, Value: []byte{0xaa}}},
+			{Value: AvEOL{}},
+		},
+	}
+
+	data, err := msg.Bytes()
+	if err != nil {
+		t.Fatalf("Bytes() error = %v", err)
+	}
+	got, err := FromBytes(data)
+	if err != nil {
+		t.Fatalf("FromBytes() error = %v", err)
+	}
+
+	var type2 = got.(*NTLMType2)
+	if type2.TargetNameData != "DOMAIN" || type2.Challenge != msg.Challenge || type2.Flags != msg.Flags {
+		t.Errorf("FromBytes() got = %v", type2)
+	}
+	var want = []AvPair{
+		AvNbDomainName("DOMAIN"),
+		AvDnsComputerName("server.domain.com"),
+		AvTimestamp{Time: time.Date(2020, 11, 18, 19, 8, 9, 844076800, time.UTC)},
+		AvRaw{ID: 0x42, Value: []byte{0xaa}},
+		AvEOL{},
+	}
+	if !reflect.DeepEqual(type2.AvPairs(), want) {
+		t.Errorf("AvPairs() got = %v, want %v", type2.AvPairs(), want)
+	}
+}
+
+func TestBytesOEM(t *testing.T) {
+	var msg = NTLMType3v2{
+		NTLMType3v1: NTLMType3v1{
+			UserNameData:   "MÜLLER",
+			LmResponseData: LMResponseData{Hex: "c337cd5cbd44fc9782a667af6d427c6de67c20c2d3e77c56"},
+		},
+		Flags: NTLMSSP_NEGOTIATE_OEM | NTLMSSP_NEGOTIATE_NTLM,
+	}
+
+	data, err := msg.Bytes()
+	if err != nil {
+		t.Fatalf("Bytes() error = %v", err)
+	}
+	if !bytes.Contains(data, []byte{'M', 0x9a, 'L', 'L', 'E', 'R'}) {
+		t.Errorf("Bytes() got = %s, want the CP437 user name", hex.EncodeToString(data))
+	}
+
+	got, err := FromBytes(data)
+	if err != nil {
+		t.Fatalf("FromBytes() error = %v", err)
+	}
+	if got.(*NTLMType3v2).UserNameData != "MÜLLER" {
+		t.Errorf("FromBytes() got = %v", got)
+	}
+
+	msg.UserNameData = "Мир"
+	if _, err := msg.Bytes(); err == nil {
+		t.Errorf("Bytes() error = nil, want an encoding error")
+	}
+}
+
+func TestBytesCodePage(t *testing.T) {
+	// NTLM Type 1 with the OEM domain "DOMAI\x81"
+	var str = "4e544c4d53535000010000000732000006000600330000000b000b0028000000050093080000000f574f524b53544154494f4e444f4d414981"
+
+	var msg, err = ParseOptions{CodePage: CP866}.FromHex(str)
+	if err != nil {
+		t.Fatalf("FromHex() error = %v", err)
+	}
+	var type1 = msg.(*NTLMType1)
+	type1.SuppliedDomainData = "МИР"
+	data, err := type1.Bytes()
+	if err != nil {
+		t.Fatalf("Bytes() error = %v", err)
+	}
+	if !bytes.HasSuffix(data, []byte{0x8c, 0x88, 0x90}) {
+		t.Errorf("Bytes() got = %s, want the CP866 domain", hex.EncodeToString(data))
+	}
+
+	type1 = &NTLMType1{Flags: NTLMSSP_NEGOTIATE_OEM | NTLMSSP_NEGOTIATE_OEM_WORKSTATION_SUPPLIED, SuppliedWorkstationData: "€", CodePage: CP1252}
+	data, err = type1.Bytes()
+	if err != nil {
+		t.Fatalf("Bytes() error = %v", err)
+	}
+	if !bytes.HasSuffix(data, []byte{0x80}) {
+		t.Errorf("Bytes() got = %s, want the CP1252 workstation", hex.EncodeToString(data))
+	}
+}
+
+func TestBytesPatch(t *testing.T) {
+	var data, _ = base64.StdEncoding.DecodeString("TlRMTVNTUAACAAAABgAGADgAAAA1goniaaCGDXCRRNUAAAAAAAAAAIIAggA+AAAACgC6RwAAAA9KAEwARwACAAYASgBMAEcAAQAQAEMASABPAFUAQwBIAE8AVQAEABIAagBsAGcALgBsAG8AYwBhAGwAAwAkAGMAaABvAHUAYwBoAG8AdQAuAGoAbABnAC4AbABvAGMAYQBsAAUAEgBqAGwAZwAuAGwAbwBjAGEAbAAHAAgAQH6UJ9691gEAAAAA")
+	msg, err := FromBytes(data)
+	if err != nil {
+		t.Fatalf("FromBytes() error = %v", err)
+	}
+	var type2 = msg.(*NTLMType2)
+
+	// same length, only the changed characters differ
+	type2.TargetInfoData[4] = TargetInfo{Value: AvDnsTreeName("jlg.lokal")}
+	got, err := type2.Bytes()
+	if err != nil {
+		t.Fatalf("Bytes() error = %v", err)
+	}
+	var want = append([]byte(nil), data...)
+	want[len(data)-22] = 'k' // followed by "al", MsvAvTimestamp and MsvAvEOL
+	if !bytes.Equal(got, want) {
+		t.Errorf("Bytes() got = %x, want %x", got, want)
+	}
+
+	// longer, the AV pair list is moved to the end of the message and the
+	// other AV pairs are copied
+	type2.TargetInfoData[4] = TargetInfo{Value: AvDnsTreeName("corp.local")}
+	got, err = type2.Bytes()
+	if err != nil {
+		t.Fatalf("Bytes() error = %v", err)
+	}
+	if !bytes.Equal(got[:40], data[:40]) || !bytes.Equal(got[48:len(data)], data[48:]) {
+		t.Errorf("Bytes() got = %x, want the header and payload of %x", got, data)
+	}
+	reparsed, err := FromBytes(got)
+	if err != nil {
+		t.Fatalf("FromBytes(Bytes()) error = %v", err)
+	}
+	if name, _ := reparsed.(*NTLMType2).DnsTreeName(); name != "corp.local" {
+		t.Errorf("DnsTreeName() got = %q, want %q", name, "corp.local")
+	}
+	if got, want := reparsed.(*NTLMType2).TargetInfoSecBuf, (SecurityBuffer{Length: 132, Allocated: 132, Offset: len(data)}); got != want {
+		t.Errorf("TargetInfoSecBuf got = %v, want %v", got, want)
+	}
+}
+
+func TestBytesPatchAllocated(t *testing.T) {
+	var data, _ = base64.StdEncoding.DecodeString("TlRMTVNTUAADAAAAGAAYAGoAAAAYABgAggAAAAwADABAAAAACAAIAEwAAAAWABYAVAAAAAAAAACaAAAAAQIAAEQATwBNAEEASQBOAHUAcwBlAHIAVwBPAFIASwBTAFQAQQBUAEkATwBOAMM3zVy9RPyXgqZnr21CfG3mfCDC0+d8ViWpjBwx6BhHRmspst9GgPOZWPuMITqcxg==")
+	data[38] = 0x10 // UserName Allocated 16, Length 8
+
+	msg, err := FromBytes(data)
+	if err != nil {
+		t.Fatalf("FromBytes() error = %v", err)
+	}
+	got, err := msg.Bytes()
+	if err != nil {
+		t.Fatalf("Bytes() error = %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("Bytes() got = %x, want %x", got, data)
+	}
+
+	var type3 = msg.(*NTLMType3v2)
+	type3.UserNameData = "USER"
+	got, err = type3.Bytes()
+	if err != nil {
+		t.Fatalf("Bytes() error = %v", err)
+	}
+	var want = append([]byte(nil), data...)
+	copy(want[76:84], "U\x00S\x00E\x00R\x00")
+	if !bytes.Equal(got, want) {
+		t.Errorf("Bytes() got = %x, want %x", got, want)
+	}
+}
+
+func TestBytesPatchNTLMv2Response(t *testing.T) {
+	var data, _ = base64.StdEncoding.DecodeString("TlRMTVNTUAADAAAAGAAYAHQAAAAiASIBjAAAAAAAAABYAAAADAAMAFgAAAAQABAAZAAAABAAEACuAQAANYKI4goAukcAAAAP1KMCweXeFIr6zmSmiHFWSWoAbABvAHUAaQBzAEMASABPAFUAQwBIAE8AVQAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAC5/Vhnk2GTLD131k8cNfZcAQEAAAAAAADSVClUh73WAX873ENT+QbPAAAAAAIABgBKAEwARwABABAAQwBIAE8AVQBDAEgATwBVAAQAEgBqAGwAZwAuAGwAbwBjAGEAbAADACQAYwBoAG8AdQBjAGgAbwB1AC4AagBsAGcALgBsAG8AYwBhAGwABQASAGoAbABnAC4AbABvAGMAYQBsAAcACADSVClUh73WAQYABAACAAAACAAwADAAAAAAAAAAAQAAAAAgAAC4YcwjyK/gKSgZikWqPXs8y5udtMrVNidXg4R7uFJFPgoAEAAAAAAAAAAAAAAAAAAAAAAACQAcAEgAVABUAFAALwBsAG8AYwBhAGwAaABvAHMAdAAAAAAAAAAAAAG7NbE8iPK1v5zqEu20+5Q=")
+	msg, err := FromBytes(data)
+	if err != nil {
+		t.Fatalf("FromBytes() error = %v", err)
+	}
+
+	var ntlmv2 = msg.(*NTLMType3v3).NtlmResponseData.NTLMv2Response
+	for i, info := range ntlmv2.AvPairs {
+		if info.Value.AvID() == MsvAvTargetName {
+			ntlmv2.AvPairs[i] = TargetInfo{Value: AvTargetName("HTTP/localhosT")}
+		}
+	}
+	got, err := msg.Bytes()
+	if err != nil {
+		t.Fatalf("Bytes() error = %v", err)
+	}
+	var want = bytes.Replace(data, []byte("t\x00\x00\x00\x00\x00"), []byte("T\x00\x00\x00\x00\x00"), 1)
+	if !bytes.Equal(got, want) {
+		t.Errorf("Bytes() got = %x, want %x", got, want)
+	}
+}
+
+func TestBytesPatchTruncated(t *testing.T) {
+	// NTLM Type 1 cut after "DO", the DomainName claims 6 bytes
+	var data, _ = hex.DecodeString("4e544c4d53535000010000000732000006000600330000000b000b0028000000050093080000000f574f524b53544154494f4e444f")
+	msg, err := ParseOptions{Lenient: true}.FromBytes(data)
+	if err != nil {
+		t.Fatalf("FromBytes() error = %v", err)
+	}
+	got, err := msg.Bytes()
+	if err != nil {
+		t.Fatalf("Bytes() error = %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("Bytes() got = %x, want %x", got, data)
+	}
+
+	// the DomainName the parser only partly read is kept
+	var type1 = msg.(*NTLMType1)
+	type1.SuppliedWorkstationData = "workstation"
+	got, err = type1.Bytes()
+	if err != nil {
+		t.Fatalf("Bytes() error = %v", err)
+	}
+	var want = append([]byte(nil), data...)
+	copy(want[40:51], "workstation")
+	if !bytes.Equal(got, want) {
+		t.Errorf("Bytes() got = %x, want %x", got, want)
+	}
+}
@@ -1,44 +1,137 @@
 package ntlm_parser
 
 import (
+	"bytes"
 	"encoding/base64"
 	"encoding/hex"
-	"errors"
 )
 
+// ParseOptions changes how messages are decoded, the zero value is what
+// FromBytes uses.
+type ParseOptions struct {
+	// CodePage decodes OEM strings, DefaultCodePage when zero.
+	CodePage CodePage
+
+	// Lenient returns whatever could be decoded instead of failing, every
+	// field that couldn't be read is reported in the Warnings of the message.
+	// Messages without a valid signature and message type are still rejected.
+	Lenient bool
+
+	// MaxMessageSize rejects larger messages with ErrMessageTooLarge,
+	// DefaultMaxMessageSize when zero and no limit when negative.
+	MaxMessageSize int
+}
+
+// DefaultMaxMessageSize is well above what Windows sends, the largest
+// AUTHENTICATE messages are a few kilobytes.
+const DefaultMaxMessageSize = 64 * 1024
+
 func FromBase64(str string) (NTLMMessage, error) {
+	return ParseOptions{}.FromBase64(str)
+}
+
+func FromHex(str string) (NTLMMessage, error) {
+	return ParseOptions{}.FromHex(str)
+}
+
+func FromBytes(data []byte) (NTLMMessage, error) {
+	return ParseOptions{}.FromBytes(data)
+}
+
+func (o ParseOptions) FromBase64(str string) (NTLMMessage, error) {
 	if data, err := base64.StdEncoding.DecodeString(str); err != nil {
 		return nil, err
 	} else {
-		return FromBytes(data)
+		return o.FromBytes(data)
 	}
 }
 
-func FromHex(str string) (NTLMMessage, error) {
+func (o ParseOptions) FromHex(str string) (NTLMMessage, error) {
 	if data, err := hex.DecodeString(str); err != nil {
 		return nil, err
 	} else {
-		return FromBytes(data)
+		return o.FromBytes(data)
 	}
 }
 
-func FromBytes(data []byte) (r NTLMMessage, err error) {
-	defer func() {
-		if e := recover(); e != nil {
-			err = errors.New("invalid ntlm message")
-		}
-	}()
+var signature = []byte("NTLMSSP\x00")
 
-	var m = map[string]func(data []byte) (NTLMMessage, error){
-		"4e544c4d5353500001000000": NTLMType1{}.Parse,
-		"4e544c4d5353500002000000": NTLMType2{}.Parse,
-		"4e544c4d5353500003000000": NTLMType3v1{}.Parse,
+var messageTypes = map[uint32]NTLMMessageType{
+	1: NEGOTIATE_MESSAGE,
+	2: CHALLENGE_MESSAGE,
+	3: AUTHENTICATE_MESSAGE,
+}
+
+// FromBytes returns a *ParseError when data can't be parsed, every offset
+// and length taken from the message is checked so hostile input can't make
+// it panic. A message wrapped in SPNEGO has to be unwrapped first, see
+// ParseSPNEGO.
+func (o ParseOptions) FromBytes(data []byte) (NTLMMessage, error) {
+	if max := o.maxMessageSize(); max >= 0 && len(data) > max {
+		return nil, &ParseError{Field: "Message", Offset: max, Err: ErrMessageTooLarge}
 	}
 
-	var f = m[hex.EncodeToString(data[0:12])]
-	if f != nil {
-		return f(data)
-	} else {
-		return nil, errors.New("unknown ntlm message")
+	var messageType, err = getMessageType(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var m = map[NTLMMessageType]func(data []byte, opts ParseOptions) (NTLMMessage, error){
+		NEGOTIATE_MESSAGE:    NTLMType1{}.parse,
+		CHALLENGE_MESSAGE:    NTLMType2{}.parse,
+		AUTHENTICATE_MESSAGE: NTLMType3v1{}.parse,
+	}
+
+	return m[messageType](data, o)
+}
+
+func getMessageType(data []byte) (NTLMMessageType, error) {
+	var r = newReader(data, "")
+	var sig = r.bytes("Signature", 0, 8)
+	if sig != nil && !bytes.Equal(sig, signature) {
+		r.fail("Signature", 0, ErrBadSignature)
+	}
+
+	var value = r.uint32("MessageType", 8)
+	if r.err != nil {
+		return "", r.err
+	}
+
+	var messageType, ok = messageTypes[value]
+	if !ok {
+		r.fail("MessageType", 8, ErrUnknownMessageType)
+		return "", r.err
+	}
+	return messageType, nil
+}
+
+// messageType returns the type of msg, empty when it is nil.
+func messageType(msg NTLMMessage) NTLMMessageType {
+	switch msg := msg.(type) {
+	case *NTLMType1:
+		return msg.MessageType
+	case *NTLMType2:
+		return msg.MessageType
+	case *NTLMType3v1:
+		return msg.MessageType
+	case *NTLMType3v2:
+		return msg.MessageType
+	case *NTLMType3v3:
+		return msg.MessageType
+	}
+	return ""
+}
+
+func (o ParseOptions) maxMessageSize() int {
+	if o.MaxMessageSize == 0 {
+		return DefaultMaxMessageSize
+	}
+	return o.MaxMessageSize
+}
+
+func (o ParseOptions) codePage() CodePage {
+	if o.CodePage == 0 {
+		return DefaultCodePage
 	}
+	return o.CodePage
 }
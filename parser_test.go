@@ -1,8 +1,12 @@
 package ntlm_parser
 
 import (
+	"bytes"
+	"encoding/hex"
+	"errors"
 	"reflect"
 	"testing"
+	"time"
 )
 
 func TestNtlmParseType1(t *testing.T) {
@@ -24,12 +28,12 @@ func TestNtlmParseType1(t *testing.T) {
 				SuppliedDomain:      SecurityBuffer{Length: 0, Allocated: 0, Offset: 0},
 				SuppliedWorkstation: SecurityBuffer{Length: 0, Allocated: 0, Offset: 0},
 				MessageType:         NEGOTIATE_MESSAGE,
-				Flags:               "UNICODE OEM NTLMSSP_REQUEST_TARGET NTLM ALWAYS_SIGN EXTENDED_SESSIONSECURITY VERSION 128 56",
+				Flags:               0xa2088207,
 				OsVersionStructure: OSVersionStructure{
-					MajorVersion: 10,
-					MinorVersion: 0,
-					BuildNumber:  18362,
-					Unknown:      15,
+					MajorVersion:        10,
+					MinorVersion:        0,
+					BuildNumber:         18362,
+					NTLMRevisionCurrent: 15,
 				},
 				SuppliedDomainData:      "",
 				SuppliedWorkstationData: "",
@@ -44,15 +48,17 @@ func TestNtlmParseType1(t *testing.T) {
 				SuppliedDomain:      SecurityBuffer{Length: 6, Allocated: 6, Offset: 51},
 				SuppliedWorkstation: SecurityBuffer{Length: 11, Allocated: 11, Offset: 40},
 				MessageType:         NEGOTIATE_MESSAGE,
-				Flags:               "UNICODE OEM NTLMSSP_REQUEST_TARGET NTLM OEM_DOMAIN_SUPPLIED OEM_WORKSTATION_SUPPLIED",
+				Flags:               0x00003207,
 				OsVersionStructure: OSVersionStructure{
-					MajorVersion: 5,
-					MinorVersion: 0,
-					BuildNumber:  2195,
-					Unknown:      15,
+					MajorVersion:        5,
+					MinorVersion:        0,
+					BuildNumber:         2195,
+					NTLMRevisionCurrent: 15,
 				},
 				SuppliedDomainData:      "DOMAIN",
 				SuppliedWorkstationData: "WORKSTATION",
+				SuppliedDomainRaw:       []byte("DOMAIN"),
+				SuppliedWorkstationRaw:  []byte("WORKSTATION"),
 			}),
 			wantErr: false,
 		},
@@ -64,7 +70,7 @@ func TestNtlmParseType1(t *testing.T) {
 				t.Errorf("FromBase64() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
-			if !reflect.DeepEqual(got, tt.want) {
+			if !reflect.DeepEqual(withoutOriginal(got), tt.want) {
 				t.Errorf("FromBase64() got = %v, want %v", got, tt.want)
 			}
 		})
@@ -89,25 +95,26 @@ func TestNtlmParseType2(t *testing.T) {
 			want: NTLMMessage(&NTLMType2{
 				MessageType:      CHALLENGE_MESSAGE,
 				TargetNameSecBuf: SecurityBuffer{Length: 6, Allocated: 6, Offset: 56},
-				Flags:            "UNICODE NTLMSSP_REQUEST_TARGET SIGN SEAL NTLM ALWAYS_SIGN NTLMSSP_TARGET_TYPE_DOMAIN EXTENDED_SESSIONSECURITY TARGET_INFO VERSION 128 KEY_EXCH 56",
+				Flags:            0xe2898235,
 				Challenge:        "69a0860d709144d5",
 				TargetNameData:   "JLG",
+				TargetNameRaw:    []byte("J\x00L\x00G\x00"),
 				Context:          "0000000000000000",
 				TargetInfoSecBuf: SecurityBuffer{Length: 130, Allocated: 130, Offset: 62},
 				TargetInfoData: []TargetInfo{
-					{Type: 2, Length: 6, Content: "JLG"},
-					{Type: 1, Length: 16, Content: "CHOUCHOU"},
-					{Type: 4, Length: 18, Content: "jlg.local"},
-					{Type: 3, Length: 36, Content: "chouchou.jlg.local"},
-					{Type: 5, Length: 18, Content: "jlg.local"},
-					{Type: 7, Length: 8, Content: "2020-11-18T19:08:09.844Z"},
-					{Type: 0, Length: 0, Content: ""},
+					{Type: 2, Length: 6, Content: "JLG", Value: AvNbDomainName("JLG")},
+					{Type: 1, Length: 16, Content: "CHOUCHOU", Value: AvNbComputerName("CHOUCHOU")},
+					{Type: 4, Length: 18, Content: "jlg.local", Value: AvDnsDomainName("jlg.local")},
+					{Type: 3, Length: 36, Content: "chouchou.jlg.local", Value: AvDnsComputerName("chouchou.jlg.local")},
+					{Type: 5, Length: 18, Content: "jlg.local", Value: AvDnsTreeName("jlg.local")},
+					{Type: 7, Length: 8, Content: "2020-11-18T19:08:09.844Z", Value: AvTimestamp{Time: time.Date(2020, 11, 18, 19, 8, 9, 844076800, time.UTC)}},
+					{Type: 0, Length: 0, Content: "", Value: AvEOL{}},
 				},
 				OsVersionStructure: OSVersionStructure{
-					MajorVersion: 10,
-					MinorVersion: 0,
-					BuildNumber:  18362,
-					Unknown:      15,
+					MajorVersion:        10,
+					MinorVersion:        0,
+					BuildNumber:         18362,
+					NTLMRevisionCurrent: 15,
 				},
 			}),
 			wantErr: false,
@@ -124,18 +131,19 @@ func TestNtlmParseType2(t *testing.T) {
 			action: FromHex,
 			want: NTLMMessage(&NTLMType2{
 				MessageType:      CHALLENGE_MESSAGE,
-				Flags:            "UNICODE NTLM NTLMSSP_TARGET_TYPE_DOMAIN TARGET_INFO",
+				Flags:            0x00810201,
 				TargetNameSecBuf: SecurityBuffer{Length: 12, Allocated: 12, Offset: 48},
 				Challenge:        "0123456789abcdef",
 				TargetNameData:   "DOMAIN",
+				TargetNameRaw:    []byte("D\x00O\x00M\x00A\x00I\x00N\x00"),
 				Context:          "0000000000000000",
 				TargetInfoSecBuf: SecurityBuffer{Length: 98, Allocated: 98, Offset: 60},
 				TargetInfoData: []TargetInfo{
-					{Type: 2, Length: 12, Content: "DOMAIN"},
-					{Type: 1, Length: 12, Content: "SERVER"},
-					{Type: 4, Length: 20, Content: "domain.com"},
-					{Type: 3, Length: 34, Content: "server.domain.com"},
-					{Type: 0, Length: 0, Content: ""},
+					{Type: 2, Length: 12, Content: "DOMAIN", Value: AvNbDomainName("DOMAIN")},
+					{Type: 1, Length: 12, Content: "SERVER", Value: AvNbComputerName("SERVER")},
+					{Type: 4, Length: 20, Content: "domain.com", Value: AvDnsDomainName("domain.com")},
+					{Type: 3, Length: 34, Content: "server.domain.com", Value: AvDnsComputerName("server.domain.com")},
+					{Type: 0, Length: 0, Content: "", Value: AvEOL{}},
 				},
 			}),
 			wantErr: false,
@@ -149,7 +157,7 @@ func TestNtlmParseType2(t *testing.T) {
 				return
 			}
 
-			if !reflect.DeepEqual(got, tt.want) {
+			if !reflect.DeepEqual(withoutOriginal(got), tt.want) {
 				t.Errorf("FromBase64() got = %v, want %v", got, tt.want)
 			}
 		})
@@ -205,20 +213,60 @@ func TestNtlmParseType3(t *testing.T) {
 								"afe02928198a45aa3d7b3ccb9b9db4cad536275783847bb852453e0a00" +
 								"10000000000000000000000000000000000009001c0048005400540050" +
 								"002f006c006f00630061006c0068006f00730074000000000000000000",
+							NTLMv2Response: &NTLMv2Response{
+								NTProofStr:      "b9fd58679361932c3d77d64f1c35f65c",
+								RespType:        1,
+								HiRespType:      1,
+								Timestamp:       time.Date(2020, 11, 18, 8, 46, 38, 423777800, time.UTC),
+								ClientChallenge: "7f3bdc4353f906cf",
+								AvPairs: []TargetInfo{
+									{Type: 2, Length: 6, Content: "JLG", Value: AvNbDomainName("JLG")},
+									{Type: 1, Length: 16, Content: "CHOUCHOU", Value: AvNbComputerName("CHOUCHOU")},
+									{Type: 4, Length: 18, Content: "jlg.local", Value: AvDnsDomainName("jlg.local")},
+									{Type: 3, Length: 36, Content: "chouchou.jlg.local", Value: AvDnsComputerName("chouchou.jlg.local")},
+									{Type: 5, Length: 18, Content: "jlg.local", Value: AvDnsTreeName("jlg.local")},
+									{Type: 7, Length: 8, Content: "2020-11-18T08:46:38.423Z", Value: AvTimestamp{Time: time.Date(2020, 11, 18, 8, 46, 38, 423777800, time.UTC)}},
+									{Type: 6, Length: 4, Content: "0x00000002", Value: AvFlags(2)},
+									{Type: 8, Length: 48, Content: "custom-data=0100000000200000 machine-id=b861cc23c8afe02928198a45aa3d7b3ccb9b9db4cad536275783847bb852453e", Value: AvSingleHost{
+										Size:       48,
+										CustomData: [8]byte{0x01, 0x00, 0x00, 0x00, 0x00, 0x20, 0x00, 0x00},
+										MachineID: [32]byte{
+											0xb8, 0x61, 0xcc, 0x23, 0xc8, 0xaf, 0xe0, 0x29, 0x28, 0x19, 0x8a, 0x45, 0xaa, 0x3d, 0x7b, 0x3c,
+											0xcb, 0x9b, 0x9d, 0xb4, 0xca, 0xd5, 0x36, 0x27, 0x57, 0x83, 0x84, 0x7b, 0xb8, 0x52, 0x45, 0x3e,
+										},
+									}},
+									{Type: 10, Length: 16, Content: "00000000000000000000000000000000", Value: AvChannelBindings{}},
+									{Type: 9, Length: 28, Content: "HTTP/localhost", Value: AvTargetName("HTTP/localhost")},
+									{Type: 0, Length: 0, Content: "", Value: AvEOL{}},
+								},
+								MsvAvFlags: 2,
+							},
 						},
 						TargetNameData:      "",
 						UserNameData:        "jlouis",
 						WorkstationNameData: "CHOUCHOU",
+						UserNameRaw:         []byte("j\x00l\x00o\x00u\x00i\x00s\x00"),
+						WorkstationNameRaw:  []byte("C\x00H\x00O\x00U\x00C\x00H\x00O\x00U\x00"),
 					},
 					SessionKey: SecurityBuffer{Length: 16, Allocated: 16, Offset: 430},
-					Flags:      "UNICODE NTLMSSP_REQUEST_TARGET SIGN SEAL NTLM ALWAYS_SIGN EXTENDED_SESSIONSECURITY TARGET_INFO VERSION 128 KEY_EXCH 56",
+					SessionKeyData: SessionKeyData{
+						Raw: []byte{
+							0x01, 0xbb, 0x35, 0xb1, 0x3c, 0x88, 0xf2, 0xb5,
+							0xbf, 0x9c, 0xea, 0x12, 0xed, 0xb4, 0xfb, 0x94,
+						},
+						Hex:     "01bb35b13c88f2b5bf9cea12edb4fb94",
+						KeyExch: true,
+					},
+					Flags: 0xe2888235,
 				},
 				OsVersionStructure: OSVersionStructure{
-					MajorVersion: 10,
-					MinorVersion: 0,
-					BuildNumber:  18362,
-					Unknown:      15,
+					MajorVersion:        10,
+					MinorVersion:        0,
+					BuildNumber:         18362,
+					NTLMRevisionCurrent: 15,
 				},
+				MIC:        "d4a302c1e5de148aface64a688715649",
+				MICPresent: true,
 			}),
 			wantErr: false,
 		},
@@ -247,9 +295,12 @@ func TestNtlmParseType3(t *testing.T) {
 					TargetNameData:      "DOMAIN",
 					UserNameData:        "user",
 					WorkstationNameData: "WORKSTATION",
+					TargetNameRaw:       []byte("D\x00O\x00M\x00A\x00I\x00N\x00"),
+					UserNameRaw:         []byte("u\x00s\x00e\x00r\x00"),
+					WorkstationNameRaw:  []byte("W\x00O\x00R\x00K\x00S\x00T\x00A\x00T\x00I\x00O\x00N\x00"),
 				},
 				SessionKey: SecurityBuffer{Length: 0, Allocated: 0, Offset: 154},
-				Flags:      "UNICODE NTLM",
+				Flags:      0x00000201,
 			}),
 			wantErr: false,
 		},
@@ -262,9 +313,187 @@ func TestNtlmParseType3(t *testing.T) {
 				return
 			}
 
-			if !reflect.DeepEqual(got, tt.want) {
+			if !reflect.DeepEqual(withoutOriginal(got), tt.want) {
 				t.Errorf("FromBase64() got = %v, want %v", got, tt.want)
 			}
 		})
 	}
 }
+
+// withoutOriginal clears the buffer kept by parse so that a parsed message
+// can be compared with a literal.
+func withoutOriginal(msg NTLMMessage) NTLMMessage {
+	switch m := msg.(type) {
+	case *NTLMType1:
+		m.original = nil
+	case *NTLMType2:
+		m.original = nil
+	case *NTLMType3v1:
+		m.original = nil
+	case *NTLMType3v2:
+		m.original = nil
+	case *NTLMType3v3:
+		m.original = nil
+	}
+	return msg
+}
+
+func TestFromBytesErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		str       string
+		wantErr   error
+		wantField string
+	}{
+		{
+			name:      "empty",
+			str:       "",
+			wantErr:   ErrTruncated,
+			wantField: "Signature",
+		},
+		{
+			name:      "bad signature",
+			str:       "4e544c4d5353500101000000",
+			wantErr:   ErrBadSignature,
+			wantField: "Signature",
+		},
+		{
+			name:      "unknown message type",
+			str:       "4e544c4d5353500004000000",
+			wantErr:   ErrUnknownMessageType,
+			wantField: "MessageType",
+		},
+		{
+			name:      "truncated header",
+			str:       "4e544c4d53535000020000000c000c0030000000",
+			wantErr:   ErrTruncated,
+			wantField: "NegotiateFlags",
+		},
+		{
+			name:      "payload out of range",
+			str:       "4e544c4d53535000010000000732000006000600330000000b000b0028000000050093080000000f574f524b53544154494f4e444f4d41",
+			wantErr:   ErrBufferOutOfRange,
+			wantField: "DomainName",
+		},
+		{
+			// negative once converted to int on 32-bit platforms
+			name:      "offset past 2^31",
+			str:       "4e544c4d53535000010000000732000006000600f0ffffff0b000b0028000000050093080000000f574f524b53544154494f4e444f4d41",
+			wantErr:   ErrBufferOutOfRange,
+			wantField: "DomainName",
+		},
+		{
+			name:      "allocated smaller than length",
+			str:       "4e544c4d53535000010000000732000006000500330000000b000b0028000000050093080000000f574f524b53544154494f4e444f4d41494e",
+			wantErr:   ErrBufferOutOfRange,
+			wantField: "DomainName",
+		},
+		{
+			name: "av pair past the target info",
+			str: "4e544c4d53535000020000000000000030000000010281000123456789abcdef" +
+				"00000000000000000800080030000000020008004100420043004400",
+			wantErr:   ErrBufferOutOfRange,
+			wantField: "TargetInfo",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := (ParseOptions{Lenient: true}).FromHex(tt.str); err != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("lenient FromHex() error = %v", err)
+			}
+
+			_, err := FromHex(tt.str)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("FromHex() error = %v, want %v", err, tt.wantErr)
+			}
+
+			var parseErr *ParseError
+			if !errors.As(err, &parseErr) || parseErr.Field != tt.wantField {
+				t.Errorf("FromHex() error = %#v, want field %s", err, tt.wantField)
+			}
+		})
+	}
+}
+
+func TestMaxMessageSize(t *testing.T) {
+	var str = "4e544c4d53535000010000000732000006000600330000000b000b0028000000050093080000000f574f524b53544154494f4e444f4d41494e"
+
+	if _, err := (ParseOptions{MaxMessageSize: 32}).FromHex(str); !errors.Is(err, ErrMessageTooLarge) {
+		t.Errorf("FromHex() error = %v, want %v", err, ErrMessageTooLarge)
+	}
+	if _, err := (ParseOptions{MaxMessageSize: -1}).FromHex(str); err != nil {
+		t.Errorf("FromHex() error = %v, want nil", err)
+	}
+}
+
+func TestLenient(t *testing.T) {
+	// NTLM Type 3 (hex) without the last 10 bytes of the NT response
+	var str = "4e544c4d5353500003000000180018006a00000018001800820000000c000c0040000000080008004c00000016001600" +
+		"54000000000000009a0000000102000044004f004d00410049004e00750073006500720057004f0052004b0053005400" +
+		"4100540049004f004e00c337cd5cbd44fc9782a667af6d427c6de67c20c2d3e77c5625a98c1c31e81847466b29b2df46"
+
+	if _, err := FromHex(str); !errors.Is(err, ErrBufferOutOfRange) {
+		t.Fatalf("FromHex() error = %v, want %v", err, ErrBufferOutOfRange)
+	}
+
+	msg, err := ParseOptions{Lenient: true}.FromHex(str)
+	if err != nil {
+		t.Fatalf("FromHex() error = %v", err)
+	}
+
+	var type3 = msg.(*NTLMType3v2)
+	if type3.UserNameData != "user" || type3.NtlmResponseData.Hex != "25a98c1c31e81847466b29b2df46" {
+		t.Errorf("FromHex() got = %v", type3)
+	}
+
+	var want = []*ParseError{
+		{MessageType: AUTHENTICATE_MESSAGE, Field: "NtChallengeResponse", Offset: 130, Err: ErrBufferOutOfRange},
+		{MessageType: AUTHENTICATE_MESSAGE, Field: "EncryptedRandomSessionKey", Offset: 154, Err: ErrBufferOutOfRange},
+	}
+	if !reflect.DeepEqual(type3.Warnings, want) {
+		t.Errorf("Warnings got = %v, want %v", type3.Warnings, want)
+	}
+}
+
+func FuzzFromBytes(f *testing.F) {
+	for _, str := range []string{
+		"4e544c4d53535000010000000732000006000600330000000b000b0028000000050093080000000f574f524b53544154494f4e444f4d41494e",
+		"4e544c4d53535000020000000c000c0030000000010281000123456789abcdef0000000000000000620062003c000000" +
+			"44004f004d00410049004e0002000c0044004f004d00410049004e0001000c0053004500520056004500520004001400" +
+			"64006f006d00610069006e002e0063006f006d00030022007300650072007600650072002e0064006f006d0061006900" +
+			"6e002e0063006f006d0000000000",
+		"4e544c4d5353500003000000180018006a00000018001800820000000c000c0040000000080008004c00000016001600" +
+			"54000000000000009a0000000102000044004f004d00410049004e00750073006500720057004f0052004b0053005400" +
+			"4100540049004f004e00c337cd5cbd44fc9782a667af6d427c6de67c20c2d3e77c5625a98c1c31e81847466b29b2df46" +
+			"80f39958fb8c213a9cc6",
+	} {
+		var data, _ = hex.DecodeString(str)
+		f.Add(data)
+	}
+	var wrapped, _ = hex.DecodeString("4e544c4d53535000010000000732000006000600330000000b000b0028000000050093080000000f574f524b53544154494f4e444f4d41494e")
+	var type1, _ = FromBytes(wrapped)
+	wrapped, _ = WrapSPNEGO(type1)
+	f.Add(wrapped)
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		msg, err := FromBytes(data)
+		if (msg == nil) == (err == nil) {
+			t.Errorf("FromBytes() got = %v, error = %v", msg, err)
+		}
+		// FromBytes doesn't unwrap SPNEGO, what it parses is data itself
+		if msg != nil {
+			if got, err := msg.Bytes(); err != nil || !bytes.Equal(got, data) {
+				t.Errorf("Bytes() got = %x, error = %v, want %x", got, err, data)
+			}
+		}
+
+		msg, err = ParseOptions{Lenient: true}.FromBytes(data)
+		if (msg == nil) == (err == nil) {
+			t.Errorf("FromBytes() lenient got = %v, error = %v", msg, err)
+		}
+		if msg != nil {
+			msg.Bytes()
+			Dissect(msg)
+		}
+	})
+}
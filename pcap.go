@@ -0,0 +1,1015 @@
+package ntlm_parser
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"sort"
+	"strings"
+	"time"
+)
+
+// FiveTuple identifies a connection, or one direction of it.
+type FiveTuple struct {
+	Protocol string
+	SrcIP    net.IP
+	SrcPort  uint16
+	DstIP    net.IP
+	DstPort  uint16
+}
+
+func (t FiveTuple) String() string {
+	return fmt.Sprintf("%s %s -> %s", t.Protocol,
+		net.JoinHostPort(t.SrcIP.String(), fmt.Sprint(t.SrcPort)),
+		net.JoinHostPort(t.DstIP.String(), fmt.Sprint(t.DstPort)))
+}
+
+func (t FiveTuple) Reverse() FiveTuple {
+	return FiveTuple{Protocol: t.Protocol, SrcIP: t.DstIP, SrcPort: t.DstPort, DstIP: t.SrcIP, DstPort: t.SrcPort}
+}
+
+func (t FiveTuple) key() string {
+	return fmt.Sprintf("%s %s %d %s %d", t.Protocol, t.SrcIP, t.SrcPort, t.DstIP, t.DstPort)
+}
+
+// connectionKey is the same for both directions of a connection.
+func (t FiveTuple) connectionKey() string {
+	var a, b = t.key(), t.Reverse().key()
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// CapturedMessage is a message found in a capture.
+type CapturedMessage struct {
+	Message NTLMMessage
+
+	// Flow goes from the sender to the receiver of the message.
+	Flow FiveTuple
+
+	// Timestamp is the one of the packet holding the first byte of the
+	// message, or of its base64 encoding.
+	Timestamp time.Time
+
+	// Offset is where the message starts in the reassembled stream of Flow.
+	// An SMB1 message rebuilt from the LM and NT responses isn't in the
+	// stream, Offset is where its PDU starts.
+	Offset int64
+
+	// Protocol is the decoder that found the message: "SMB2", "SMB",
+	// "LDAP", "DCE/RPC", "HTTP", "SMTP", "IMAP" or "POP3". It is empty when
+	// the message was carved out by a Scanner.
+	Protocol string
+
+	// SessionID is the SessionId of the SMB2 header, or the UID of the SMB1
+	// one.
+	SessionID uint64
+
+	// MessageID is the messageID of the LDAP message.
+	MessageID int64
+
+	// AuthLevel is the auth_level of the DCE/RPC sec_trailer.
+	AuthLevel DCERPCAuthLevel
+
+	messageType NTLMMessageType
+}
+
+// Exchange is one NEGOTIATE, CHALLENGE, AUTHENTICATE handshake, the
+// messages that weren't captured are nil.
+type Exchange struct {
+	// Connection goes from the client to the server.
+	Connection   FiveTuple
+	Negotiate    *CapturedMessage
+	Challenge    *CapturedMessage
+	Authenticate *CapturedMessage
+}
+
+func ReadPcap(r io.Reader) ([]Exchange, error) {
+	return ParseOptions{}.ReadPcap(r)
+}
+
+// ReadPcap reads a pcap or pcapng capture, reassembles its TCP streams and
+// returns the NTLM exchanges they carry, ordered by time.
+//
+// A connection is decoded by the protocol its server port, or else the
+// first bytes of its streams, tell: the PDUs of SMB, LDAP and DCE/RPC go
+// through ParseSMB2, ParseSMB1, ParseLDAP and ParseDCERPC, the NTLM and
+// Negotiate tokens of the HTTP authentication headers are parsed, and
+// SMTP, IMAP and POP3 transcripts, both directions merged by time, go
+// through ParseSMTP, ParseIMAP and ParsePOP3. The other connections, the
+// mail transcripts that don't parse, the PDUs a decoder rejects and the
+// bytes it can't frame, such as those after a gap in the capture, are
+// searched with a Scanner. The bytes missing in a gap are lost, and so is
+// a message they cut.
+func (o ParseOptions) ReadPcap(r io.Reader) ([]Exchange, error) {
+	var flows = newFlowTable()
+	if err := readPackets(r, flows.add); err != nil {
+		return nil, err
+	}
+
+	var streams = map[string][]stream{}
+	var connections []string
+	for _, f := range flows.list {
+		var key = f.tuple.connectionKey()
+		if _, ok := streams[key]; !ok {
+			connections = append(connections, key)
+		}
+		streams[key] = append(streams[key], stream{tuple: f.tuple, chunks: f.reassemble()})
+	}
+
+	var result []Exchange
+	for _, key := range connections {
+		result = append(result, groupExchanges(o.decodeConnection(streams[key]))...)
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].start().Before(result[j].start())
+	})
+	return result, nil
+}
+
+func (e Exchange) start() time.Time {
+	for _, m := range []*CapturedMessage{e.Negotiate, e.Challenge, e.Authenticate} {
+		if m != nil {
+			return m.Timestamp
+		}
+	}
+	return time.Time{}
+}
+
+// groupExchanges splits the messages of a connection into exchanges. The
+// first message of an exchange sets its Connection, a message that already
+// has its place taken or goes the other way starts the next one.
+func groupExchanges(messages []*CapturedMessage) []Exchange {
+	sort.SliceStable(messages, func(i, j int) bool {
+		return messages[i].Timestamp.Before(messages[j].Timestamp)
+	})
+
+	var result []Exchange
+	var current *Exchange
+	for _, m := range messages {
+		var messageType = m.messageType
+		var connection = m.Flow
+		if messageType == CHALLENGE_MESSAGE {
+			connection = m.Flow.Reverse()
+		}
+
+		if current == nil || current.Connection.key() != connection.key() ||
+			messageType == NEGOTIATE_MESSAGE && (current.Negotiate != nil || current.Challenge != nil || current.Authenticate != nil) ||
+			messageType == CHALLENGE_MESSAGE && (current.Challenge != nil || current.Authenticate != nil) ||
+			messageType == AUTHENTICATE_MESSAGE && current.Authenticate != nil {
+			result = append(result, Exchange{Connection: connection})
+			current = &result[len(result)-1]
+		}
+
+		switch messageType {
+		case NEGOTIATE_MESSAGE:
+			current.Negotiate = m
+		case CHALLENGE_MESSAGE:
+			current.Challenge = m
+		case AUTHENTICATE_MESSAGE:
+			current.Authenticate = m
+		}
+	}
+	return result
+}
+
+// stream is the reassembled data of a flow.
+type stream struct {
+	tuple  FiveTuple
+	chunks []chunk
+}
+
+// captureProtocol is the protocol of a connection, which tells how
+// ReadPcap decodes it.
+type captureProtocol int
+
+const (
+	captureUnknown captureProtocol = iota
+	captureSMB
+	captureLDAP
+	captureDCERPC
+	captureHTTP
+	captureSMTP
+	captureIMAP
+	capturePOP3
+)
+
+// capturePorts are the server ports of the protocols.
+var capturePorts = map[uint16]captureProtocol{
+	139:  captureSMB,
+	445:  captureSMB,
+	389:  captureLDAP,
+	3268: captureLDAP,
+	135:  captureDCERPC,
+	80:   captureHTTP,
+	3128: captureHTTP,
+	8080: captureHTTP,
+	25:   captureSMTP,
+	587:  captureSMTP,
+	143:  captureIMAP,
+	110:  capturePOP3,
+}
+
+var captureMailProtocols = map[captureProtocol]MailProtocol{
+	captureSMTP: SMTP,
+	captureIMAP: IMAP,
+	capturePOP3: POP3,
+}
+
+// sniffProtocol tells the protocol of a connection by its server port, or
+// else by the first bytes of its streams.
+func sniffProtocol(streams []stream) captureProtocol {
+	for _, s := range streams {
+		if protocol, ok := capturePorts[s.tuple.DstPort]; ok {
+			return protocol
+		}
+	}
+	for _, s := range streams {
+		if protocol, ok := capturePorts[s.tuple.SrcPort]; ok {
+			return protocol
+		}
+	}
+
+	for _, s := range streams {
+		if len(s.chunks) == 0 {
+			continue
+		}
+		var data = s.chunks[0].data
+		var line, _, _ = bytes.Cut(data, []byte("\n"))
+		switch {
+		case len(data) >= 8 && data[0] == 0 && (bytes.Equal(data[4:8], smb2ProtocolID) || bytes.Equal(data[4:8], smb1ProtocolID)):
+			return captureSMB
+		case len(data) >= dcerpcHeaderSize && data[0] == 5 && data[1] <= 1:
+			return captureDCERPC
+		case data[0] == 0x30: // SEQUENCE
+			return captureLDAP
+		case bytes.HasPrefix(line, []byte("HTTP/1.")) || bytes.Contains(line, []byte(" HTTP/1.")):
+			return captureHTTP
+		case bytes.HasPrefix(data, []byte("220 ")) || bytes.HasPrefix(data, []byte("220-")):
+			return captureSMTP
+		case bytes.HasPrefix(data, []byte("* OK")):
+			return captureIMAP
+		case bytes.HasPrefix(data, []byte("+OK")):
+			return capturePOP3
+		}
+	}
+	return captureUnknown
+}
+
+// decodeConnection finds the messages of the streams of a connection with
+// the decoder of its protocol.
+func (o ParseOptions) decodeConnection(streams []stream) []*CapturedMessage {
+	var protocol = sniffProtocol(streams)
+	if mail, ok := captureMailProtocols[protocol]; ok {
+		if result, ok := o.decodeMail(streams, mail); ok {
+			return result
+		}
+		protocol = captureUnknown
+	}
+
+	var result []*CapturedMessage
+	for _, s := range streams {
+		for _, c := range s.chunks {
+			switch protocol {
+			case captureUnknown:
+				result = append(result, o.scanChunk(s.tuple, c, 0, len(c.data))...)
+			case captureHTTP:
+				result = append(result, o.decodeHTTP(s.tuple, c)...)
+			default:
+				result = append(result, o.decodePDUs(s.tuple, c, protocol)...)
+			}
+		}
+	}
+	return result
+}
+
+// capture sets where m was found in c, at offset in c.data.
+func (c chunk) capture(m *CapturedMessage, tuple FiveTuple, offset int) *CapturedMessage {
+	m.Flow = tuple
+	m.Offset = c.offset + int64(offset)
+	m.Timestamp = c.timestamp(m.Offset)
+	m.messageType = messageType(m.Message)
+	return m
+}
+
+// scanChunk carves the messages out of c.data[start:end].
+func (o ParseOptions) scanChunk(tuple FiveTuple, c chunk, start, end int) []*CapturedMessage {
+	var result []*CapturedMessage
+	var s = o.NewScanner(bytes.NewReader(c.data[start:end]))
+	for s.Next() {
+		result = append(result, c.capture(&CapturedMessage{Message: s.Message()}, tuple, start+int(s.Offset())))
+	}
+	return result
+}
+
+// decodePDUs decodes the PDUs of a chunk. The bytes that don't frame as a
+// PDU the decoder accepts, e.g. after a gap, are searched with a Scanner
+// up to the next PDU it accepts.
+func (o ParseOptions) decodePDUs(tuple FiveTuple, c chunk, protocol captureProtocol) []*CapturedMessage {
+	var result []*CapturedMessage
+	for start := 0; start < len(c.data); {
+		var length = pduLength(protocol, c.data[start:])
+		var messages, ok = o.decodePDU(protocol, c.data[start:start+length])
+		if !ok {
+			var next = start + 1
+			for ; next < len(c.data); next++ {
+				if length := pduLength(protocol, c.data[next:]); length > 0 {
+					if _, ok := o.decodePDU(protocol, c.data[next:next+length]); ok {
+						break
+					}
+				}
+			}
+			result = append(result, o.scanChunk(tuple, c, start, next)...)
+			start = next
+			continue
+		}
+
+		// the messages are found in the PDU in order, or else placed at its
+		// start
+		var pdu = c.data[start : start+length]
+		var cursor = 0
+		for _, m := range messages {
+			var offset = 0
+			if parsed, ok := m.Message.(interface{ Original() []byte }); ok && len(parsed.Original()) > 0 {
+				if i := bytes.Index(pdu[cursor:], parsed.Original()); i >= 0 {
+					offset = cursor + i
+					cursor = offset + len(parsed.Original())
+				}
+			}
+			result = append(result, c.capture(m, tuple, start+offset))
+		}
+		start += length
+	}
+	return result
+}
+
+// pduLength returns the length of the PDU data starts with, 0 when data
+// doesn't start with a whole one.
+func pduLength(protocol captureProtocol, data []byte) int {
+	var length int
+	switch protocol {
+	case captureSMB:
+		// the NetBIOS session message and the other session packets
+		if len(data) < 4 || data[0] != 0 && (data[0] < 0x81 || data[0] > 0x85) {
+			return 0
+		}
+		length = 4 + (int(data[1])<<16 | int(data[2])<<8 | int(data[3]))
+	case captureDCERPC:
+		if len(data) < dcerpcHeaderSize || data[0] != 5 {
+			return 0
+		}
+		var order binary.ByteOrder = binary.BigEndian
+		if data[4]&0x10 != 0 {
+			order = binary.LittleEndian
+		}
+		length = int(order.Uint16(data[8:]))
+		if length < dcerpcHeaderSize {
+			return 0
+		}
+	case captureLDAP:
+		var _, rest, err = readBER(data)
+		if err != nil {
+			return 0
+		}
+		length = len(data) - len(rest)
+	}
+	if length > len(data) {
+		return 0
+	}
+	return length
+}
+
+// decodePDU decodes a PDU with the decoder of protocol, ok is false when
+// the decoder rejects it or pdu is empty.
+func (o ParseOptions) decodePDU(protocol captureProtocol, pdu []byte) (result []*CapturedMessage, ok bool) {
+	if len(pdu) == 0 {
+		return nil, false
+	}
+	switch protocol {
+	case captureSMB:
+		switch {
+		case pdu[0] != 0:
+			return nil, true
+		case bytes.HasPrefix(pdu[4:], []byte("\xfdSMB")), bytes.HasPrefix(pdu[4:], []byte("\xfcSMB")):
+			// encrypted or compressed
+			return nil, true
+		case bytes.HasPrefix(pdu[4:], smb2ProtocolID):
+			var packets, err = o.ParseSMB2(pdu)
+			if err != nil {
+				return nil, false
+			}
+			for _, p := range packets {
+				if p.NTLM != nil {
+					result = append(result, &CapturedMessage{Message: p.NTLM, Protocol: "SMB2", SessionID: p.SessionID})
+				}
+			}
+			return result, true
+		case bytes.HasPrefix(pdu[4:], smb1ProtocolID):
+			var packets, err = o.ParseSMB1(pdu)
+			if err != nil {
+				return nil, false
+			}
+			for _, p := range packets {
+				if p.NTLM != nil {
+					result = append(result, &CapturedMessage{Message: p.NTLM, Protocol: "SMB", SessionID: uint64(p.UID)})
+				}
+			}
+			return result, true
+		}
+	case captureLDAP:
+		var messages, err = o.ParseLDAP(pdu)
+		if err != nil {
+			return nil, false
+		}
+		for _, m := range messages {
+			if m.NTLM != nil {
+				result = append(result, &CapturedMessage{Message: m.NTLM, Protocol: "LDAP", MessageID: m.MessageID})
+			}
+		}
+		return result, true
+	case captureDCERPC:
+		var packets, err = o.ParseDCERPC(pdu)
+		if err != nil {
+			return nil, false
+		}
+		for _, p := range packets {
+			if p.NTLM != nil {
+				result = append(result, &CapturedMessage{Message: p.NTLM, Protocol: "DCE/RPC", AuthLevel: p.AuthLevel})
+			}
+		}
+		return result, true
+	}
+	return nil, false
+}
+
+// decodeHTTP parses the NTLM and Negotiate tokens of the authentication
+// headers of a chunk of an HTTP stream. A token that isn't base64, or
+// holds Kerberos, is skipped.
+func (o ParseOptions) decodeHTTP(tuple FiveTuple, c chunk) []*CapturedMessage {
+	var result []*CapturedMessage
+	for start := 0; start < len(c.data); {
+		var end = len(c.data)
+		if i := bytes.IndexByte(c.data[start:], '\n'); i >= 0 {
+			end = start + i + 1
+		}
+		var line = string(c.data[start:end])
+		var name, value, found = strings.Cut(line, ":")
+		if !found || !isAuthHeader(name) {
+			start = end
+			continue
+		}
+
+		var challenges, err = ParseChallenges(strings.TrimRight(value, "\r\n"))
+		if err != nil {
+			start = end
+			continue
+		}
+		var cursor = len(name) + 1
+		for _, challenge := range challenges {
+			if !strings.EqualFold(challenge.Scheme, "NTLM") && !strings.EqualFold(challenge.Scheme, "Negotiate") || challenge.Token == "" {
+				continue
+			}
+			var at = strings.Index(line[cursor:], challenge.Token)
+			if at < 0 {
+				continue
+			}
+			at += cursor
+			cursor = at + len(challenge.Token)
+
+			var data, err = base64.StdEncoding.DecodeString(challenge.Token)
+			if err != nil {
+				continue
+			}
+			msg, err := o.fromNegotiateToken(data)
+			if err != nil {
+				continue
+			}
+			// the offset of the base64 quantum holding the signature
+			if i := bytes.Index(data, signature); i > 0 {
+				at += i / 3 * 4
+			}
+			result = append(result, c.capture(&CapturedMessage{Message: msg, Protocol: "HTTP"}, tuple, start+at))
+		}
+		start = end
+	}
+	return result
+}
+
+func isAuthHeader(name string) bool {
+	for _, header := range authHeaderNames {
+		if strings.EqualFold(name, header) {
+			return true
+		}
+	}
+	return false
+}
+
+// mailLine is a line of a mail stream.
+type mailLine struct {
+	tuple     FiveTuple
+	chunk     *chunk
+	offset    int // in chunk.data
+	text      string
+	timestamp time.Time
+}
+
+// decodeMail merges the lines of the streams of a mail connection by time
+// into a transcript and parses it, ok is false when the transcript can't
+// be parsed or a message can't be found back in the lines.
+func (o ParseOptions) decodeMail(streams []stream, protocol MailProtocol) (result []*CapturedMessage, ok bool) {
+	var lines []mailLine
+	for _, s := range streams {
+		for i := range s.chunks {
+			var c = &s.chunks[i]
+			for start := 0; start < len(c.data); {
+				var end = len(c.data)
+				if i := bytes.IndexByte(c.data[start:], '\n'); i >= 0 {
+					end = start + i + 1
+				}
+				var text = strings.TrimSuffix(string(c.data[start:end]), "\n")
+				lines = append(lines, mailLine{tuple: s.tuple, chunk: c, offset: start, text: text, timestamp: c.timestamp(c.offset + int64(start))})
+				start = end
+			}
+		}
+	}
+	sort.SliceStable(lines, func(i, j int) bool {
+		return lines[i].timestamp.Before(lines[j].timestamp)
+	})
+
+	var transcript strings.Builder
+	for _, line := range lines {
+		transcript.WriteString(line.text)
+		transcript.WriteString("\n")
+	}
+	var exchanges, err = o.parseMail(strings.NewReader(transcript.String()), protocol)
+	if err != nil {
+		return nil, false
+	}
+
+	// the padding is left out, a client may leave it out too
+	var next = 0
+	for _, exchange := range exchanges {
+		for _, msg := range exchange.Messages() {
+			var encoded = base64.RawStdEncoding.EncodeToString(msg.(interface{ Original() []byte }).Original())
+			var found = false
+			for ; next < len(lines) && !found; next++ {
+				if at := strings.Index(lines[next].text, encoded); at >= 0 {
+					var line = lines[next]
+					result = append(result, line.chunk.capture(&CapturedMessage{Message: msg, Protocol: protocol.String()}, line.tuple, line.offset+at))
+					found = true
+				}
+			}
+			if !found {
+				return nil, false
+			}
+		}
+	}
+	return result, true
+}
+
+// flow is one direction of a TCP connection.
+type flow struct {
+	tuple    FiveTuple
+	isn      uint32 // sequence number of the SYN
+	syn      bool
+	segments []segment
+}
+
+type segment struct {
+	seq       uint32
+	timestamp time.Time
+	data      []byte
+}
+
+// chunk is a contiguous part of a reassembled stream, there is one chunk
+// per run of captured bytes between the gaps of the capture.
+type chunk struct {
+	offset int64
+	data   []byte
+	marks  []mark
+}
+
+// mark tells when the bytes from offset on were captured.
+type mark struct {
+	offset    int64
+	timestamp time.Time
+}
+
+func (c chunk) timestamp(offset int64) time.Time {
+	var i = sort.Search(len(c.marks), func(i int) bool { return c.marks[i].offset > offset })
+	if i == 0 {
+		return c.marks[0].timestamp
+	}
+	return c.marks[i-1].timestamp
+}
+
+// reassemble orders the segments by sequence number and drops the
+// retransmitted bytes.
+func (f *flow) reassemble() []chunk {
+	if len(f.segments) == 0 {
+		return nil
+	}
+	var base = f.isn + 1
+	if !f.syn {
+		base = f.segments[0].seq
+		for _, s := range f.segments {
+			if int32(s.seq-base) < 0 {
+				base = s.seq
+			}
+		}
+	}
+
+	var segments = append([]segment(nil), f.segments...)
+	sort.SliceStable(segments, func(i, j int) bool {
+		return int32(segments[i].seq-base) < int32(segments[j].seq-base)
+	})
+
+	var result []chunk
+	var end int64 = -1
+	for _, s := range segments {
+		var start = int64(int32(s.seq - base))
+		var data = s.data
+		if start < 0 {
+			if int64(len(data)) <= -start {
+				continue
+			}
+			data, start = data[-start:], 0
+		}
+
+		if start > end {
+			result = append(result, chunk{offset: start})
+			end = start
+		}
+		var c = &result[len(result)-1]
+		if start+int64(len(data)) <= end {
+			continue
+		}
+		data = data[end-start:]
+		c.marks = append(c.marks, mark{offset: end, timestamp: s.timestamp})
+		c.data = append(c.data, data...)
+		end += int64(len(data))
+	}
+	return result
+}
+
+type flowTable struct {
+	flows map[string]*flow
+	list  []*flow
+}
+
+func newFlowTable() *flowTable {
+	return &flowTable{flows: map[string]*flow{}}
+}
+
+func (t *flowTable) add(p packet) {
+	var tuple, seg, flags, ok = decodePacket(p)
+	if !ok {
+		return
+	}
+
+	var key = tuple.key()
+	var f = t.flows[key]
+	if f == nil || flags&tcpSYN != 0 && f.syn && f.isn != seg.seq {
+		// a new connection reusing the ports
+		f = &flow{tuple: tuple}
+		t.flows[key] = f
+		t.list = append(t.list, f)
+	}
+	if flags&tcpSYN != 0 {
+		f.isn, f.syn = seg.seq, true
+	}
+	if len(seg.data) > 0 {
+		f.segments = append(f.segments, seg)
+	}
+}
+
+// packet is a captured frame.
+type packet struct {
+	timestamp time.Time
+	linkType  uint32
+	data      []byte
+}
+
+// link-layer header types, https://www.tcpdump.org/linktypes.html
+const (
+	linkTypeNull      = 0
+	linkTypeEthernet  = 1
+	linkTypeRaw       = 101
+	linkTypeLoop      = 108
+	linkTypeLinuxSLL  = 113
+	linkTypeIPv4      = 228
+	linkTypeIPv6      = 229
+	linkTypeLinuxSLL2 = 276
+)
+
+const tcpSYN = 0x02
+
+// decodePacket decodes the TCP segment of a frame, ok is false for
+// anything else.
+func decodePacket(p packet) (tuple FiveTuple, seg segment, flags byte, ok bool) {
+	var data, found = linkPayload(p.linkType, p.data)
+	if !found {
+		return
+	}
+
+	var protocol byte
+	switch data[0] >> 4 {
+	case 4:
+		if len(data) < 20 {
+			return
+		}
+		var headerSize = int(data[0]&0x0f) * 4
+		var totalLength = int(binary.BigEndian.Uint16(data[2:4]))
+		if totalLength == 0 {
+			// left to the NIC by TCP segmentation offload
+			totalLength = len(data)
+		}
+		var fragment = binary.BigEndian.Uint16(data[6:8])
+		if headerSize < 20 || totalLength < headerSize || totalLength > len(data) || fragment&0x3fff != 0 {
+			return
+		}
+		protocol = data[9]
+		tuple.SrcIP, tuple.DstIP = net.IP(data[12:16]), net.IP(data[16:20])
+		data = data[headerSize:totalLength]
+	case 6:
+		if len(data) < 40 {
+			return
+		}
+		var payloadLength = int(binary.BigEndian.Uint16(data[4:6]))
+		if 40+payloadLength > len(data) {
+			return
+		}
+		protocol = data[6]
+		tuple.SrcIP, tuple.DstIP = net.IP(data[8:24]), net.IP(data[24:40])
+		data = data[40 : 40+payloadLength]
+
+		// hop-by-hop, routing and destination options headers
+		for protocol == 0 || protocol == 43 || protocol == 60 {
+			if len(data) < 8 || len(data) < 8+int(data[1])*8 {
+				return
+			}
+			protocol, data = data[0], data[8+int(data[1])*8:]
+		}
+	default:
+		return
+	}
+
+	if protocol != 6 || len(data) < 20 {
+		return
+	}
+	var headerSize = int(data[12]>>4) * 4
+	if headerSize < 20 || headerSize > len(data) {
+		return
+	}
+	tuple.Protocol = "tcp"
+	tuple.SrcPort = binary.BigEndian.Uint16(data[0:2])
+	tuple.DstPort = binary.BigEndian.Uint16(data[2:4])
+	seg = segment{seq: binary.BigEndian.Uint32(data[4:8]), timestamp: p.timestamp, data: data[headerSize:]}
+	return tuple, seg, data[13], true
+}
+
+// linkPayload strips the link-layer header, found is false when the frame
+// doesn't carry IPv4 or IPv6.
+func linkPayload(linkType uint32, data []byte) ([]byte, bool) {
+	var etherType uint16
+	switch linkType {
+	case linkTypeEthernet:
+		if len(data) < 14 {
+			return nil, false
+		}
+		etherType, data = binary.BigEndian.Uint16(data[12:14]), data[14:]
+		// 802.1Q and 802.1ad tags
+		for (etherType == 0x8100 || etherType == 0x88a8) && len(data) >= 4 {
+			etherType, data = binary.BigEndian.Uint16(data[2:4]), data[4:]
+		}
+	case linkTypeNull, linkTypeLoop:
+		if len(data) < 4 {
+			return nil, false
+		}
+		data = data[4:]
+	case linkTypeLinuxSLL:
+		if len(data) < 16 {
+			return nil, false
+		}
+		etherType, data = binary.BigEndian.Uint16(data[14:16]), data[16:]
+	case linkTypeLinuxSLL2:
+		if len(data) < 20 {
+			return nil, false
+		}
+		etherType, data = binary.BigEndian.Uint16(data[0:2]), data[20:]
+	case linkTypeRaw, linkTypeIPv4, linkTypeIPv6, 12, 14:
+	default:
+		return nil, false
+	}
+
+	if etherType != 0 && etherType != 0x0800 && etherType != 0x86dd || len(data) == 0 {
+		return nil, false
+	}
+	return data, true
+}
+
+// maxBlockSize bounds the records of a capture, so a corrupted length
+// doesn't allocate gigabytes.
+const maxBlockSize = 16 * 1024 * 1024
+
+// readPackets calls fn with every frame of a pcap or pcapng capture.
+func readPackets(r io.Reader, fn func(packet)) error {
+	var br = bufio.NewReader(r)
+	var magic, err = br.Peek(4)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrBadCapture, err)
+	}
+	if binary.BigEndian.Uint32(magic) == 0x0a0d0d0a {
+		return readPcapng(br, fn)
+	}
+	return readClassicPcap(br, fn)
+}
+
+// readClassicPcap reads the libpcap format.
+//
+// reference: https://datatracker.ietf.org/doc/draft-ietf-opsawg-pcap/
+func readClassicPcap(r io.Reader, fn func(packet)) error {
+	var header = make([]byte, 24)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return fmt.Errorf("%w: %v", ErrBadCapture, err)
+	}
+
+	var order binary.ByteOrder
+	var nanoseconds bool
+	switch magic := binary.LittleEndian.Uint32(header); magic {
+	case 0xa1b2c3d4, 0xa1b23c4d:
+		order, nanoseconds = binary.LittleEndian, magic == 0xa1b23c4d
+	case 0xd4c3b2a1, 0x4d3cb2a1:
+		order, nanoseconds = binary.BigEndian, magic == 0x4d3cb2a1
+	default:
+		return ErrBadCapture
+	}
+	var linkType = order.Uint32(header[20:24]) & 0x0fffffff
+
+	var record = make([]byte, 16)
+	for {
+		if _, err := io.ReadFull(r, record); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("pcap: record header: %w", err)
+		}
+
+		var length = order.Uint32(record[8:12])
+		if length > maxBlockSize {
+			return fmt.Errorf("pcap: record of %d bytes", length)
+		}
+		var data = make([]byte, length)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return fmt.Errorf("pcap: record: %w", err)
+		}
+
+		var fraction = int64(order.Uint32(record[4:8]))
+		if !nanoseconds {
+			fraction *= 1000
+		}
+		fn(packet{
+			timestamp: time.Unix(int64(order.Uint32(record[0:4])), fraction).UTC(),
+			linkType:  linkType,
+			data:      data,
+		})
+	}
+}
+
+// pcapngInterface is what an Interface Description Block tells about the
+// frames of an interface.
+type pcapngInterface struct {
+	linkType uint32
+	// ticks per second of the timestamps
+	resolution uint64
+}
+
+// readPcapng reads the pcapng format, only the Enhanced and Simple Packet
+// Blocks carry frames.
+//
+// reference: https://datatracker.ietf.org/doc/draft-ietf-opsawg-pcapng/
+func readPcapng(r io.Reader, fn func(packet)) error {
+	var order binary.ByteOrder = binary.LittleEndian
+	var interfaces []pcapngInterface
+	var header = make([]byte, 12)
+	for {
+		if _, err := io.ReadFull(r, header[:8]); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("pcapng: block header: %w", err)
+		}
+
+		var blockType = order.Uint32(header[0:4])
+		if blockType == 0x0a0d0d0a {
+			// the byte-order magic of a Section Header Block follows the length
+			if _, err := io.ReadFull(r, header[8:12]); err != nil {
+				return fmt.Errorf("pcapng: section header: %w", err)
+			}
+			switch binary.LittleEndian.Uint32(header[8:12]) {
+			case 0x1a2b3c4d:
+				order = binary.LittleEndian
+			case 0x4d3c2b1a:
+				order = binary.BigEndian
+			default:
+				return ErrBadCapture
+			}
+			interfaces = nil
+		}
+
+		var length = order.Uint32(header[4:8])
+		var read = 8
+		if blockType == 0x0a0d0d0a {
+			read = 12
+		}
+		if length%4 != 0 || length < uint32(read)+4 || length > maxBlockSize {
+			return fmt.Errorf("pcapng: block of %d bytes", length)
+		}
+		var body = make([]byte, int(length)-read)
+		if _, err := io.ReadFull(r, body); err != nil {
+			return fmt.Errorf("pcapng: block: %w", err)
+		}
+		body = body[:len(body)-4] // trailing Block Total Length
+
+		switch blockType {
+		case 1: // Interface Description Block
+			if len(body) < 8 {
+				return errors.New("pcapng: short interface description block")
+			}
+			interfaces = append(interfaces, pcapngInterface{
+				linkType:   uint32(order.Uint16(body[0:2])),
+				resolution: pcapngResolution(order, body[8:]),
+			})
+		case 6: // Enhanced Packet Block
+			if len(body) < 20 {
+				return errors.New("pcapng: short enhanced packet block")
+			}
+			var id = order.Uint32(body[0:4])
+			var captured = order.Uint32(body[12:16])
+			if int(id) >= len(interfaces) || uint64(captured) > uint64(len(body)-20) {
+				return errors.New("pcapng: bad enhanced packet block")
+			}
+			var ticks = uint64(order.Uint32(body[4:8]))<<32 | uint64(order.Uint32(body[8:12]))
+			fn(packet{
+				timestamp: pcapngTime(ticks, interfaces[id].resolution),
+				linkType:  interfaces[id].linkType,
+				data:      body[20 : 20+captured],
+			})
+		case 3: // Simple Packet Block, without a timestamp
+			if len(body) < 4 || len(interfaces) == 0 {
+				return errors.New("pcapng: bad simple packet block")
+			}
+			var captured = order.Uint32(body[0:4])
+			if uint64(captured) > uint64(len(body)-4) {
+				captured = uint32(len(body) - 4)
+			}
+			fn(packet{linkType: interfaces[0].linkType, data: body[4 : 4+captured]})
+		}
+	}
+}
+
+// pcapngResolution returns the ticks per second set by the if_tsresol
+// option, microseconds by default.
+func pcapngResolution(order binary.ByteOrder, options []byte) uint64 {
+	for len(options) >= 4 {
+		var code, length = order.Uint16(options[0:2]), int(order.Uint16(options[2:4]))
+		if code == 0 || 4+length > len(options) {
+			break
+		}
+		if code == 9 && length >= 1 {
+			var value = options[4]
+			if value&0x80 != 0 {
+				if value&0x7f >= 64 {
+					break
+				}
+				return 1 << (value & 0x7f)
+			}
+			if value > 19 {
+				break
+			}
+			var result uint64 = 1
+			for i := byte(0); i < value; i++ {
+				result *= 10
+			}
+			return result
+		}
+		if next := 4 + (length+3)/4*4; next < len(options) {
+			options = options[next:]
+		} else {
+			break
+		}
+	}
+	return 1000000
+}
+
+func pcapngTime(ticks, resolution uint64) time.Time {
+	var seconds = ticks / resolution
+	var fraction = ticks % resolution
+	var nanoseconds = int64(math.Round(float64(fraction) * 1e9 / float64(resolution)))
+	return time.Unix(int64(seconds), nanoseconds).UTC()
+}
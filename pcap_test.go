@@ -0,0 +1,447 @@
+package ntlm_parser
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testSegment struct {
+	at      time.Duration
+	from    int // 0 the client, 1 the server
+	seq     uint32
+	flags   byte
+	payload string
+}
+
+var pcapEpoch = time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+
+// tcpPacket returns an IPv4 or IPv6 packet carrying a TCP segment.
+func tcpPacket(src, dst net.IP, srcPort, dstPort uint16, seq uint32, flags byte, payload []byte) []byte {
+	var tcp = make([]byte, 20, 20+len(payload))
+	binary.BigEndian.PutUint16(tcp[0:], srcPort)
+	binary.BigEndian.PutUint16(tcp[2:], dstPort)
+	binary.BigEndian.PutUint32(tcp[4:], seq)
+	tcp[12] = 5 << 4
+	tcp[13] = flags | 0x10
+	tcp = append(tcp, payload...)
+
+	if src.To4() != nil {
+		var ip = make([]byte, 20, 20+len(tcp))
+		ip[0] = 0x45
+		binary.BigEndian.PutUint16(ip[2:], uint16(20+len(tcp)))
+		ip[8], ip[9] = 64, 6
+		copy(ip[12:], src.To4())
+		copy(ip[16:], dst.To4())
+		return append(ip, tcp...)
+	}
+	var ip = make([]byte, 40, 40+len(tcp))
+	ip[0] = 0x60
+	binary.BigEndian.PutUint16(ip[4:], uint16(len(tcp)))
+	ip[6], ip[7] = 6, 64
+	copy(ip[8:], src.To16())
+	copy(ip[24:], dst.To16())
+	return append(ip, tcp...)
+}
+
+func testFrames(conn FiveTuple, segments []testSegment, ethernet bool) [][]byte {
+	var frames [][]byte
+	for _, s := range segments {
+		var flow = conn
+		if s.from == 1 {
+			flow = conn.Reverse()
+		}
+		var frame = tcpPacket(flow.SrcIP, flow.DstIP, flow.SrcPort, flow.DstPort, s.seq, s.flags, []byte(s.payload))
+		if ethernet {
+			frame = append(append(make([]byte, 12), 0x08, 0x00), frame...)
+		}
+		frames = append(frames, frame)
+	}
+	return frames
+}
+
+// classicPcap writes a little-endian, microsecond pcap of Ethernet frames.
+func classicPcap(segments []testSegment, frames [][]byte) []byte {
+	var b bytes.Buffer
+	var header = []uint32{0xa1b2c3d4, 2 | 4<<16, 0, 0, 65535, linkTypeEthernet}
+	binary.Write(&b, binary.LittleEndian, header)
+	for i, frame := range frames {
+		var ts = pcapEpoch.Add(segments[i].at)
+		binary.Write(&b, binary.LittleEndian, []uint32{uint32(ts.Unix()), uint32(ts.Nanosecond() / 1000), uint32(len(frame)), uint32(len(frame))})
+		b.Write(frame)
+	}
+	return b.Bytes()
+}
+
+// pcapng writes a big-endian, nanosecond pcapng of raw IP packets.
+func pcapng(segments []testSegment, frames [][]byte) []byte {
+	var b bytes.Buffer
+	var block = func(blockType uint32, body []byte) {
+		for len(body)%4 != 0 {
+			body = append(body, 0)
+		}
+		binary.Write(&b, binary.BigEndian, []uint32{blockType, uint32(12 + len(body))})
+		b.Write(body)
+		binary.Write(&b, binary.BigEndian, uint32(12+len(body)))
+	}
+
+	block(0x0a0d0d0a, []byte{0x1a, 0x2b, 0x3c, 0x4d, 0, 1, 0, 0, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
+	// linktype RAW, if_tsresol 9, opt_endofopt
+	block(1, []byte{0, linkTypeRaw, 0, 0, 0, 0, 0, 0, 0, 9, 0, 1, 9, 0, 0, 0, 0, 0, 0, 0})
+	for i, frame := range frames {
+		var ticks = uint64(pcapEpoch.Add(segments[i].at).UnixNano())
+		var body = make([]byte, 20, 20+len(frame))
+		binary.BigEndian.PutUint32(body[4:], uint32(ticks>>32))
+		binary.BigEndian.PutUint32(body[8:], uint32(ticks))
+		binary.BigEndian.PutUint32(body[12:], uint32(len(frame)))
+		binary.BigEndian.PutUint32(body[16:], uint32(len(frame)))
+		block(6, append(body, frame...))
+	}
+	return b.Bytes()
+}
+
+func TestReadPcap(t *testing.T) {
+	var type1, _ = base64.StdEncoding.DecodeString(scanType1)
+	var type2, _ = base64.StdEncoding.DecodeString(scanType2)
+	var type3, _ = base64.StdEncoding.DecodeString(scanType3)
+
+	var http = FiveTuple{Protocol: "tcp", SrcIP: net.IPv4(10, 0, 0, 1).To4(), SrcPort: 50000, DstIP: net.IPv4(10, 0, 0, 2).To4(), DstPort: 80}
+	var request = "GET / HTTP/1.1\r\nAuthorization: NTLM " + scanType1 + "\r\n\r\n"
+	var response = "HTTP/1.1 401 Unauthorized\r\nWWW-Authenticate: NTLM " + scanType2 + "\r\n\r\n"
+	var httpSegments = []testSegment{
+		{at: 0, from: 0, seq: 999, flags: tcpSYN},
+		{at: 1 * time.Millisecond, from: 1, seq: 4999, flags: tcpSYN},
+		// the second half arrives first, the first half is retransmitted
+		{at: 3 * time.Millisecond, from: 0, seq: 1000 + 40, payload: request[40:]},
+		{at: 2 * time.Millisecond, from: 0, seq: 1000, payload: request[:40]},
+		{at: 4 * time.Millisecond, from: 0, seq: 1000, payload: request[:40]},
+		{at: 5 * time.Millisecond, from: 1, seq: 5000, payload: response},
+		{at: 6 * time.Millisecond, from: 0, seq: 1000 + uint32(len(request)), payload: "GET / HTTP/1.1\r\nAuthorization: NTLM " + scanType3 + "\r\n\r\n"},
+	}
+
+	var httpExchanges = []Exchange{{
+		Connection:   http,
+		Negotiate:    &CapturedMessage{Flow: http, Timestamp: pcapEpoch.Add(2 * time.Millisecond), Offset: int64(strings.Index(request, "TlRM"))},
+		Challenge:    &CapturedMessage{Flow: http.Reverse(), Timestamp: pcapEpoch.Add(5 * time.Millisecond), Offset: int64(strings.Index(response, "TlRM"))},
+		Authenticate: &CapturedMessage{Flow: http, Timestamp: pcapEpoch.Add(6 * time.Millisecond), Offset: int64(len(request) + strings.Index(request, "TlRM"))},
+	}}
+
+	// TCP segmentation offload leaves the IPv4 total length at 0
+	var tsoFrames = testFrames(http, httpSegments, true)
+	for _, frame := range tsoFrames {
+		binary.BigEndian.PutUint16(frame[14+2:], 0)
+	}
+
+	// Negotiate with SPNEGO, the NTLM messages sit at any byte offset of the
+	// base64 tokens
+	var negotiate = FiveTuple{Protocol: "tcp", SrcIP: net.IPv4(10, 0, 0, 1).To4(), SrcPort: 50002, DstIP: net.IPv4(10, 0, 0, 2).To4(), DstPort: 80}
+	var spnegoHeader = func(prefix, token string) (string, int64) {
+		var wrapped = mustWrap(t, token)
+		// the offset of the base64 quantum holding the signature
+		return prefix + base64.StdEncoding.EncodeToString(wrapped) + "\r\n\r\n", int64(len(prefix) + bytes.Index(wrapped, signature)/3*4)
+	}
+	var spnegoRequest, spnegoRequestOffset = spnegoHeader("GET / HTTP/1.1\r\nAuthorization: Negotiate ", scanType1)
+	var spnegoResponse, spnegoResponseOffset = spnegoHeader("HTTP/1.1 401 Unauthorized\r\nWWW-Authenticate: Negotiate ", scanType2)
+	var spnegoAuthenticate, spnegoAuthenticateOffset = spnegoHeader("GET / HTTP/1.1\r\nAuthorization: Negotiate ", scanType3)
+	var negotiateSegments = []testSegment{
+		{at: 20 * time.Millisecond, from: 0, seq: 100, payload: spnegoRequest},
+		{at: 21 * time.Millisecond, from: 1, seq: 200, payload: spnegoResponse},
+		{at: 22 * time.Millisecond, from: 0, seq: 100 + uint32(len(spnegoRequest)), payload: spnegoAuthenticate},
+	}
+
+	// SMB-like binary framing over IPv6, without the handshake
+	var smb = FiveTuple{Protocol: "tcp", SrcIP: net.ParseIP("fe80::1"), SrcPort: 50001, DstIP: net.ParseIP("fe80::2"), DstPort: 445}
+	var smbSegments = []testSegment{
+		{at: 10 * time.Millisecond, from: 0, seq: 7, payload: "\x00\x00\x00\x28" + string(type1)},
+		{at: 11 * time.Millisecond, from: 1, seq: 9, payload: "\x00\x00\x01\x00" + string(type2)},
+		{at: 12 * time.Millisecond, from: 0, seq: 7 + 44, payload: "\x00\x00\x01\xbe" + string(type3)},
+		{at: 13 * time.Millisecond, from: 0, seq: 7 + 44 + 450, payload: "\x00\x00\x00\x28" + string(type1)},
+	}
+
+	tests := []struct {
+		name    string
+		capture []byte
+		want    []Exchange
+	}{
+		{
+			name:    "pcap",
+			capture: classicPcap(httpSegments, testFrames(http, httpSegments, true)),
+			want:    httpExchanges,
+		},
+		{
+			name:    "TSO",
+			capture: classicPcap(httpSegments, tsoFrames),
+			want:    httpExchanges,
+		},
+		{
+			name:    "Negotiate",
+			capture: classicPcap(negotiateSegments, testFrames(negotiate, negotiateSegments, true)),
+			want: []Exchange{{
+				Connection:   negotiate,
+				Negotiate:    &CapturedMessage{Flow: negotiate, Timestamp: pcapEpoch.Add(20 * time.Millisecond), Offset: spnegoRequestOffset},
+				Challenge:    &CapturedMessage{Flow: negotiate.Reverse(), Timestamp: pcapEpoch.Add(21 * time.Millisecond), Offset: spnegoResponseOffset},
+				Authenticate: &CapturedMessage{Flow: negotiate, Timestamp: pcapEpoch.Add(22 * time.Millisecond), Offset: int64(len(spnegoRequest)) + spnegoAuthenticateOffset},
+			}},
+		},
+		{
+			name:    "pcapng",
+			capture: pcapng(smbSegments, testFrames(smb, smbSegments, false)),
+			want: []Exchange{
+				{
+					Connection:   smb,
+					Negotiate:    &CapturedMessage{Flow: smb, Timestamp: pcapEpoch.Add(10 * time.Millisecond), Offset: 4},
+					Challenge:    &CapturedMessage{Flow: smb.Reverse(), Timestamp: pcapEpoch.Add(11 * time.Millisecond), Offset: 4},
+					Authenticate: &CapturedMessage{Flow: smb, Timestamp: pcapEpoch.Add(12 * time.Millisecond), Offset: 48},
+				},
+				{
+					Connection: smb,
+					Negotiate:  &CapturedMessage{Flow: smb, Timestamp: pcapEpoch.Add(13 * time.Millisecond), Offset: 498},
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got, err = ReadPcap(bytes.NewReader(tt.capture))
+			if err != nil {
+				t.Fatalf("ReadPcap() error = %v", err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("ReadPcap() got %d exchanges, want %d", len(got), len(tt.want))
+			}
+			for i := range got {
+				if got[i].Connection.String() != tt.want[i].Connection.String() {
+					t.Errorf("exchange %d: Connection = %s, want %s", i, got[i].Connection, tt.want[i].Connection)
+				}
+				for j, pair := range [][2]*CapturedMessage{
+					{got[i].Negotiate, tt.want[i].Negotiate},
+					{got[i].Challenge, tt.want[i].Challenge},
+					{got[i].Authenticate, tt.want[i].Authenticate},
+				} {
+					var got, want = pair[0], pair[1]
+					if (got == nil) != (want == nil) {
+						t.Fatalf("exchange %d message %d: got %v, want %v", i, j, got, want)
+					}
+					if got == nil {
+						continue
+					}
+					if got.Flow.String() != want.Flow.String() || !got.Timestamp.Equal(want.Timestamp) || got.Offset != want.Offset {
+						t.Errorf("exchange %d message %d: got %s %s %d, want %s %s %d", i, j,
+							got.Flow, got.Timestamp, got.Offset, want.Flow, want.Timestamp, want.Offset)
+					}
+					data, _ := got.Message.Bytes()
+					if !bytes.Equal(data, [][]byte{type1, type2, type3}[j]) {
+						t.Errorf("exchange %d message %d: Bytes() = %x", i, j, data)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestReadPcapProtocols(t *testing.T) {
+	var type1, _ = base64.StdEncoding.DecodeString(scanType1)
+	var type2, _ = base64.StdEncoding.DecodeString(scanType2)
+	var type3, _ = base64.StdEncoding.DecodeString(scanType3)
+	var conn = func(port uint16) FiveTuple {
+		return FiveTuple{Protocol: "tcp", SrcIP: net.IPv4(10, 0, 0, 1).To4(), SrcPort: 50000, DstIP: net.IPv4(10, 0, 0, 2).To4(), DstPort: port}
+	}
+	// segments sends the payloads a millisecond apart, in turn from the
+	// client and the server, from first on
+	var segments = func(first int, payloads ...[]byte) []testSegment {
+		var result []testSegment
+		var seq = [2]uint32{1000, 5000}
+		for i, payload := range payloads {
+			var from = (first + i) % 2
+			result = append(result, testSegment{at: time.Duration(i) * time.Millisecond, from: from, seq: seq[from], payload: string(payload)})
+			seq[from] += uint32(len(payload))
+		}
+		return result
+	}
+
+	var smb = [][]byte{
+		netBIOS(smb2Message(SMB2_SESSION_SETUP, false, 0, 1, 0, sessionSetupRequest(1, mustWrap(t, scanType1)))),
+		netBIOS(smb2Message(SMB2_SESSION_SETUP, true, 0xc0000016, 1, 0x1234, sessionSetupResponse(0, mustWrap(t, scanType2)))),
+		netBIOS(smb2Message(SMB2_SESSION_SETUP, false, 0, 2, 0x1234, sessionSetupRequest(1, mustWrap(t, scanType3)))),
+	}
+	var sasl = func(creds []byte) []byte {
+		return ber(0xa3, ber(0x04, []byte("GSS-SPNEGO")), ber(0x04, creds))
+	}
+	var ldap = [][]byte{
+		ldapMessage(1, bindRequest("", sasl(mustWrap(t, scanType1)))),
+		ldapMessage(1, bindResponse(14, nil, ber(0x87, mustWrap(t, scanType2)))),
+		ldapMessage(2, bindRequest("", sasl(mustWrap(t, scanType3)))),
+	}
+	var dcerpc = [][]byte{
+		dcerpcPDU(DCERPC_BIND, 2, bindBody(0), RPC_C_AUTHN_WINNT, RPC_C_AUTHN_LEVEL_PKT_PRIVACY, type1),
+		dcerpcPDU(DCERPC_BIND_ACK, 2, bindBody(0x1234), RPC_C_AUTHN_WINNT, RPC_C_AUTHN_LEVEL_PKT_PRIVACY, type2),
+		dcerpcPDU(DCERPC_AUTH3, 2, make([]byte, 4), RPC_C_AUTHN_WINNT, RPC_C_AUTHN_LEVEL_PKT_PRIVACY, type3),
+	}
+	var smtp = [][]byte{
+		[]byte("220 mail.example.com ESMTP\r\n"),
+		[]byte("EHLO client.example.com\r\n"),
+		[]byte("250-mail.example.com Hello\r\n250 AUTH NTLM\r\n"),
+		[]byte("AUTH NTLM " + scanType1 + "\r\n"),
+		[]byte("334 " + scanType2 + "\r\n"),
+		[]byte(scanType3 + "\r\n"),
+		[]byte("235 2.7.0 Authentication successful\r\n"),
+	}
+
+	// the messages found in order, their offsets are searched in the
+	// payloads and moved by the bytes lost in a gap
+	tests := []struct {
+		name     string
+		conn     FiveTuple
+		segments []testSegment
+		lost     int
+		want     []CapturedMessage
+	}{
+		{
+			name:     "SMB2",
+			conn:     conn(445),
+			segments: segments(0, smb...),
+			want: []CapturedMessage{
+				{Protocol: "SMB2", SessionID: 0},
+				{Protocol: "SMB2", SessionID: 0x1234},
+				{Protocol: "SMB2", SessionID: 0x1234},
+			},
+		},
+		{
+			name:     "LDAP",
+			conn:     conn(389),
+			segments: segments(0, ldap...),
+			want:     []CapturedMessage{{Protocol: "LDAP", MessageID: 1}, {Protocol: "LDAP", MessageID: 1}, {Protocol: "LDAP", MessageID: 2}},
+		},
+		{
+			name:     "DCE/RPC on a dynamic port",
+			conn:     conn(49667),
+			segments: segments(0, dcerpc...),
+			want: []CapturedMessage{
+				{Protocol: "DCE/RPC", AuthLevel: RPC_C_AUTHN_LEVEL_PKT_PRIVACY},
+				{Protocol: "DCE/RPC", AuthLevel: RPC_C_AUTHN_LEVEL_PKT_PRIVACY},
+				{Protocol: "DCE/RPC", AuthLevel: RPC_C_AUTHN_LEVEL_PKT_PRIVACY},
+			},
+		},
+		{
+			name:     "SMTP",
+			conn:     conn(25),
+			segments: segments(1, smtp...),
+			want:     []CapturedMessage{{Protocol: "SMTP"}, {Protocol: "SMTP"}, {Protocol: "SMTP"}},
+		},
+		{
+			// the header of the first SESSION_SETUP is cut by a gap, its
+			// message is carved out and the next PDU decoded
+			name: "SMB2 after a gap",
+			conn: conn(445),
+			segments: []testSegment{
+				{from: 0, seq: 1000, payload: string(smb[0][:10])},
+				{at: 1 * time.Millisecond, from: 0, seq: 1020, payload: string(smb[0][20:]) + string(smb[2])},
+			},
+			lost: 10,
+			want: []CapturedMessage{{Protocol: ""}, {Protocol: "SMB2", SessionID: 0x1234}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got, err = ReadPcap(bytes.NewReader(classicPcap(tt.segments, testFrames(tt.conn, tt.segments, true))))
+			if err != nil {
+				t.Fatalf("ReadPcap() error = %v", err)
+			}
+			var messages []*CapturedMessage
+			for _, e := range got {
+				for _, m := range []*CapturedMessage{e.Negotiate, e.Challenge, e.Authenticate} {
+					if m != nil {
+						messages = append(messages, m)
+					}
+				}
+			}
+			if len(messages) != len(tt.want) {
+				t.Fatalf("ReadPcap() got %d messages, want %d", len(messages), len(tt.want))
+			}
+
+			var streams = map[string][]byte{}
+			var offsets = map[string]int{}
+			for _, s := range tt.segments {
+				var flow = tt.conn
+				if s.from == 1 {
+					flow = tt.conn.Reverse()
+				}
+				streams[flow.key()] = append(streams[flow.key()], s.payload...)
+			}
+			for i, m := range messages {
+				var want = tt.want[i]
+				if m.Protocol != want.Protocol || m.SessionID != want.SessionID || m.MessageID != want.MessageID || m.AuthLevel != want.AuthLevel {
+					t.Errorf("message %d: got %q %#x %d %s, want %q %#x %d %s", i,
+						m.Protocol, m.SessionID, m.MessageID, m.AuthLevel, want.Protocol, want.SessionID, want.MessageID, want.AuthLevel)
+				}
+
+				var data, _ = m.Message.Bytes()
+				if !bytes.Equal(data, type1) && !bytes.Equal(data, type2) && !bytes.Equal(data, type3) {
+					t.Errorf("message %d: Bytes() = %x", i, data)
+				}
+				if m.Protocol == "SMTP" {
+					data = []byte(base64.StdEncoding.EncodeToString(data))
+				}
+				var key = m.Flow.key()
+				var at = offsets[key] + bytes.Index(streams[key][offsets[key]:], data)
+				if m.Offset != int64(at+tt.lost) {
+					t.Errorf("message %d: Offset = %d, want %d", i, m.Offset, at+tt.lost)
+				}
+				offsets[key] = at + len(data)
+			}
+		})
+	}
+}
+
+func TestGroupExchanges(t *testing.T) {
+	var conn = FiveTuple{Protocol: "tcp", SrcIP: net.IPv4(10, 0, 0, 1).To4(), SrcPort: 50000, DstIP: net.IPv4(10, 0, 0, 2).To4(), DstPort: 445}
+	var message = func(ms int, flow FiveTuple, messageType NTLMMessageType) *CapturedMessage {
+		return &CapturedMessage{Flow: flow, Timestamp: pcapEpoch.Add(time.Duration(ms) * time.Millisecond), messageType: messageType}
+	}
+
+	// the CHALLENGE goes the same way as the NEGOTIATE, it can't answer it
+	var got = groupExchanges([]*CapturedMessage{
+		message(0, conn, NEGOTIATE_MESSAGE),
+		message(1, conn, CHALLENGE_MESSAGE),
+		message(2, conn.Reverse(), AUTHENTICATE_MESSAGE),
+	})
+	var want = []FiveTuple{conn, conn.Reverse()}
+	if len(got) != len(want) {
+		t.Fatalf("groupExchanges() got %d exchanges, want %d", len(got), len(want))
+	}
+	for i, e := range got {
+		if e.Connection.String() != want[i].String() {
+			t.Errorf("exchange %d: Connection = %s, want %s", i, e.Connection, want[i])
+		}
+	}
+	if got[0].Challenge != nil || got[1].Challenge == nil || got[1].Authenticate == nil {
+		t.Errorf("groupExchanges() got = %+v", got)
+	}
+}
+
+func TestReadPcapErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		capture []byte
+	}{
+		{name: "empty"},
+		{name: "text", capture: []byte("GET / HTTP/1.1\r\nHost: example.com\r\n\r\n")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ReadPcap(bytes.NewReader(tt.capture)); !errors.Is(err, ErrBadCapture) {
+				t.Errorf("ReadPcap() error = %v, want %v", err, ErrBadCapture)
+			}
+		})
+	}
+
+	var truncated = classicPcap([]testSegment{{}}, [][]byte{make([]byte, 60)})
+	if _, err := ReadPcap(bytes.NewReader(truncated[:len(truncated)-1])); err == nil {
+		t.Error("ReadPcap() of a truncated capture succeeded")
+	}
+}
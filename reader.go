@@ -0,0 +1,122 @@
+package ntlm_parser
+
+import (
+	"encoding/binary"
+)
+
+// reader reads the fields of one message with bounds checks. Reads never
+// panic, a failed read returns a zero value and the first failure is kept
+// in err, or in lenient mode every failure is appended to warnings.
+type reader struct {
+	buf         []byte
+	messageType NTLMMessageType
+	err         error
+
+	lenient  bool
+	warnings []*ParseError
+}
+
+func newReader(buf []byte, messageType NTLMMessageType) *reader {
+	return &reader{buf: buf, messageType: messageType}
+}
+
+func (o ParseOptions) newReader(buf []byte, messageType NTLMMessageType) *reader {
+	return &reader{buf: buf, messageType: messageType, lenient: o.Lenient}
+}
+
+func (r *reader) fail(field string, offset int, cause error) {
+	var err = &ParseError{MessageType: r.messageType, Field: field, Offset: offset, Err: cause}
+	if r.lenient {
+		r.warnings = append(r.warnings, err)
+	} else if r.err == nil {
+		r.err = err
+	}
+}
+
+func (r *reader) bytes(field string, offset, length int) []byte {
+	if offset < 0 || length < 0 || offset+length > len(r.buf) {
+		r.fail(field, offset, ErrTruncated)
+		return nil
+	}
+	return r.buf[offset : offset+length]
+}
+
+func (r *reader) uint32(field string, offset int) uint32 {
+	if r.bytes(field, offset, 4) == nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint32(r.buf[offset : offset+4])
+}
+
+func (r *reader) secBuf(field string, offset int) SecurityBuffer {
+	if r.bytes(field, offset, 8) == nil {
+		return SecurityBuffer{}
+	}
+	return getSecBuf(r.buf, offset)
+}
+
+// payload returns the bytes referenced by secBuf, the buffer has to fit in
+// the message and Allocated can't be smaller than Length. In lenient mode
+// the part of a truncated buffer that is there is returned.
+func (r *reader) payload(field string, secBuf SecurityBuffer) []byte {
+	if secBuf.Allocated < secBuf.Length || !secBuf.within(len(r.buf)) {
+		r.fail(field, secBuf.Offset, ErrBufferOutOfRange)
+		if r.lenient && secBuf.Offset >= 0 && secBuf.Offset < len(r.buf) {
+			var end = len(r.buf)
+			if secBuf.Length < end-secBuf.Offset {
+				end = secBuf.Offset + secBuf.Length
+			}
+			return r.buf[secBuf.Offset:end]
+		}
+		return nil
+	}
+	return r.buf[secBuf.Offset : secBuf.Offset+secBuf.Length]
+}
+
+// avPairs walks the AV_PAIR list in [offset, offset+length), stopping after
+// MsvAvEOL. A pair that runs past the end fails with ErrBufferOutOfRange.
+//
+// reference: https://learn.microsoft.com/en-us/openspecs/windows_protocols/ms-nlmp/83f5e789-660d-4781-8491-5f8c6641f75e
+func (r *reader) avPairs(field string, offset, length int) AvPairList {
+	var result AvPairList
+	var end = offset + length
+	for offset < end {
+		if offset+4 > end {
+			r.fail(field, offset, ErrBufferOutOfRange)
+			break
+		}
+		var header = r.bytes(field, offset, 4)
+		if header == nil {
+			break
+		}
+
+		var item = TargetInfo{
+			Type:   int(binary.LittleEndian.Uint16(header[0:2])),
+			Length: int(binary.LittleEndian.Uint16(header[2:4])),
+		}
+		if offset+4+item.Length > end {
+			r.fail(field, offset, ErrBufferOutOfRange)
+			break
+		}
+
+		item.Value = decodeAvPair(AvID(item.Type), r.buf[offset+4:offset+4+item.Length])
+		item.Content = item.Value.String()
+
+		result = append(result, item)
+		offset += 2 + 2 + item.Length
+
+		if item.Type == 0 {
+			// MsvAvEOL, anything after it is padding
+			break
+		}
+	}
+
+	return result
+}
+
+func (r *reader) version(field string, offset int) OSVersionStructure {
+	if r.bytes(field, offset, 8) == nil {
+		return OSVersionStructure{}
+	}
+	return getOSVersionStructure(r.buf, offset)
+}
@@ -0,0 +1,315 @@
+package ntlm_parser
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+)
+
+// base64Signatures are the base64 encodings of "NTLMSSP\x00" starting 0, 1
+// and 2 bytes into a 3-byte group, up to the last character that doesn't
+// depend on the message type. The one at shift k starts base64SigOffsets[k]
+// characters into its 4-character quantum, the characters before it
+// depend on the bytes before the message.
+var (
+	base64Signatures = [][]byte{[]byte("TlRMTVNTUA"), []byte("5UTE1TU1AA"), []byte("OVExNU1NQA")}
+	base64SigOffsets = []int{0, 2, 3}
+)
+
+const (
+	scanChunkSize = 32 * 1024
+
+	// scanLookbehind is what is kept of the buffer when no signature is
+	// found, it may hold the beginning of one and its quantum.
+	scanLookbehind = 10 + 3
+)
+
+// Scanner finds the NTLM messages in a stream such as a memory dump, a
+// proxy log or a binary blob, raw or base64 encoded. A base64 message may
+// be embedded at any byte offset of the decoded run, e.g. the NTLM token
+// of a SPNEGO Negotiate header.
+//
+//	var s = parser.NewScanner(r)
+//	for s.Next() {
+//		fmt.Println(s.Offset(), s.Message())
+//	}
+//	if err := s.Err(); err != nil {
+//		...
+//	}
+//
+// Every signature found starts a candidate, its length is worked out from
+// its security buffers and the candidates that can't be parsed with the
+// ParseOptions are skipped.
+type Scanner struct {
+	r    io.Reader
+	opts ParseOptions
+	buf  []byte
+	base int64 // stream offset of buf[0]
+	pos  int   // where the search resumes in buf
+	eof  bool
+	err  error
+
+	offset  int64
+	data    []byte
+	message NTLMMessage
+	encoded bool
+}
+
+func NewScanner(r io.Reader) *Scanner {
+	return ParseOptions{}.NewScanner(r)
+}
+
+func (o ParseOptions) NewScanner(r io.Reader) *Scanner {
+	return &Scanner{r: r, opts: o}
+}
+
+// Next advances to the next message, it returns false at the end of the
+// stream or on a read error.
+func (s *Scanner) Next() bool {
+	s.message, s.data, s.encoded = nil, nil, false
+	for {
+		var i, shift = s.find()
+		if i < 0 {
+			if s.eof || s.err != nil {
+				return false
+			}
+			// the tail may hold the beginning of a signature
+			s.discard(len(s.buf) - scanLookbehind)
+			s.fill()
+			continue
+		}
+
+		var encoded = shift >= 0
+		s.discard(i)
+		if !s.ready(encoded) {
+			s.fill()
+			continue
+		}
+
+		var n int
+		if encoded {
+			n = s.carveBase64(shift)
+		} else {
+			n = s.carve()
+		}
+		if n > 0 {
+			s.offset, s.encoded = s.base, encoded
+			s.pos = n
+			return true
+		}
+		s.pos = 1
+	}
+}
+
+// Offset returns the stream offset of the message, or of the base64
+// quantum its encoding starts in.
+func (s *Scanner) Offset() int64 {
+	return s.offset
+}
+
+func (s *Scanner) Message() NTLMMessage {
+	return s.message
+}
+
+// Bytes returns the carved message, decoded when it was base64 encoded.
+func (s *Scanner) Bytes() []byte {
+	return s.data
+}
+
+// Encoded tells whether the message was found base64 encoded.
+func (s *Scanner) Encoded() bool {
+	return s.encoded
+}
+
+// Err returns the first read error, nil at the end of the stream.
+func (s *Scanner) Err() error {
+	return s.err
+}
+
+// find returns the index in buf of the first raw signature, or base64
+// quantum holding a signature, from pos. It returns -1 when there is none,
+// and the shift of the base64 signature or -1 for a raw one.
+func (s *Scanner) find() (int, int) {
+	var first, shift = bytes.Index(s.buf[s.pos:], signature), -1
+	for k, sig := range base64Signatures {
+		var offset = base64SigOffsets[k]
+		if s.pos+offset > len(s.buf) {
+			continue
+		}
+		if i := bytes.Index(s.buf[s.pos+offset:], sig); i >= 0 && (first < 0 || i < first) {
+			first, shift = i, k
+		}
+	}
+	if first < 0 {
+		return -1, -1
+	}
+	return s.pos + first, shift
+}
+
+// discard drops the first n bytes of buf.
+func (s *Scanner) discard(n int) {
+	if n <= 0 {
+		return
+	}
+	s.buf = s.buf[:copy(s.buf, s.buf[n:])]
+	s.base += int64(n)
+	s.pos -= n
+	if s.pos < 0 {
+		s.pos = 0
+	}
+}
+
+func (s *Scanner) fill() {
+	if len(s.buf)+scanChunkSize > cap(s.buf) {
+		var buf = make([]byte, len(s.buf), 2*cap(s.buf)+scanChunkSize)
+		copy(buf, s.buf)
+		s.buf = buf
+	}
+	var n, err = s.r.Read(s.buf[len(s.buf) : len(s.buf)+scanChunkSize])
+	s.buf = s.buf[:len(s.buf)+n]
+	if err == io.EOF {
+		s.eof = true
+	} else if err != nil {
+		s.err = err
+	}
+}
+
+func (s *Scanner) maxMessageSize() int {
+	if max := s.opts.maxMessageSize(); max >= 0 {
+		return max
+	}
+	return DefaultMaxMessageSize
+}
+
+// ready tells whether buf, which starts with a signature, holds enough of
+// the stream to carve the message.
+func (s *Scanner) ready(encoded bool) bool {
+	if s.eof || s.err != nil {
+		return true
+	}
+	if encoded {
+		return base64Length(s.buf) < len(s.buf) || len(s.buf) >= s.maxMessageSize()/3*4+4
+	}
+	return len(s.buf) >= s.maxMessageSize()
+}
+
+// carve parses the raw message at the start of buf and returns its length,
+// 0 when it can't be parsed.
+func (s *Scanner) carve() int {
+	var data = s.buf
+	if max := s.maxMessageSize(); len(data) > max {
+		data = data[:max]
+	}
+	var n = messageLength(data)
+	if n == 0 || !s.parse(data[:n]) {
+		return 0
+	}
+	return n
+}
+
+// carveBase64 parses the base64 encoded message shift bytes into the
+// quantum at the start of buf and returns the length of the encoding, 0
+// when it can't be parsed.
+func (s *Scanner) carveBase64(shift int) int {
+	var n = base64Length(s.buf)
+	var text = bytes.TrimRight(s.buf[:n], "=")
+	if len(text)%4 == 1 {
+		text = text[:len(text)-1]
+	}
+	var data, err = base64.RawStdEncoding.DecodeString(string(text))
+	if err != nil || len(data) < shift {
+		return 0
+	}
+	data = data[shift:]
+	var length = messageLength(data)
+	if length == 0 || !s.parse(data[:length]) {
+		return 0
+	}
+	return n
+}
+
+func (s *Scanner) parse(data []byte) bool {
+	var msg, err = s.opts.FromBytes(data)
+	if err != nil {
+		return false
+	}
+	s.message, s.data = msg, append([]byte(nil), data...)
+	return true
+}
+
+// base64Length returns the length of the run of base64 characters at the
+// start of data, padding included.
+func base64Length(data []byte) int {
+	var n = 0
+	for n < len(data) && isBase64Char(data[n]) {
+		n++
+	}
+	for n < len(data) && data[n] == '=' {
+		n++
+	}
+	return n
+}
+
+func isBase64Char(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '+' || c == '/'
+}
+
+// messageLength works out the length of the message at the start of data
+// from its security buffers, data may run past the end of the message. It
+// returns 0 when the message doesn't fit in data.
+func messageLength(data []byte) int {
+	if len(data) < 16 {
+		return 0
+	}
+
+	// the security buffers, the offset of the flags, the smallest header
+	// and the header with a VERSION
+	var secBufs []int
+	var flagsOffset, minHeader, headerSize int
+	switch binary.LittleEndian.Uint32(data[8:12]) {
+	case 1:
+		secBufs, flagsOffset, minHeader, headerSize = []int{16, 24}, 12, 16, 32
+	case 2:
+		secBufs, flagsOffset, minHeader, headerSize = []int{12, 40}, 20, 32, 48
+	case 3:
+		secBufs, flagsOffset, minHeader, headerSize = []int{12, 20, 28, 36, 44, 52}, 60, 52, 64
+	default:
+		return 0
+	}
+	if len(data) >= flagsOffset+4 && NegotiateFlags(binary.LittleEndian.Uint32(data[flagsOffset:])).Has(NTLMSSP_NEGOTIATE_VERSION) {
+		headerSize += 8
+	}
+
+	// the header ends where the first payload starts, the security buffers
+	// past that point are payload
+	var firstPayload, end = -1, 0
+	for _, offset := range secBufs {
+		if offset+8 > len(data) || firstPayload >= 0 && offset >= firstPayload {
+			break
+		}
+		var secBuf = getSecBuf(data, offset)
+		if secBuf.Offset < minHeader {
+			continue
+		}
+		if firstPayload < 0 || secBuf.Offset < firstPayload {
+			firstPayload = secBuf.Offset
+		}
+		if secBuf.Offset+secBuf.Length > end {
+			end = secBuf.Offset + secBuf.Length
+		}
+	}
+	if firstPayload >= 0 {
+		headerSize = firstPayload
+	} else if len(data) < headerSize || bytes.HasPrefix(data[minHeader:], signature) {
+		headerSize = minHeader
+	}
+
+	if end < headerSize {
+		end = headerSize
+	}
+	if end > len(data) {
+		return 0
+	}
+	return end
+}
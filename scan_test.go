@@ -0,0 +1,145 @@
+package ntlm_parser
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+var (
+	scanType1 = "TlRMTVNTUAABAAAAB4IIogAAAAAAAAAAAAAAAAAAAAAKALpHAAAADw=="
+	scanType2 = "TlRMTVNTUAACAAAABgAGADgAAAA1goniaaCGDXCRRNUAAAAAAAAAAIIAggA+AAAACgC6RwAAAA9KAEwARwACAAYASgBMAEcAAQAQAEMASABPAFUAQwBIAE8AVQAEABIAagBsAGcALgBsAG8AYwBhAGwAAwAkAGMAaABvAHUAYwBoAG8AdQAuAGoAbABnAC4AbABvAGMAYQBsAAUAEgBqAGwAZwAuAGwAbwBjAGEAbAAHAAgAQH6UJ9691gEAAAAA"
+	scanType3 = "TlRMTVNTUAADAAAAGAAYAHQAAAAiASIBjAAAAAAAAABYAAAADAAMAFgAAAAQABAAZAAAABAAEACuAQAANYKI4goAukcAAAAP1KMCweXeFIr6zmSmiHFWSWoAbABvAHUAaQBzAEMASABPAFUAQwBIAE8AVQAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAC5/Vhnk2GTLD131k8cNfZcAQEAAAAAAADSVClUh73WAX873ENT+QbPAAAAAAIABgBKAEwARwABABAAQwBIAE8AVQBDAEgATwBVAAQAEgBqAGwAZwAuAGwAbwBjAGEAbAADACQAYwBoAG8AdQBjAGgAbwB1AC4AagBsAGcALgBsAG8AYwBhAGwABQASAGoAbABnAC4AbABvAGMAYQBsAAcACADSVClUh73WAQYABAACAAAACAAwADAAAAAAAAAAAQAAAAAgAAC4YcwjyK/gKSgZikWqPXs8y5udtMrVNidXg4R7uFJFPgoAEAAAAAAAAAAAAAAAAAAAAAAACQAcAEgAVABUAFAALwBsAG8AYwBhAGwAaABvAHMAdAAAAAAAAAAAAAG7NbE8iPK1v5zqEu20+5Q="
+)
+
+type scanned struct {
+	offset  int64
+	encoded bool
+	data    []byte
+}
+
+func scanAll(t *testing.T, s *Scanner) []scanned {
+	var result []scanned
+	for s.Next() {
+		var data, err = s.Message().Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, s.Bytes()) {
+			t.Errorf("Message().Bytes() = %x, want %x", data, s.Bytes())
+		}
+		result = append(result, scanned{offset: s.Offset(), encoded: s.Encoded(), data: s.Bytes()})
+	}
+	return result
+}
+
+func TestScanner(t *testing.T) {
+	var type1, _ = base64.StdEncoding.DecodeString(scanType1)
+	var type2, _ = base64.StdEncoding.DecodeString(scanType2)
+	var type3, _ = base64.StdEncoding.DecodeString(scanType3)
+
+	var stream bytes.Buffer
+	stream.WriteString("\x00\x01garbage NTLMSSP")
+	stream.Write(type1)
+	stream.WriteString("\r\nWWW-Authenticate: NTLM ")
+	stream.WriteString(scanType2)
+	stream.WriteString("\r\n\x00\x00")
+	stream.Write(type3)
+	stream.WriteString("NTLMSSP\x00\x03\x00\x00\x00truncated")
+
+	var want = []scanned{
+		{offset: 17, data: type1},
+		{offset: 17 + 40 + 25, encoded: true, data: type2},
+		{offset: 17 + 40 + 25 + int64(len(scanType2)) + 4, data: type3},
+	}
+
+	tests := []struct {
+		name string
+		r    io.Reader
+	}{
+		{name: "whole", r: bytes.NewReader(stream.Bytes())},
+		{name: "one byte at a time", r: iotest.OneByteReader(bytes.NewReader(stream.Bytes()))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var s = NewScanner(tt.r)
+			var got = scanAll(t, s)
+			if s.Err() != nil {
+				t.Fatalf("Err() = %v", s.Err())
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("Scanner got = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestScannerEmbeddedBase64(t *testing.T) {
+	var type1, _ = base64.StdEncoding.DecodeString(scanType1)
+	var type2, _ = base64.StdEncoding.DecodeString(scanType2)
+	var type3, _ = base64.StdEncoding.DecodeString(scanType3)
+
+	tests := []struct {
+		name    string
+		encoded []byte // holds the message at any byte offset
+		message []byte
+	}{
+		{name: "shift 1", encoded: append([]byte{0xa1}, type1...), message: type1},
+		{name: "shift 2", encoded: append([]byte{0xa1, 0x82}, type2...), message: type2},
+		{name: "SPNEGO NEGOTIATE", encoded: mustWrap(t, scanType1), message: type1},
+		{name: "SPNEGO CHALLENGE", encoded: mustWrap(t, scanType2), message: type2},
+		{name: "SPNEGO AUTHENTICATE", encoded: mustWrap(t, scanType3), message: type3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			const prefix = "GET / HTTP/1.1\r\nAuthorization: Negotiate "
+			var stream = prefix + base64.StdEncoding.EncodeToString(tt.encoded) + "\r\n\r\n"
+			// the offset of the quantum holding the signature
+			var want = []scanned{{
+				offset:  int64(len(prefix) + bytes.Index(tt.encoded, signature)/3*4),
+				encoded: true,
+				data:    tt.message,
+			}}
+
+			for _, r := range []io.Reader{strings.NewReader(stream), iotest.OneByteReader(strings.NewReader(stream))} {
+				if got := scanAll(t, NewScanner(r)); !reflect.DeepEqual(got, want) {
+					t.Errorf("Scanner got = %+v, want %+v", got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestScannerLargeStream(t *testing.T) {
+	var type1, _ = base64.StdEncoding.DecodeString(scanType1)
+	var padding = strings.Repeat("x", 3*scanChunkSize+5)
+
+	var s = ParseOptions{MaxMessageSize: 1024}.NewScanner(strings.NewReader(padding + string(type1) + padding + scanType1))
+	var got = scanAll(t, s)
+	var want = []scanned{
+		{offset: int64(len(padding)), data: type1},
+		{offset: int64(2*len(padding) + len(type1)), encoded: true, data: type1},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Scanner got = %+v, want %+v", got, want)
+	}
+}
+
+func TestScannerErr(t *testing.T) {
+	var failure = errors.New("read failure")
+	var s = NewScanner(io.MultiReader(strings.NewReader(scanType1+"\n"), iotest.ErrReader(failure)))
+	if !s.Next() {
+		t.Fatal("Next() = false, want the message read before the error")
+	}
+	if s.Next() {
+		t.Fatal("Next() = true after the read error")
+	}
+	if !errors.Is(s.Err(), failure) {
+		t.Errorf("Err() = %v, want %v", s.Err(), failure)
+	}
+}
@@ -0,0 +1,362 @@
+package ntlm_parser
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+)
+
+// SMB1Command is the Command of an SMB1 header, only NEGOTIATE and
+// SESSION_SETUP_ANDX are decoded past the header.
+//
+// reference: https://learn.microsoft.com/en-us/openspecs/windows_protocols/ms-cifs/69a29f73-de0c-45a6-a1aa-8ceeea42217f
+type SMB1Command uint8
+
+const (
+	SMB_COM_NEGOTIATE          SMB1Command = 0x72
+	SMB_COM_SESSION_SETUP_ANDX SMB1Command = 0x73
+	SMB_COM_TREE_CONNECT_ANDX  SMB1Command = 0x75
+)
+
+func (c SMB1Command) String() string {
+	switch c {
+	case SMB_COM_NEGOTIATE:
+		return "SMB_COM_NEGOTIATE"
+	case SMB_COM_SESSION_SETUP_ANDX:
+		return "SMB_COM_SESSION_SETUP_ANDX"
+	case SMB_COM_TREE_CONNECT_ANDX:
+		return "SMB_COM_TREE_CONNECT_ANDX"
+	}
+	return fmt.Sprintf("0x%02x", uint8(c))
+}
+
+type SMB1Flags2 uint16
+
+const (
+	SMB_FLAGS2_SECURITY_SIGNATURE SMB1Flags2 = 0x0004
+	SMB_FLAGS2_EXTENDED_SECURITY  SMB1Flags2 = 0x0800
+	SMB_FLAGS2_NT_STATUS          SMB1Flags2 = 0x4000
+	SMB_FLAGS2_UNICODE            SMB1Flags2 = 0x8000
+)
+
+type SMB1SecurityMode uint8
+
+const (
+	NEGOTIATE_USER_SECURITY                SMB1SecurityMode = 0x01
+	NEGOTIATE_ENCRYPT_PASSWORDS            SMB1SecurityMode = 0x02
+	NEGOTIATE_SECURITY_SIGNATURES_ENABLED  SMB1SecurityMode = 0x04
+	NEGOTIATE_SECURITY_SIGNATURES_REQUIRED SMB1SecurityMode = 0x08
+)
+
+func (m SMB1SecurityMode) String() string {
+	return bitNames(uint32(m), []bitName{
+		{uint32(NEGOTIATE_USER_SECURITY), "NEGOTIATE_USER_SECURITY"},
+		{uint32(NEGOTIATE_ENCRYPT_PASSWORDS), "NEGOTIATE_ENCRYPT_PASSWORDS"},
+		{uint32(NEGOTIATE_SECURITY_SIGNATURES_ENABLED), "NEGOTIATE_SECURITY_SIGNATURES_ENABLED"},
+		{uint32(NEGOTIATE_SECURITY_SIGNATURES_REQUIRED), "NEGOTIATE_SECURITY_SIGNATURES_REQUIRED"},
+	})
+}
+
+type SMB1Capabilities uint32
+
+const (
+	CAP_UNICODE           SMB1Capabilities = 0x00000004
+	CAP_NT_SMBS           SMB1Capabilities = 0x00000010
+	CAP_STATUS32          SMB1Capabilities = 0x00000040
+	CAP_EXTENDED_SECURITY SMB1Capabilities = 0x80000000
+)
+
+func (c SMB1Capabilities) String() string {
+	return bitNames(uint32(c), []bitName{
+		{uint32(CAP_UNICODE), "CAP_UNICODE"},
+		{uint32(CAP_NT_SMBS), "CAP_NT_SMBS"},
+		{uint32(CAP_STATUS32), "CAP_STATUS32"},
+		{uint32(CAP_EXTENDED_SECURITY), "CAP_EXTENDED_SECURITY"},
+	})
+}
+
+// SMB1Packet is one SMB1 message. The fields past the header are only set
+// for the NEGOTIATE and SESSION_SETUP_ANDX commands of the NT LM 0.12
+// dialect.
+//
+// With extended security the GSS token is in SecurityBlob and decoded in
+// SPNEGO and NTLM like for SMB2. Without it, the NEGOTIATE response carries
+// the server Challenge and the SESSION_SETUP_ANDX request the raw LM and NT
+// responses, which NTLM then holds as an NTLMType3v1 built from them.
+//
+// reference: https://learn.microsoft.com/en-us/openspecs/windows_protocols/ms-smb/3c0848a6-efe9-47c2-b57a-f7e8217150b9
+type SMB1Packet struct {
+	Command  SMB1Command
+	Response bool
+	Status   uint32
+	Flags2   SMB1Flags2
+	UID      uint16
+	MID      uint16
+
+	Dialects     []string // NEGOTIATE request
+	DialectIndex uint16   // NEGOTIATE response
+	SecurityMode SMB1SecurityMode
+	Capabilities SMB1Capabilities
+
+	// Challenge and DomainName of a NEGOTIATE response without extended
+	// security.
+	Challenge  string
+	DomainName string
+
+	// AccountName and PrimaryDomain of a SESSION_SETUP_ANDX request without
+	// extended security.
+	AccountName   string
+	PrimaryDomain string
+
+	Action       uint16 // SESSION_SETUP_ANDX response
+	NativeOS     string
+	NativeLanMan string
+
+	SecurityBlob []byte
+	SPNEGO       *SPNEGOToken
+	NTLM         NTLMMessage
+}
+
+func (p *SMB1Packet) ExtendedSecurity() bool {
+	return p.Flags2&SMB_FLAGS2_EXTENDED_SECURITY != 0
+}
+
+// SigningRequired tells whether the server requires signing, it is only
+// known from a NEGOTIATE response.
+func (p *SMB1Packet) SigningRequired() bool {
+	return p.SecurityMode&NEGOTIATE_SECURITY_SIGNATURES_REQUIRED != 0
+}
+
+var smb1ProtocolID = []byte("\xffSMB")
+
+const smb1HeaderSize = 32
+
+func ParseSMB1(data []byte) ([]*SMB1Packet, error) {
+	return ParseOptions{}.ParseSMB1(data)
+}
+
+// ParseSMB1 decodes SMB1 messages, bare or framed by the NetBIOS session
+// header. NetBIOS frames that don't hold SMB1 are skipped.
+func (o ParseOptions) ParseSMB1(data []byte) ([]*SMB1Packet, error) {
+	if bytes.HasPrefix(data, smb1ProtocolID) {
+		var packet, err = o.parseSMB1(data)
+		if err != nil {
+			return nil, err
+		}
+		return []*SMB1Packet{packet}, nil
+	}
+
+	var result []*SMB1Packet
+	for frames := data; len(frames) > 0; {
+		var frame, rest, err = netBIOSFrame(frames)
+		if err != nil {
+			return nil, err
+		}
+		frames = rest
+		if !bytes.HasPrefix(frame, smb1ProtocolID) {
+			continue
+		}
+		packet, err := o.parseSMB1(frame)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, packet)
+	}
+	return result, nil
+}
+
+// smb1Reader reads the parameter words and data bytes of a message.
+type smb1Reader struct {
+	words   []byte
+	bytes   []byte
+	offset  int // of bytes in message
+	unicode bool
+}
+
+func (o ParseOptions) parseSMB1(data []byte) (*SMB1Packet, error) {
+	if len(data) < smb1HeaderSize+3 {
+		return nil, fmt.Errorf("%w: SMB1 header truncated", ErrBadSMB)
+	}
+	var p = &SMB1Packet{
+		Command:  SMB1Command(data[4]),
+		Status:   binary.LittleEndian.Uint32(data[5:9]),
+		Response: data[9]&0x80 != 0, // SMB_FLAGS_REPLY
+		Flags2:   SMB1Flags2(binary.LittleEndian.Uint16(data[10:12])),
+		UID:      binary.LittleEndian.Uint16(data[28:30]),
+		MID:      binary.LittleEndian.Uint16(data[30:32]),
+	}
+
+	var wordCount = int(data[smb1HeaderSize])
+	var bytesAt = smb1HeaderSize + 1 + 2*wordCount
+	if bytesAt+2 > len(data) {
+		return nil, fmt.Errorf("%w: %s parameters truncated", ErrBadSMB, p.Command)
+	}
+	var byteCount = int(binary.LittleEndian.Uint16(data[bytesAt:]))
+	if bytesAt+2+byteCount > len(data) {
+		return nil, fmt.Errorf("%w: %s data truncated", ErrBadSMB, p.Command)
+	}
+	var r = &smb1Reader{
+		words:   data[smb1HeaderSize+1 : bytesAt],
+		bytes:   data[bytesAt+2 : bytesAt+2+byteCount],
+		offset:  bytesAt + 2,
+		unicode: p.Flags2&SMB_FLAGS2_UNICODE != 0,
+	}
+
+	switch p.Command {
+	case SMB_COM_NEGOTIATE:
+		return p, o.parseSMB1Negotiate(p, r)
+	case SMB_COM_SESSION_SETUP_ANDX:
+		return p, o.parseSMB1SessionSetup(p, r)
+	}
+	return p, nil
+}
+
+func (o ParseOptions) parseSMB1Negotiate(p *SMB1Packet, r *smb1Reader) error {
+	if !p.Response {
+		// a list of 0x02 followed by a null-terminated dialect name
+		for rest := r.bytes; len(rest) > 0 && rest[0] == 0x02; {
+			var end = bytes.IndexByte(rest[1:], 0)
+			if end < 0 {
+				return fmt.Errorf("%w: unterminated dialect", ErrBadSMB)
+			}
+			p.Dialects = append(p.Dialects, string(rest[1:1+end]))
+			rest = rest[2+end:]
+		}
+		return nil
+	}
+
+	// only the 17 words of NT LM 0.12 are decoded
+	if len(r.words) < 2 {
+		return nil
+	}
+	p.DialectIndex = binary.LittleEndian.Uint16(r.words[0:2])
+	if len(r.words) != 34 {
+		return nil
+	}
+	p.SecurityMode = SMB1SecurityMode(r.words[2])
+	p.Capabilities = SMB1Capabilities(binary.LittleEndian.Uint32(r.words[19:23]))
+
+	if p.Capabilities&CAP_EXTENDED_SECURITY != 0 {
+		// ServerGUID, then the security blob
+		if len(r.bytes) < 16 {
+			return fmt.Errorf("%w: NEGOTIATE ServerGUID truncated", ErrBadSMB)
+		}
+		if len(r.bytes) > 16 {
+			p.SecurityBlob = r.bytes[16:]
+			return o.parseSecurityBlob(p.SecurityBlob, &p.SPNEGO, &p.NTLM)
+		}
+		return nil
+	}
+
+	var challengeLength = int(r.words[33])
+	if challengeLength > len(r.bytes) {
+		return fmt.Errorf("%w: NEGOTIATE challenge truncated", ErrBadSMB)
+	}
+	p.Challenge = hex.EncodeToString(r.bytes[:challengeLength])
+	// unlike the other strings, DomainName isn't aligned
+	p.DomainName, _ = r.string(challengeLength, false)
+	return nil
+}
+
+func (o ParseOptions) parseSMB1SessionSetup(p *SMB1Packet, r *smb1Reader) error {
+	var next int
+	switch {
+	case p.Response && len(r.words) == 8: // extended security
+		p.Action = binary.LittleEndian.Uint16(r.words[4:6])
+		var length = int(binary.LittleEndian.Uint16(r.words[6:8]))
+		if length > len(r.bytes) {
+			return fmt.Errorf("%w: SESSION_SETUP_ANDX security blob truncated", ErrBadSMB)
+		}
+		p.SecurityBlob, next = r.bytes[:length], length
+	case p.Response && len(r.words) == 6:
+		p.Action = binary.LittleEndian.Uint16(r.words[4:6])
+	case p.Response:
+		// an error response has no parameters
+		return nil
+	case len(r.words) == 24: // extended security
+		p.Capabilities = SMB1Capabilities(binary.LittleEndian.Uint32(r.words[20:24]))
+		var length = int(binary.LittleEndian.Uint16(r.words[14:16]))
+		if length > len(r.bytes) {
+			return fmt.Errorf("%w: SESSION_SETUP_ANDX security blob truncated", ErrBadSMB)
+		}
+		p.SecurityBlob, next = r.bytes[:length], length
+	case len(r.words) == 26:
+		p.Capabilities = SMB1Capabilities(binary.LittleEndian.Uint32(r.words[22:26]))
+		var lmLength = int(binary.LittleEndian.Uint16(r.words[14:16]))
+		var ntLength = int(binary.LittleEndian.Uint16(r.words[16:18]))
+		if lmLength+ntLength > len(r.bytes) {
+			return fmt.Errorf("%w: SESSION_SETUP_ANDX passwords truncated", ErrBadSMB)
+		}
+		next = lmLength + ntLength
+		p.AccountName, next = r.string(next, true)
+		p.PrimaryDomain, next = r.string(next, true)
+		p.NativeOS, next = r.string(next, true)
+		p.NativeLanMan, _ = r.string(next, true)
+
+		var msg, err = o.rawResponses(p, r.bytes[:lmLength], r.bytes[lmLength:lmLength+ntLength])
+		if err != nil {
+			return err
+		}
+		p.NTLM = msg
+		return nil
+	default:
+		return nil
+	}
+
+	p.NativeOS, next = r.string(next, true)
+	p.NativeLanMan, _ = r.string(next, true)
+	if len(p.SecurityBlob) == 0 {
+		return nil
+	}
+	return o.parseSecurityBlob(p.SecurityBlob, &p.SPNEGO, &p.NTLM)
+}
+
+// rawResponses returns the NTLMType3v1 an AUTHENTICATE message would carry
+// with the LM and NT responses of a session setup without extended
+// security, encoded and parsed back so it is like any parsed message.
+func (o ParseOptions) rawResponses(p *SMB1Packet, lm, nt []byte) (NTLMMessage, error) {
+	var data, err = NTLMType3v1{
+		MessageType:      AUTHENTICATE_MESSAGE,
+		Version:          1,
+		LmResponseData:   LMResponseData{Hex: hex.EncodeToString(lm)},
+		NtlmResponseData: NTLMResponseData{Hex: hex.EncodeToString(nt)},
+		TargetNameData:   p.PrimaryDomain,
+		UserNameData:     p.AccountName,
+	}.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	return o.FromBytes(data)
+}
+
+// string reads the null-terminated string at offset in bytes, aligned
+// Unicode strings are padded to 2 bytes from the start of the message. It
+// returns the offset after the string.
+func (r *smb1Reader) string(offset int, aligned bool) (string, int) {
+	if !r.unicode {
+		if offset >= len(r.bytes) {
+			return "", offset
+		}
+		var end = bytes.IndexByte(r.bytes[offset:], 0)
+		if end < 0 {
+			return string(r.bytes[offset:]), len(r.bytes)
+		}
+		return string(r.bytes[offset : offset+end]), offset + end + 1
+	}
+
+	if aligned && (r.offset+offset)%2 != 0 {
+		offset++
+	}
+	var end = offset
+	for end+1 < len(r.bytes) && (r.bytes[end] != 0 || r.bytes[end+1] != 0) {
+		end += 2
+	}
+	if end+1 >= len(r.bytes) {
+		if offset >= len(r.bytes) {
+			return "", offset
+		}
+		return bytesToUCS2(r.bytes[offset:end]), len(r.bytes)
+	}
+	return bytesToUCS2(r.bytes[offset:end]), end + 2
+}
@@ -0,0 +1,151 @@
+package ntlm_parser
+
+import (
+	"encoding/asn1"
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func smb1Message(command SMB1Command, response bool, status uint32, flags2 SMB1Flags2, words, data []byte) []byte {
+	var header = make([]byte, smb1HeaderSize)
+	copy(header, smb1ProtocolID)
+	header[4] = byte(command)
+	binary.LittleEndian.PutUint32(header[5:], status)
+	if response {
+		header[9] = 0x80
+	}
+	binary.LittleEndian.PutUint16(header[10:], uint16(flags2))
+	binary.LittleEndian.PutUint16(header[28:], 0x0800)
+
+	var result = append(header, byte(len(words)/2))
+	result = append(result, words...)
+	result = binary.LittleEndian.AppendUint16(result, uint16(len(data)))
+	return append(result, data...)
+}
+
+func smb1NegotiateResponse(securityMode SMB1SecurityMode, capabilities SMB1Capabilities, challengeLength int) []byte {
+	var words = make([]byte, 34)
+	binary.LittleEndian.PutUint16(words[0:], 1)
+	words[2] = byte(securityMode)
+	binary.LittleEndian.PutUint32(words[19:], uint32(capabilities))
+	words[33] = byte(challengeLength)
+	return words
+}
+
+// utf16z returns the null-terminated UTF-16 encoding of every string.
+func utf16z(strs ...string) []byte {
+	var result []byte
+	for _, str := range strs {
+		result = append(append(result, stringToUCS2(str)...), 0, 0)
+	}
+	return result
+}
+
+func TestParseSMB1ExtendedSecurity(t *testing.T) {
+	const flags2 = SMB_FLAGS2_UNICODE | SMB_FLAGS2_EXTENDED_SECURITY | SMB_FLAGS2_NT_STATUS
+
+	var hints, _ = (&SPNEGOToken{Init: &NegTokenInit{MechTypes: []asn1.ObjectIdentifier{OIDNTLMSSP}}}).Bytes()
+	var type1, type2 = mustWrap(t, scanType1), mustWrap(t, scanType2)
+
+	var setupRequest = make([]byte, 24)
+	binary.LittleEndian.PutUint16(setupRequest[14:], uint16(len(type1)))
+	binary.LittleEndian.PutUint32(setupRequest[20:], uint32(CAP_UNICODE|CAP_EXTENDED_SECURITY))
+	var setupResponse = make([]byte, 8)
+	binary.LittleEndian.PutUint16(setupResponse[6:], uint16(len(type2)))
+
+	var stream = netBIOS(
+		smb1Message(SMB_COM_NEGOTIATE, false, 0, flags2, nil, []byte("\x02PC NETWORK PROGRAM 1.0\x00\x02NT LM 0.12\x00")),
+		smb1Message(SMB_COM_NEGOTIATE, true, 0, flags2,
+			smb1NegotiateResponse(NEGOTIATE_USER_SECURITY|NEGOTIATE_ENCRYPT_PASSWORDS|NEGOTIATE_SECURITY_SIGNATURES_ENABLED|NEGOTIATE_SECURITY_SIGNATURES_REQUIRED, CAP_UNICODE|CAP_NT_SMBS|CAP_EXTENDED_SECURITY, 0),
+			append(make([]byte, 16), hints...)),
+		// the blob is followed by a pad byte to align NativeOS
+		smb1Message(SMB_COM_SESSION_SETUP_ANDX, false, 0, flags2, setupRequest, append(append(type1, 0), utf16z("Unix", "Samba")...)),
+		smb1Message(SMB_COM_SESSION_SETUP_ANDX, true, 0xc0000016, flags2, setupResponse, type2),
+	)
+
+	var packets, err = ParseSMB1(stream)
+	if err != nil {
+		t.Fatalf("ParseSMB1() error = %v", err)
+	}
+	if len(packets) != 4 {
+		t.Fatalf("ParseSMB1() got %d packets, want 4", len(packets))
+	}
+	if got := packets[0].Dialects; !reflect.DeepEqual(got, []string{"PC NETWORK PROGRAM 1.0", "NT LM 0.12"}) {
+		t.Errorf("Dialects = %q", got)
+	}
+	if got := packets[1]; got.DialectIndex != 1 || !got.SigningRequired() || !got.ExtendedSecurity() || got.SPNEGO == nil || got.NTLM != nil {
+		t.Errorf("NEGOTIATE response = %+v", got)
+	}
+	if got := packets[2]; messageType(got.NTLM) != NEGOTIATE_MESSAGE || got.NativeOS != "Unix" || got.NativeLanMan != "Samba" {
+		t.Errorf("SESSION_SETUP_ANDX request = %+v", got)
+	}
+	if got := packets[3]; messageType(got.NTLM) != CHALLENGE_MESSAGE || got.Status != 0xc0000016 || got.UID != 0x0800 {
+		t.Errorf("SESSION_SETUP_ANDX response = %+v", got)
+	}
+}
+
+func TestParseSMB1RawResponses(t *testing.T) {
+	const flags2 = SMB_FLAGS2_UNICODE | SMB_FLAGS2_NT_STATUS
+
+	var challenge = []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	var negotiate = smb1Message(SMB_COM_NEGOTIATE, true, 0, flags2,
+		smb1NegotiateResponse(NEGOTIATE_USER_SECURITY|NEGOTIATE_ENCRYPT_PASSWORDS, CAP_UNICODE|CAP_NT_SMBS, 8),
+		append(append([]byte(nil), challenge...), utf16z("WORKGROUP", "FILER")...))
+
+	var lm, nt = make([]byte, 24), make([]byte, 24)
+	for i := range lm {
+		lm[i], nt[i] = byte(i), byte(0xa0+i)
+	}
+	var words = make([]byte, 26)
+	binary.LittleEndian.PutUint16(words[14:], 24)
+	binary.LittleEndian.PutUint16(words[16:], 24)
+	binary.LittleEndian.PutUint32(words[22:], uint32(CAP_UNICODE|CAP_NT_SMBS))
+	// the strings start at an odd offset and are padded
+	var data = append(append(append([]byte(nil), lm...), nt...), 0)
+	data = append(data, utf16z("alice", "WORKGROUP", "Windows 2000 2195", "Windows 2000 5.0")...)
+	var setup = smb1Message(SMB_COM_SESSION_SETUP_ANDX, false, 0, flags2, words, data)
+
+	var packets, err = ParseSMB1(netBIOS(negotiate, setup))
+	if err != nil {
+		t.Fatalf("ParseSMB1() error = %v", err)
+	}
+	if got := packets[0]; got.Challenge != "0102030405060708" || got.DomainName != "WORKGROUP" || got.ExtendedSecurity() {
+		t.Errorf("NEGOTIATE response = %+v", got)
+	}
+
+	var got = packets[1]
+	if got.AccountName != "alice" || got.PrimaryDomain != "WORKGROUP" || got.NativeOS != "Windows 2000 2195" || got.NativeLanMan != "Windows 2000 5.0" {
+		t.Errorf("SESSION_SETUP_ANDX request = %+v", got)
+	}
+	var msg, ok = got.NTLM.(*NTLMType3v1)
+	if !ok {
+		t.Fatalf("NTLM = %T, want *NTLMType3v1", got.NTLM)
+	}
+	if msg.UserNameData != "alice" || msg.TargetNameData != "WORKGROUP" ||
+		msg.LmResponseData.Hex != "000102030405060708090a0b0c0d0e0f1011121314151617" ||
+		msg.NtlmResponseData.Hex != "a0a1a2a3a4a5a6a7a8a9aaabacadaeafb0b1b2b3b4b5b6b7" {
+		t.Errorf("NTLM = %+v", msg)
+	}
+}
+
+func TestParseSMB1Errors(t *testing.T) {
+	var words = make([]byte, 24)
+	binary.LittleEndian.PutUint16(words[14:], 100)
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{name: "truncated header", data: smb1ProtocolID},
+		{name: "truncated data", data: smb1Message(SMB_COM_NEGOTIATE, false, 0, 0, nil, []byte("\x02NT LM 0.12\x00"))[:40]},
+		{name: "security blob out of range", data: smb1Message(SMB_COM_SESSION_SETUP_ANDX, false, 0, SMB_FLAGS2_EXTENDED_SECURITY, words, make([]byte, 10))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseSMB1(tt.data); !errors.Is(err, ErrBadSMB) {
+				t.Errorf("ParseSMB1() error = %v, want %v", err, ErrBadSMB)
+			}
+		})
+	}
+}
@@ -0,0 +1,414 @@
+package ntlm_parser
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// SMB2Command is the Command of an SMB2 header, only NEGOTIATE and
+// SESSION_SETUP are decoded past the header.
+//
+// reference: https://learn.microsoft.com/en-us/openspecs/windows_protocols/ms-smb2/fb188936-5050-48d3-b350-dc43059638a4
+type SMB2Command uint16
+
+const (
+	SMB2_NEGOTIATE     SMB2Command = 0x0000
+	SMB2_SESSION_SETUP SMB2Command = 0x0001
+	SMB2_LOGOFF        SMB2Command = 0x0002
+	SMB2_TREE_CONNECT  SMB2Command = 0x0003
+)
+
+func (c SMB2Command) String() string {
+	switch c {
+	case SMB2_NEGOTIATE:
+		return "SMB2_NEGOTIATE"
+	case SMB2_SESSION_SETUP:
+		return "SMB2_SESSION_SETUP"
+	case SMB2_LOGOFF:
+		return "SMB2_LOGOFF"
+	case SMB2_TREE_CONNECT:
+		return "SMB2_TREE_CONNECT"
+	}
+	return fmt.Sprintf("0x%04x", uint16(c))
+}
+
+// SMB2Dialect is a DialectRevision.
+type SMB2Dialect uint16
+
+func (d SMB2Dialect) String() string {
+	switch d {
+	case 0x0202:
+		return "2.0.2"
+	case 0x0210:
+		return "2.1"
+	case 0x0300:
+		return "3.0"
+	case 0x0302:
+		return "3.0.2"
+	case 0x0311:
+		return "3.1.1"
+	case 0x02ff:
+		return "2.???"
+	}
+	return fmt.Sprintf("0x%04x", uint16(d))
+}
+
+type SMB2SecurityMode uint16
+
+const (
+	SMB2_NEGOTIATE_SIGNING_ENABLED  SMB2SecurityMode = 0x0001
+	SMB2_NEGOTIATE_SIGNING_REQUIRED SMB2SecurityMode = 0x0002
+)
+
+func (m SMB2SecurityMode) String() string {
+	return bitNames(uint32(m), []bitName{
+		{uint32(SMB2_NEGOTIATE_SIGNING_ENABLED), "SMB2_NEGOTIATE_SIGNING_ENABLED"},
+		{uint32(SMB2_NEGOTIATE_SIGNING_REQUIRED), "SMB2_NEGOTIATE_SIGNING_REQUIRED"},
+	})
+}
+
+type SMB2Capabilities uint32
+
+const (
+	SMB2_GLOBAL_CAP_DFS                SMB2Capabilities = 0x00000001
+	SMB2_GLOBAL_CAP_LEASING            SMB2Capabilities = 0x00000002
+	SMB2_GLOBAL_CAP_LARGE_MTU          SMB2Capabilities = 0x00000004
+	SMB2_GLOBAL_CAP_MULTI_CHANNEL      SMB2Capabilities = 0x00000008
+	SMB2_GLOBAL_CAP_PERSISTENT_HANDLES SMB2Capabilities = 0x00000010
+	SMB2_GLOBAL_CAP_DIRECTORY_LEASING  SMB2Capabilities = 0x00000020
+	SMB2_GLOBAL_CAP_ENCRYPTION         SMB2Capabilities = 0x00000040
+)
+
+func (c SMB2Capabilities) String() string {
+	return bitNames(uint32(c), []bitName{
+		{uint32(SMB2_GLOBAL_CAP_DFS), "SMB2_GLOBAL_CAP_DFS"},
+		{uint32(SMB2_GLOBAL_CAP_LEASING), "SMB2_GLOBAL_CAP_LEASING"},
+		{uint32(SMB2_GLOBAL_CAP_LARGE_MTU), "SMB2_GLOBAL_CAP_LARGE_MTU"},
+		{uint32(SMB2_GLOBAL_CAP_MULTI_CHANNEL), "SMB2_GLOBAL_CAP_MULTI_CHANNEL"},
+		{uint32(SMB2_GLOBAL_CAP_PERSISTENT_HANDLES), "SMB2_GLOBAL_CAP_PERSISTENT_HANDLES"},
+		{uint32(SMB2_GLOBAL_CAP_DIRECTORY_LEASING), "SMB2_GLOBAL_CAP_DIRECTORY_LEASING"},
+		{uint32(SMB2_GLOBAL_CAP_ENCRYPTION), "SMB2_GLOBAL_CAP_ENCRYPTION"},
+	})
+}
+
+type SMB2SessionFlags uint16
+
+const (
+	SMB2_SESSION_FLAG_IS_GUEST     SMB2SessionFlags = 0x0001
+	SMB2_SESSION_FLAG_IS_NULL      SMB2SessionFlags = 0x0002
+	SMB2_SESSION_FLAG_ENCRYPT_DATA SMB2SessionFlags = 0x0004
+)
+
+func (f SMB2SessionFlags) String() string {
+	return bitNames(uint32(f), []bitName{
+		{uint32(SMB2_SESSION_FLAG_IS_GUEST), "SMB2_SESSION_FLAG_IS_GUEST"},
+		{uint32(SMB2_SESSION_FLAG_IS_NULL), "SMB2_SESSION_FLAG_IS_NULL"},
+		{uint32(SMB2_SESSION_FLAG_ENCRYPT_DATA), "SMB2_SESSION_FLAG_ENCRYPT_DATA"},
+	})
+}
+
+type bitName struct {
+	value uint32
+	label string
+}
+
+// bitNames joins the labels of the bits set in value, the unknown bits are
+// printed in hex.
+func bitNames(value uint32, names []bitName) string {
+	var labels []string
+	for _, name := range names {
+		if value&name.value != 0 {
+			labels = append(labels, name.label)
+			value &^= name.value
+		}
+	}
+	if value != 0 {
+		labels = append(labels, fmt.Sprintf("0x%x", value))
+	}
+	return strings.Join(labels, " ")
+}
+
+// SMB2Packet is one SMB2 message. The fields past the header are only set
+// for the NEGOTIATE and SESSION_SETUP commands.
+//
+// reference: https://learn.microsoft.com/en-us/openspecs/windows_protocols/ms-smb2/5606ad47-5ee0-437a-817e-70c366052962
+type SMB2Packet struct {
+	Command   SMB2Command
+	Response  bool
+	Status    uint32
+	MessageID uint64
+	SessionID uint64
+
+	Dialects []SMB2Dialect // NEGOTIATE request
+	Dialect  SMB2Dialect   // NEGOTIATE response
+
+	SecurityMode      SMB2SecurityMode
+	Capabilities      SMB2Capabilities
+	PreviousSessionID uint64           // SESSION_SETUP request
+	SessionFlags      SMB2SessionFlags // SESSION_SETUP response
+
+	// SecurityBlob is the GSS token, SPNEGO is set when it is a SPNEGO token
+	// and NTLM when it carries an NTLM message.
+	SecurityBlob []byte
+	SPNEGO       *SPNEGOToken
+	NTLM         NTLMMessage
+}
+
+// SigningRequired tells whether the sender requires signing.
+func (p *SMB2Packet) SigningRequired() bool {
+	return p.SecurityMode&SMB2_NEGOTIATE_SIGNING_REQUIRED != 0
+}
+
+var smb2ProtocolID = []byte("\xfeSMB")
+
+const smb2HeaderSize = 64
+
+func ParseSMB2(data []byte) ([]*SMB2Packet, error) {
+	return ParseOptions{}.ParseSMB2(data)
+}
+
+// ParseSMB2 decodes SMB2 messages, compounded or not, bare or framed by the
+// 4-byte NetBIOS session header of direct TCP. NetBIOS frames that don't
+// hold SMB2, such as the SMB1 NEGOTIATE opening a connection, are skipped.
+func (o ParseOptions) ParseSMB2(data []byte) ([]*SMB2Packet, error) {
+	if bytes.HasPrefix(data, smb2ProtocolID) {
+		return o.parseSMB2Chain(data)
+	}
+
+	var result []*SMB2Packet
+	for frames := data; len(frames) > 0; {
+		var frame, rest, err = netBIOSFrame(frames)
+		if err != nil {
+			return nil, err
+		}
+		frames = rest
+		if !bytes.HasPrefix(frame, smb2ProtocolID) {
+			continue
+		}
+		packets, err := o.parseSMB2Chain(frame)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, packets...)
+	}
+	return result, nil
+}
+
+// netBIOSFrame splits the first session message off data, frame is nil for
+// the other NetBIOS packets such as keep-alives.
+//
+// reference: https://learn.microsoft.com/en-us/openspecs/windows_protocols/ms-smb2/1dfacde4-b5c7-4494-8a14-a09d3ab4cc83
+func netBIOSFrame(data []byte) (frame, rest []byte, err error) {
+	if len(data) < 4 {
+		return nil, nil, fmt.Errorf("%w: NetBIOS header truncated", ErrBadSMB)
+	}
+	var length = int(data[1])<<16 | int(data[2])<<8 | int(data[3])
+	if 4+length > len(data) {
+		return nil, nil, fmt.Errorf("%w: NetBIOS frame of %d bytes truncated", ErrBadSMB, length)
+	}
+	if data[0] != 0 {
+		return nil, data[4+length:], nil
+	}
+	return data[4 : 4+length], data[4+length:], nil
+}
+
+// parseSMB2Chain decodes the messages chained by NextCommand.
+func (o ParseOptions) parseSMB2Chain(data []byte) ([]*SMB2Packet, error) {
+	var result []*SMB2Packet
+	for {
+		if len(data) < smb2HeaderSize || !bytes.HasPrefix(data, smb2ProtocolID) {
+			return nil, fmt.Errorf("%w: SMB2 header truncated", ErrBadSMB)
+		}
+		var next = int(binary.LittleEndian.Uint32(data[20:24]))
+		var message = data
+		if next != 0 {
+			if next < smb2HeaderSize || next > len(data) {
+				return nil, fmt.Errorf("%w: NextCommand %d out of range", ErrBadSMB, next)
+			}
+			message = data[:next]
+		}
+
+		var packet, err = o.parseSMB2(message)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, packet)
+		if next == 0 {
+			return result, nil
+		}
+		data = data[next:]
+	}
+}
+
+func (o ParseOptions) parseSMB2(data []byte) (*SMB2Packet, error) {
+	var p = &SMB2Packet{
+		Command:   SMB2Command(binary.LittleEndian.Uint16(data[12:14])),
+		Response:  binary.LittleEndian.Uint32(data[16:20])&0x1 != 0, // SMB2_FLAGS_SERVER_TO_REDIR
+		Status:    binary.LittleEndian.Uint32(data[8:12]),
+		MessageID: binary.LittleEndian.Uint64(data[24:32]),
+		SessionID: binary.LittleEndian.Uint64(data[40:48]),
+	}
+	var body = data[smb2HeaderSize:]
+
+	// the security buffer, its offset counts from the start of the header
+	var offset, length int
+	switch {
+	case p.Command == SMB2_NEGOTIATE && !p.Response:
+		if len(body) < 36 {
+			return nil, fmt.Errorf("%w: NEGOTIATE request truncated", ErrBadSMB)
+		}
+		var count = int(binary.LittleEndian.Uint16(body[2:4]))
+		p.SecurityMode = SMB2SecurityMode(binary.LittleEndian.Uint16(body[4:6]))
+		p.Capabilities = SMB2Capabilities(binary.LittleEndian.Uint32(body[8:12]))
+		if 36+2*count > len(body) {
+			return nil, fmt.Errorf("%w: NEGOTIATE dialects truncated", ErrBadSMB)
+		}
+		for i := 0; i < count; i++ {
+			p.Dialects = append(p.Dialects, SMB2Dialect(binary.LittleEndian.Uint16(body[36+2*i:])))
+		}
+		return p, nil
+	case p.Command == SMB2_NEGOTIATE:
+		if len(body) < 64 {
+			return nil, fmt.Errorf("%w: NEGOTIATE response truncated", ErrBadSMB)
+		}
+		p.SecurityMode = SMB2SecurityMode(binary.LittleEndian.Uint16(body[2:4]))
+		p.Dialect = SMB2Dialect(binary.LittleEndian.Uint16(body[4:6]))
+		p.Capabilities = SMB2Capabilities(binary.LittleEndian.Uint32(body[24:28]))
+		offset = int(binary.LittleEndian.Uint16(body[56:58]))
+		length = int(binary.LittleEndian.Uint16(body[58:60]))
+	case p.Command == SMB2_SESSION_SETUP && !p.Response:
+		if len(body) < 24 {
+			return nil, fmt.Errorf("%w: SESSION_SETUP request truncated", ErrBadSMB)
+		}
+		p.SecurityMode = SMB2SecurityMode(body[3])
+		p.Capabilities = SMB2Capabilities(binary.LittleEndian.Uint32(body[4:8]))
+		offset = int(binary.LittleEndian.Uint16(body[12:14]))
+		length = int(binary.LittleEndian.Uint16(body[14:16]))
+		p.PreviousSessionID = binary.LittleEndian.Uint64(body[16:24])
+	case p.Command == SMB2_SESSION_SETUP:
+		// an error response has the 9-byte ERROR body instead
+		if len(body) < 8 || binary.LittleEndian.Uint16(body[0:2]) != 9 {
+			return p, nil
+		}
+		p.SessionFlags = SMB2SessionFlags(binary.LittleEndian.Uint16(body[2:4]))
+		offset = int(binary.LittleEndian.Uint16(body[4:6]))
+		length = int(binary.LittleEndian.Uint16(body[6:8]))
+	default:
+		return p, nil
+	}
+
+	if length == 0 {
+		return p, nil
+	}
+	if offset < smb2HeaderSize || offset+length > len(data) {
+		return nil, fmt.Errorf("%w: %s security buffer out of range", ErrBadSMB, p.Command)
+	}
+	p.SecurityBlob = data[offset : offset+length]
+	return p, o.parseSecurityBlob(p.SecurityBlob, &p.SPNEGO, &p.NTLM)
+}
+
+// parseSecurityBlob decodes a GSS token, a SPNEGO token that doesn't carry
+// NTLM (e.g. Kerberos, or the mechanism list of a NEGOTIATE response) is not
+// an error and leaves msg nil.
+func (o ParseOptions) parseSecurityBlob(blob []byte, token **SPNEGOToken, msg *NTLMMessage) error {
+	var data = blob
+	if isSPNEGO(blob) {
+		var t, err = ParseSPNEGO(blob)
+		if errors.Is(err, ErrKerberosToken) {
+			return nil // a bare Kerberos token
+		} else if err != nil {
+			return err
+		}
+		*token = t
+		if data, err = t.NTLM(); err != nil {
+			return nil
+		}
+	}
+	if !bytes.HasPrefix(data, signature) {
+		return nil
+	}
+
+	var m, err = o.FromBytes(data)
+	if err != nil {
+		return err
+	}
+	*msg = m
+	return nil
+}
+
+// SMB2Session ties the NTLM messages of a session setup to the SMB2
+// session and the signing and dialect that were negotiated.
+type SMB2Session struct {
+	SessionID          uint64
+	Dialect            SMB2Dialect
+	ServerSecurityMode SMB2SecurityMode
+	ClientSecurityMode SMB2SecurityMode
+	Capabilities       SMB2Capabilities
+
+	Negotiate    NTLMMessage
+	Challenge    NTLMMessage
+	Authenticate NTLMMessage
+
+	// SessionFlags and Status of the last SESSION_SETUP response.
+	SessionFlags SMB2SessionFlags
+	Status       uint32
+}
+
+// SigningRequired tells whether either side requires signing.
+func (s *SMB2Session) SigningRequired() bool {
+	return (s.ServerSecurityMode|s.ClientSecurityMode)&SMB2_NEGOTIATE_SIGNING_REQUIRED != 0
+}
+
+// SMB2Sessions groups the packets of a connection, in order, by session.
+// The first SESSION_SETUP request has no SessionId yet, its NEGOTIATE
+// message goes to the session the server assigns in its response.
+func SMB2Sessions(packets []*SMB2Packet) []*SMB2Session {
+	var result []*SMB2Session
+	var sessions = map[uint64]*SMB2Session{}
+	var negotiate *SMB2Packet
+	var pending *SMB2Packet // SESSION_SETUP request without a SessionId
+	for _, p := range packets {
+		switch {
+		case p.Command == SMB2_NEGOTIATE && p.Response:
+			negotiate = p
+			continue
+		case p.Command != SMB2_SESSION_SETUP:
+			continue
+		case !p.Response && p.SessionID == 0:
+			pending = p
+			continue
+		}
+
+		var s = sessions[p.SessionID]
+		if s == nil {
+			s = &SMB2Session{SessionID: p.SessionID}
+			if negotiate != nil {
+				s.Dialect, s.ServerSecurityMode, s.Capabilities = negotiate.Dialect, negotiate.SecurityMode, negotiate.Capabilities
+			}
+			if pending != nil {
+				s.ClientSecurityMode, s.Negotiate = pending.SecurityMode, pending.NTLM
+				pending = nil
+			}
+			sessions[p.SessionID] = s
+			result = append(result, s)
+		}
+
+		if p.Response {
+			s.SessionFlags, s.Status = p.SessionFlags, p.Status
+		} else {
+			s.ClientSecurityMode = p.SecurityMode
+		}
+		switch messageType(p.NTLM) {
+		case NEGOTIATE_MESSAGE:
+			s.Negotiate = p.NTLM
+		case CHALLENGE_MESSAGE:
+			s.Challenge = p.NTLM
+		case AUTHENTICATE_MESSAGE:
+			s.Authenticate = p.NTLM
+		}
+	}
+	return result
+}
@@ -0,0 +1,192 @@
+package ntlm_parser
+
+import (
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// smb2Message builds an SMB2 message, the security blob goes right after
+// the fixed part of body at the offset it names.
+func smb2Message(command SMB2Command, response bool, status uint32, messageID, sessionID uint64, body []byte) []byte {
+	var header = make([]byte, smb2HeaderSize)
+	copy(header, smb2ProtocolID)
+	binary.LittleEndian.PutUint16(header[4:], smb2HeaderSize)
+	binary.LittleEndian.PutUint32(header[8:], status)
+	binary.LittleEndian.PutUint16(header[12:], uint16(command))
+	if response {
+		header[16] = 1
+	}
+	binary.LittleEndian.PutUint64(header[24:], messageID)
+	binary.LittleEndian.PutUint64(header[40:], sessionID)
+	return append(header, body...)
+}
+
+func netBIOS(messages ...[]byte) []byte {
+	var result []byte
+	for _, m := range messages {
+		result = append(result, 0, byte(len(m)>>16), byte(len(m)>>8), byte(len(m)))
+		result = append(result, m...)
+	}
+	return result
+}
+
+func sessionSetupRequest(securityMode byte, blob []byte) []byte {
+	var body = make([]byte, 24)
+	binary.LittleEndian.PutUint16(body[0:], 25)
+	body[3] = securityMode
+	binary.LittleEndian.PutUint16(body[12:], smb2HeaderSize+24)
+	binary.LittleEndian.PutUint16(body[14:], uint16(len(blob)))
+	return append(body, blob...)
+}
+
+func sessionSetupResponse(flags uint16, blob []byte) []byte {
+	var body = make([]byte, 8)
+	binary.LittleEndian.PutUint16(body[0:], 9)
+	binary.LittleEndian.PutUint16(body[2:], flags)
+	binary.LittleEndian.PutUint16(body[4:], smb2HeaderSize+8)
+	binary.LittleEndian.PutUint16(body[6:], uint16(len(blob)))
+	return append(body, blob...)
+}
+
+func mustWrap(t *testing.T, token string) []byte {
+	var msg, err = FromBase64(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := WrapSPNEGO(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestParseSMB2(t *testing.T) {
+	var negotiateRequest = make([]byte, 36+4)
+	binary.LittleEndian.PutUint16(negotiateRequest[0:], 36)
+	binary.LittleEndian.PutUint16(negotiateRequest[2:], 2)
+	binary.LittleEndian.PutUint16(negotiateRequest[4:], uint16(SMB2_NEGOTIATE_SIGNING_ENABLED))
+	binary.LittleEndian.PutUint32(negotiateRequest[8:], uint32(SMB2_GLOBAL_CAP_DFS|SMB2_GLOBAL_CAP_LEASING))
+	binary.LittleEndian.PutUint16(negotiateRequest[36:], 0x0202)
+	binary.LittleEndian.PutUint16(negotiateRequest[38:], 0x0311)
+
+	var hints, _ = (&SPNEGOToken{Init: &NegTokenInit{
+		MechTypes: []asn1.ObjectIdentifier{OIDNegoEx, OIDNTLMSSP},
+		NegHints:  &NegHints{HintName: "not_defined_in_RFC4178@please_ignore"},
+	}}).Bytes()
+	var negotiateResponse = make([]byte, 64)
+	binary.LittleEndian.PutUint16(negotiateResponse[0:], 65)
+	binary.LittleEndian.PutUint16(negotiateResponse[2:], uint16(SMB2_NEGOTIATE_SIGNING_ENABLED|SMB2_NEGOTIATE_SIGNING_REQUIRED))
+	binary.LittleEndian.PutUint16(negotiateResponse[4:], 0x0311)
+	binary.LittleEndian.PutUint32(negotiateResponse[24:], uint32(SMB2_GLOBAL_CAP_DFS|SMB2_GLOBAL_CAP_ENCRYPTION))
+	binary.LittleEndian.PutUint16(negotiateResponse[56:], smb2HeaderSize+64)
+	binary.LittleEndian.PutUint16(negotiateResponse[58:], uint16(len(hints)))
+	negotiateResponse = append(negotiateResponse, hints...)
+
+	var type3, _ = base64.StdEncoding.DecodeString(scanType3)
+	var stream = netBIOS(
+		[]byte("\xffSMBr"), // the SMB1 NEGOTIATE opening the connection
+		smb2Message(SMB2_NEGOTIATE, false, 0, 0, 0, negotiateRequest),
+		smb2Message(SMB2_NEGOTIATE, true, 0, 0, 0, negotiateResponse),
+		smb2Message(SMB2_SESSION_SETUP, false, 0, 1, 0, sessionSetupRequest(1, mustWrap(t, scanType1))),
+		smb2Message(SMB2_SESSION_SETUP, true, 0xc0000016, 1, 0x1234, sessionSetupResponse(0, mustWrap(t, scanType2))),
+		smb2Message(SMB2_SESSION_SETUP, false, 0, 2, 0x1234, sessionSetupRequest(1, type3)),
+		smb2Message(SMB2_SESSION_SETUP, true, 0, 2, 0x1234, sessionSetupResponse(uint16(SMB2_SESSION_FLAG_IS_GUEST), nil)),
+	)
+
+	var packets, err = ParseSMB2(stream)
+	if err != nil {
+		t.Fatalf("ParseSMB2() error = %v", err)
+	}
+	if len(packets) != 6 {
+		t.Fatalf("ParseSMB2() got %d packets, want 6", len(packets))
+	}
+	if got := packets[0].Dialects; !reflect.DeepEqual(got, []SMB2Dialect{0x0202, 0x0311}) {
+		t.Errorf("Dialects = %v", got)
+	}
+	if got := packets[1]; got.Dialect.String() != "3.1.1" || !got.SigningRequired() || got.SPNEGO == nil || got.NTLM != nil {
+		t.Errorf("NEGOTIATE response = %+v", got)
+	}
+	if got := packets[1].Capabilities.String(); got != "SMB2_GLOBAL_CAP_DFS SMB2_GLOBAL_CAP_ENCRYPTION" {
+		t.Errorf("Capabilities = %q", got)
+	}
+	for i, want := range []NTLMMessageType{"", "", NEGOTIATE_MESSAGE, CHALLENGE_MESSAGE, AUTHENTICATE_MESSAGE, ""} {
+		if got := messageType(packets[i].NTLM); got != want {
+			t.Errorf("packet %d: NTLM = %s, want %s", i, got, want)
+		}
+	}
+
+	var sessions = SMB2Sessions(packets)
+	if len(sessions) != 1 {
+		t.Fatalf("SMB2Sessions() got %d sessions, want 1", len(sessions))
+	}
+	var s = sessions[0]
+	if s.SessionID != 0x1234 || s.Dialect != 0x0311 || !s.SigningRequired() || s.SessionFlags != SMB2_SESSION_FLAG_IS_GUEST || s.Status != 0 {
+		t.Errorf("SMB2Sessions() got = %+v", s)
+	}
+	if messageType(s.Negotiate) != NEGOTIATE_MESSAGE || messageType(s.Challenge) != CHALLENGE_MESSAGE || messageType(s.Authenticate) != AUTHENTICATE_MESSAGE {
+		t.Errorf("SMB2Sessions() messages = %T %T %T", s.Negotiate, s.Challenge, s.Authenticate)
+	}
+}
+
+func TestParseSMB2Compound(t *testing.T) {
+	var first = smb2Message(SMB2_SESSION_SETUP, false, 0, 1, 7, sessionSetupRequest(2, mustWrap(t, scanType1)))
+	for len(first)%8 != 0 {
+		first = append(first, 0)
+	}
+	binary.LittleEndian.PutUint32(first[20:], uint32(len(first)))
+	var data = append(first, smb2Message(SMB2_TREE_CONNECT, false, 0, 2, 7, make([]byte, 8))...)
+
+	var packets, err = ParseSMB2(data)
+	if err != nil {
+		t.Fatalf("ParseSMB2() error = %v", err)
+	}
+	if len(packets) != 2 || packets[1].Command != SMB2_TREE_CONNECT || !packets[0].SigningRequired() {
+		t.Errorf("ParseSMB2() got = %+v", packets)
+	}
+}
+
+func TestParseSMB2Kerberos(t *testing.T) {
+	var bare, _ = base64.StdEncoding.DecodeString("YA8GCSqGSIb3EgECAgEAbgA=")
+	var wrapped, _ = (&SPNEGOToken{Init: &NegTokenInit{
+		MechTypes: []asn1.ObjectIdentifier{OIDMSKerberos5, OIDKerberos5, OIDNTLMSSP},
+		MechToken: bare,
+	}}).Bytes()
+
+	var packets, err = ParseSMB2(netBIOS(
+		smb2Message(SMB2_SESSION_SETUP, false, 0, 1, 0, sessionSetupRequest(1, wrapped)),
+		smb2Message(SMB2_SESSION_SETUP, false, 0, 2, 0, sessionSetupRequest(1, bare)),
+	))
+	if err != nil {
+		t.Fatalf("ParseSMB2() error = %v", err)
+	}
+	if got := packets[0]; got.SPNEGO == nil || got.NTLM != nil {
+		t.Errorf("SPNEGO Kerberos SESSION_SETUP = %+v", got)
+	}
+	if got := packets[1]; got.SPNEGO != nil || got.NTLM != nil || len(got.SecurityBlob) != len(bare) {
+		t.Errorf("bare Kerberos SESSION_SETUP = %+v", got)
+	}
+}
+
+func TestParseSMB2Errors(t *testing.T) {
+	var setup = smb2Message(SMB2_SESSION_SETUP, false, 0, 1, 0, sessionSetupRequest(1, mustWrap(t, scanType1)))
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{name: "truncated header", data: setup[:40]},
+		{name: "truncated frame", data: netBIOS(setup)[:100]},
+		{name: "security buffer out of range", data: setup[:len(setup)-1]},
+		{name: "NextCommand out of range", data: append(append([]byte(nil), setup[:20]...), append([]byte{0xff, 0, 0, 0}, setup[24:]...)...)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseSMB2(tt.data); !errors.Is(err, ErrBadSMB) {
+				t.Errorf("ParseSMB2() error = %v, want %v", err, ErrBadSMB)
+			}
+		})
+	}
+}
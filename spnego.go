@@ -0,0 +1,385 @@
+package ntlm_parser
+
+import (
+	"bytes"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+)
+
+// Mechanism OIDs found in SPNEGO tokens.
+var (
+	OIDSPNEGO          = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 2}
+	OIDNTLMSSP         = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 2, 2, 10}
+	OIDKerberos5       = asn1.ObjectIdentifier{1, 2, 840, 113554, 1, 2, 2}
+	OIDMSKerberos5     = asn1.ObjectIdentifier{1, 2, 840, 48018, 1, 2, 2}
+	OIDKerberosU2U     = asn1.ObjectIdentifier{1, 2, 840, 113554, 1, 2, 2, 3}
+	OIDNegoEx          = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 2, 2, 30}
+	kerberosMechanisms = []asn1.ObjectIdentifier{OIDKerberos5, OIDMSKerberos5, OIDKerberosU2U}
+)
+
+// NegState is the negState of a NegTokenResp.
+type NegState int
+
+const (
+	NegStateAcceptCompleted  NegState = 0
+	NegStateAcceptIncomplete NegState = 1
+	NegStateReject           NegState = 2
+	NegStateRequestMIC       NegState = 3
+)
+
+func (s NegState) String() string {
+	switch s {
+	case NegStateAcceptCompleted:
+		return "accept-completed"
+	case NegStateAcceptIncomplete:
+		return "accept-incomplete"
+	case NegStateReject:
+		return "reject"
+	case NegStateRequestMIC:
+		return "request-mic"
+	}
+	return fmt.Sprintf("NegState(%d)", int(s))
+}
+
+// NegTokenInit is the first token of the client, or the NegTokenInit2 an
+// SMB server sends in its NEGOTIATE response.
+//
+// reference: https://www.rfc-editor.org/rfc/rfc4178#section-4.2.1
+// https://learn.microsoft.com/en-us/openspecs/windows_protocols/ms-spng/8e71cf53-e867-4b79-b5b5-38c92be3d472
+type NegTokenInit struct {
+	MechTypes   []asn1.ObjectIdentifier
+	ReqFlags    asn1.BitString
+	MechToken   []byte
+	NegHints    *NegHints // NegTokenInit2 only
+	MechListMIC []byte
+}
+
+type NegHints struct {
+	HintName    string
+	HintAddress []byte
+}
+
+// NegTokenResp is every token after the first one.
+//
+// reference: https://www.rfc-editor.org/rfc/rfc4178#section-4.2.2
+type NegTokenResp struct {
+	NegState      *NegState // nil when absent
+	SupportedMech asn1.ObjectIdentifier
+	ResponseToken []byte
+	MechListMIC   []byte
+}
+
+// SPNEGOToken is a NegotiationToken, exactly one of Init and Resp is set.
+type SPNEGOToken struct {
+	Init *NegTokenInit
+	Resp *NegTokenResp
+}
+
+type negTokenInitASN1 struct {
+	MechTypes []asn1.ObjectIdentifier `asn1:"explicit,tag:0"`
+	ReqFlags  asn1.BitString          `asn1:"explicit,optional,tag:1"`
+	MechToken []byte                  `asn1:"explicit,optional,tag:2"`
+
+	// mechListMIC in RFC 4178, negHints in NegTokenInit2, the whole [3]
+	Field3      asn1.RawValue `asn1:"optional,tag:3"`
+	MechListMIC []byte        `asn1:"explicit,optional,tag:4"`
+}
+
+type negHintsASN1 struct {
+	HintName    asn1.RawValue `asn1:"optional,tag:0"` // the whole [0]
+	HintAddress []byte        `asn1:"explicit,optional,tag:1"`
+}
+
+type negTokenRespASN1 struct {
+	// the whole [0], encoding/asn1 would leave out accept-completed (0)
+	NegState      asn1.RawValue         `asn1:"optional,tag:0"`
+	SupportedMech asn1.ObjectIdentifier `asn1:"explicit,optional,tag:1"`
+	ResponseToken []byte                `asn1:"explicit,optional,tag:2"`
+	MechListMIC   []byte                `asn1:"explicit,optional,tag:3"`
+}
+
+// isSPNEGO tells whether data starts like a GSS-API InitialContextToken or
+// a NegTokenResp.
+func isSPNEGO(data []byte) bool {
+	return len(data) > 0 && (data[0] == 0x60 || data[0] == 0xa1)
+}
+
+// ParseSPNEGO decodes an InitialContextToken holding a NegTokenInit, or a
+// bare NegTokenInit or NegTokenResp. A Kerberos token given instead fails
+// with ErrKerberosToken.
+func ParseSPNEGO(data []byte) (*SPNEGOToken, error) {
+	var raw asn1.RawValue
+	if _, err := asn1.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("spnego: %w", err)
+	}
+
+	if raw.Class == asn1.ClassApplication && raw.Tag == 0 {
+		var mech asn1.ObjectIdentifier
+		var rest, err = asn1.Unmarshal(raw.Bytes, &mech)
+		if err != nil {
+			return nil, fmt.Errorf("spnego: thisMech: %w", err)
+		}
+		if isKerberos(mech) {
+			return nil, fmt.Errorf("%w: mech %s", ErrKerberosToken, mech)
+		}
+		if !mech.Equal(OIDSPNEGO) {
+			return nil, fmt.Errorf("spnego: GSS-API token of mech %s", mech)
+		}
+		if _, err := asn1.Unmarshal(rest, &raw); err != nil {
+			return nil, fmt.Errorf("spnego: innerContextToken: %w", err)
+		}
+	}
+
+	if raw.Class != asn1.ClassContextSpecific || !raw.IsCompound {
+		return nil, fmt.Errorf("spnego: unexpected tag %d class %d", raw.Tag, raw.Class)
+	}
+	switch raw.Tag {
+	case 0:
+		var init, err = parseNegTokenInit(raw.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return &SPNEGOToken{Init: init}, nil
+	case 1:
+		var resp, err = parseNegTokenResp(raw.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return &SPNEGOToken{Resp: resp}, nil
+	}
+	return nil, fmt.Errorf("spnego: unknown NegotiationToken choice %d", raw.Tag)
+}
+
+func parseNegTokenInit(data []byte) (*NegTokenInit, error) {
+	var v negTokenInitASN1
+	if _, err := asn1.Unmarshal(data, &v); err != nil {
+		return nil, fmt.Errorf("spnego: NegTokenInit: %w", err)
+	}
+
+	var result = &NegTokenInit{
+		MechTypes:   v.MechTypes,
+		ReqFlags:    v.ReqFlags,
+		MechToken:   v.MechToken,
+		MechListMIC: v.MechListMIC,
+	}
+	if v.Field3.FullBytes != nil {
+		var field asn1.RawValue
+		if _, err := asn1.Unmarshal(v.Field3.Bytes, &field); err != nil {
+			return nil, fmt.Errorf("spnego: NegTokenInit: %w", err)
+		}
+		switch {
+		case field.Class == asn1.ClassUniversal && field.Tag == asn1.TagOctetString:
+			result.MechListMIC = field.Bytes
+		case field.Class == asn1.ClassUniversal && field.Tag == asn1.TagSequence:
+			var hints negHintsASN1
+			if _, err := asn1.Unmarshal(field.FullBytes, &hints); err != nil {
+				return nil, fmt.Errorf("spnego: negHints: %w", err)
+			}
+			result.NegHints = &NegHints{HintAddress: hints.HintAddress}
+			if hints.HintName.FullBytes != nil {
+				var name asn1.RawValue
+				if _, err := asn1.Unmarshal(hints.HintName.Bytes, &name); err != nil {
+					return nil, fmt.Errorf("spnego: hintName: %w", err)
+				}
+				result.NegHints.HintName = string(name.Bytes)
+			}
+		default:
+			return nil, fmt.Errorf("spnego: NegTokenInit: unexpected tag %d in field 3", field.Tag)
+		}
+	}
+	return result, nil
+}
+
+func parseNegTokenResp(data []byte) (*NegTokenResp, error) {
+	var v negTokenRespASN1
+	if _, err := asn1.Unmarshal(data, &v); err != nil {
+		return nil, fmt.Errorf("spnego: NegTokenResp: %w", err)
+	}
+
+	var result = &NegTokenResp{
+		SupportedMech: v.SupportedMech,
+		ResponseToken: v.ResponseToken,
+		MechListMIC:   v.MechListMIC,
+	}
+	if v.NegState.FullBytes != nil {
+		var state asn1.Enumerated
+		if _, err := asn1.Unmarshal(v.NegState.Bytes, &state); err != nil {
+			return nil, fmt.Errorf("spnego: negState: %w", err)
+		}
+		var negState = NegState(state)
+		result.NegState = &negState
+	}
+	return result, nil
+}
+
+func isKerberos(mech asn1.ObjectIdentifier) bool {
+	for _, oid := range kerberosMechanisms {
+		if mech.Equal(oid) {
+			return true
+		}
+	}
+	return false
+}
+
+// Mech returns the mechanism of the token: the first (optimistic) one of a
+// NegTokenInit or the supportedMech of a NegTokenResp, nil when unknown.
+func (t *SPNEGOToken) Mech() asn1.ObjectIdentifier {
+	if t.Init != nil {
+		if len(t.Init.MechTypes) == 0 {
+			return nil
+		}
+		return t.Init.MechTypes[0]
+	}
+	return t.Resp.SupportedMech
+}
+
+// Token returns the mechToken of a NegTokenInit or the responseToken of a
+// NegTokenResp.
+func (t *SPNEGOToken) Token() []byte {
+	if t.Init != nil {
+		return t.Init.MechToken
+	}
+	return t.Resp.ResponseToken
+}
+
+// NTLM returns the NTLM message carried by the token. It fails with
+// ErrKerberosToken when the mechanism is Kerberos and with ErrNoNTLMToken
+// when there is no NTLM message, e.g. in the final accept-completed.
+func (t *SPNEGOToken) NTLM() ([]byte, error) {
+	var mech, token = t.Mech(), t.Token()
+	switch {
+	case bytes.HasPrefix(token, signature):
+		return token, nil
+	case isKerberos(mech):
+		return nil, fmt.Errorf("%w: mech %s", ErrKerberosToken, mech)
+	case len(token) == 0:
+		return nil, ErrNoNTLMToken
+	case mech != nil && !mech.Equal(OIDNTLMSSP):
+		return nil, fmt.Errorf("%w: mech %s", ErrNoNTLMToken, mech)
+	}
+	return nil, fmt.Errorf("%w: mechToken without the NTLMSSP signature", ErrNoNTLMToken)
+}
+
+// fromNegotiateToken parses the NTLM message of a Negotiate token, either
+// the bare message or a SPNEGO token carrying it. The message keeps the
+// NTLM bytes only, so its Bytes don't give the token back.
+func (o ParseOptions) fromNegotiateToken(data []byte) (NTLMMessage, error) {
+	if isSPNEGO(data) {
+		var token, err = ParseSPNEGO(data)
+		if err == nil {
+			data, err = token.NTLM()
+		}
+		if err != nil {
+			return nil, &ParseError{Field: "SPNEGO", Offset: 0, Err: err}
+		}
+	}
+	return o.FromBytes(data)
+}
+
+// Bytes encodes a NegTokenInit in an InitialContextToken, as sent by
+// clients, and a NegTokenResp as is.
+func (t *SPNEGOToken) Bytes() ([]byte, error) {
+	switch {
+	case t.Init != nil:
+		var init, err = t.Init.marshal()
+		if err != nil {
+			return nil, err
+		}
+		thisMech, err := asn1.Marshal(OIDSPNEGO)
+		if err != nil {
+			return nil, err
+		}
+		inner, err := asn1.Marshal(contextTag(0, init))
+		if err != nil {
+			return nil, err
+		}
+		return asn1.Marshal(asn1.RawValue{Class: asn1.ClassApplication, Tag: 0, IsCompound: true, Bytes: append(thisMech, inner...)})
+	case t.Resp != nil:
+		var resp, err = t.Resp.marshal()
+		if err != nil {
+			return nil, err
+		}
+		return asn1.Marshal(contextTag(1, resp))
+	}
+	return nil, errors.New("spnego: empty token")
+}
+
+func (t *NegTokenInit) marshal() ([]byte, error) {
+	var v = negTokenInitASN1{
+		MechTypes: t.MechTypes,
+		ReqFlags:  t.ReqFlags,
+		MechToken: t.MechToken,
+	}
+	if t.NegHints != nil {
+		var hints = negHintsASN1{HintAddress: t.NegHints.HintAddress}
+		if t.NegHints.HintName != "" {
+			var name, err = asn1.Marshal(asn1.RawValue{Tag: asn1.TagGeneralString, Bytes: []byte(t.NegHints.HintName)})
+			if err != nil {
+				return nil, err
+			}
+			hints.HintName = contextTag(0, name)
+		}
+		var data, err = asn1.Marshal(hints)
+		if err != nil {
+			return nil, err
+		}
+		v.Field3 = contextTag(3, data)
+		v.MechListMIC = t.MechListMIC
+	} else if t.MechListMIC != nil {
+		var data, err = asn1.Marshal(t.MechListMIC)
+		if err != nil {
+			return nil, err
+		}
+		v.Field3 = contextTag(3, data)
+	}
+	return asn1.Marshal(v)
+}
+
+func (t *NegTokenResp) marshal() ([]byte, error) {
+	var v = negTokenRespASN1{
+		SupportedMech: t.SupportedMech,
+		ResponseToken: t.ResponseToken,
+		MechListMIC:   t.MechListMIC,
+	}
+	if t.NegState != nil {
+		var data, err = asn1.Marshal(asn1.Enumerated(*t.NegState))
+		if err != nil {
+			return nil, err
+		}
+		v.NegState = contextTag(0, data)
+	}
+	return asn1.Marshal(v)
+}
+
+// contextTag wraps an encoded value in an explicit context-specific tag.
+func contextTag(tag int, data []byte) asn1.RawValue {
+	return asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: tag, IsCompound: true, Bytes: data}
+}
+
+// WrapSPNEGO encodes msg in the SPNEGO token it travels in: a NEGOTIATE
+// message in a NegTokenInit offering NTLMSSP only, a CHALLENGE message in
+// an accept-incomplete NegTokenResp and an AUTHENTICATE message in a
+// NegTokenResp without negState.
+func WrapSPNEGO(msg NTLMMessage) ([]byte, error) {
+	var data, err = msg.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	messageType, err := getMessageType(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var token SPNEGOToken
+	switch messageType {
+	case NEGOTIATE_MESSAGE:
+		token.Init = &NegTokenInit{MechTypes: []asn1.ObjectIdentifier{OIDNTLMSSP}, MechToken: data}
+	case CHALLENGE_MESSAGE:
+		var state = NegStateAcceptIncomplete
+		token.Resp = &NegTokenResp{NegState: &state, SupportedMech: OIDNTLMSSP, ResponseToken: data}
+	default:
+		token.Resp = &NegTokenResp{ResponseToken: data}
+	}
+	return token.Bytes()
+}
@@ -0,0 +1,167 @@
+package ntlm_parser
+
+import (
+	"bytes"
+	"encoding/asn1"
+	"encoding/base64"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParseSPNEGO(t *testing.T) {
+	var challenge, _ = base64.StdEncoding.DecodeString("TlRMTVNTUAACAAAABgAGADgAAAA1goniaaCGDXCRRNUAAAAAAAAAAIIAggA+AAAACgC6RwAAAA9KAEwARwACAAYASgBMAEcAAQAQAEMASABPAFUAQwBIAE8AVQAEABIAagBsAGcALgBsAG8AYwBhAGwAAwAkAGMAaABvAHUAYwBoAG8AdQAuAGoAbABnAC4AbABvAGMAYQBsAAUAEgBqAGwAZwAuAGwAbwBjAGEAbAAHAAgAQH6UJ9691gEAAAAA")
+	var incomplete = NegStateAcceptIncomplete
+
+	tests := []struct {
+		name  string
+		token string
+		want  *SPNEGOToken
+	}{
+		{
+			name:  "NegTokenResp",
+			token: "oYHcMIHZoAMKAQGhDAYKKwYBBAGCNwICCqKBwwSBwE5UTE1TU1AAAgAAAAYABgA4AAAANYKJ4mmghg1wkUTVAAAAAAAAAACCAIIAPgAAAAoAukcAAAAPSgBMAEcAAgAGAEoATABHAAEAEABDAEgATwBVAEMASABPAFUABAASAGoAbABnAC4AbABvAGMAYQBsAAMAJABjAGgAbwB1AGMAaABvAHUALgBqAGwAZwAuAGwAbwBjAGEAbAAFABIAagBsAGcALgBsAG8AYwBhAGwABwAIAEB+lCfevdYBAAAAAA==",
+			want: &SPNEGOToken{Resp: &NegTokenResp{
+				NegState:      &incomplete,
+				SupportedMech: OIDNTLMSSP,
+				ResponseToken: challenge,
+			}},
+		},
+		{
+			name:  "NegTokenInit2",
+			token: "YDsGBisGAQUFAqAxMC+gGjAYBgorBgEEAYI3AgIeBgorBgEEAYI3AgIKoxEwD6ANGwtub3RfZGVmaW5lZA==",
+			want: &SPNEGOToken{Init: &NegTokenInit{
+				MechTypes: []asn1.ObjectIdentifier{OIDNegoEx, OIDNTLMSSP},
+				NegHints:  &NegHints{HintName: "not_defined"},
+			}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var data, _ = base64.StdEncoding.DecodeString(tt.token)
+			got, err := ParseSPNEGO(data)
+			if err != nil {
+				t.Fatalf("ParseSPNEGO() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseSPNEGO() got = %+v, want %+v", got, tt.want)
+			}
+
+			encoded, err := got.Bytes()
+			if err != nil {
+				t.Fatalf("Bytes() error = %v", err)
+			}
+			if !bytes.Equal(encoded, data) {
+				t.Errorf("Bytes() got = %x, want %x", encoded, data)
+			}
+		})
+	}
+}
+
+func TestSPNEGONTLM(t *testing.T) {
+	var completed = NegStateAcceptCompleted
+	var ntlm = []byte("NTLMSSP\x00\x01\x00\x00\x00")
+
+	tests := []struct {
+		name    string
+		token   SPNEGOToken
+		wantErr error
+	}{
+		{
+			name:  "NTLM",
+			token: SPNEGOToken{Init: &NegTokenInit{MechTypes: []asn1.ObjectIdentifier{OIDNTLMSSP}, MechToken: ntlm}},
+		},
+		{
+			name:  "NTLM second in the list",
+			token: SPNEGOToken{Init: &NegTokenInit{MechTypes: []asn1.ObjectIdentifier{OIDMSKerberos5, OIDNTLMSSP}, MechToken: ntlm}},
+		},
+		{
+			name:    "Kerberos",
+			token:   SPNEGOToken{Init: &NegTokenInit{MechTypes: []asn1.ObjectIdentifier{OIDMSKerberos5, OIDNTLMSSP}, MechToken: []byte{0x60, 0x00}}},
+			wantErr: ErrKerberosToken,
+		},
+		{
+			name:    "Kerberos response",
+			token:   SPNEGOToken{Resp: &NegTokenResp{SupportedMech: OIDKerberos5, ResponseToken: []byte{0x6f, 0x00}}},
+			wantErr: ErrKerberosToken,
+		},
+		{
+			name:    "accept-completed",
+			token:   SPNEGOToken{Resp: &NegTokenResp{NegState: &completed, MechListMIC: make([]byte, 16)}},
+			wantErr: ErrNoNTLMToken,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var data, err = tt.token.Bytes()
+			if err != nil {
+				t.Fatalf("Bytes() error = %v", err)
+			}
+			parsed, err := ParseSPNEGO(data)
+			if err != nil {
+				t.Fatalf("ParseSPNEGO() error = %v", err)
+			}
+			if !reflect.DeepEqual(*parsed, tt.token) {
+				t.Errorf("ParseSPNEGO() got = %+v, want %+v", parsed, tt.token)
+			}
+
+			got, err := parsed.NTLM()
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("NTLM() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && !bytes.Equal(got, ntlm) {
+				t.Errorf("NTLM() got = %x, want %x", got, ntlm)
+			}
+		})
+	}
+}
+
+func TestWrapSPNEGO(t *testing.T) {
+	tests := []struct {
+		name  string
+		token string
+		first byte
+	}{
+		{
+			name:  "NEGOTIATE",
+			token: "TlRMTVNTUAABAAAAB4IIogAAAAAAAAAAAAAAAAAAAAAKALpHAAAADw==",
+			first: 0x60,
+		},
+		{
+			name:  "CHALLENGE",
+			token: "TlRMTVNTUAACAAAABgAGADgAAAA1goniaaCGDXCRRNUAAAAAAAAAAIIAggA+AAAACgC6RwAAAA9KAEwARwACAAYASgBMAEcAAQAQAEMASABPAFUAQwBIAE8AVQAEABIAagBsAGcALgBsAG8AYwBhAGwAAwAkAGMAaABvAHUAYwBoAG8AdQAuAGoAbABnAC4AbABvAGMAYQBsAAUAEgBqAGwAZwAuAGwAbwBjAGEAbAAHAAgAQH6UJ9691gEAAAAA",
+			first: 0xa1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var msg, err = FromBase64(tt.token)
+			if err != nil {
+				t.Fatal(err)
+			}
+			wrapped, err := WrapSPNEGO(msg)
+			if err != nil {
+				t.Fatalf("WrapSPNEGO() error = %v", err)
+			}
+			if wrapped[0] != tt.first {
+				t.Errorf("WrapSPNEGO() starts with %#x, want %#x", wrapped[0], tt.first)
+			}
+
+			token, err := ParseSPNEGO(wrapped)
+			if err != nil {
+				t.Fatalf("ParseSPNEGO() error = %v", err)
+			}
+			data, err := token.NTLM()
+			if err != nil {
+				t.Fatalf("NTLM() error = %v", err)
+			}
+			got, err := FromBytes(data)
+			if err != nil {
+				t.Fatalf("FromBytes() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, msg) {
+				t.Errorf("FromBytes() got = %+v, want %+v", got, msg)
+			}
+		})
+	}
+}
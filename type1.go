@@ -1,53 +1,72 @@
 package ntlm_parser
 
-import (
-	"encoding/binary"
-)
-
 type NTLMType1 struct {
 	SuppliedDomain      SecurityBuffer
 	SuppliedWorkstation SecurityBuffer
 
 	MessageType             NTLMMessageType
-	Flags                   string
+	Flags                   NegotiateFlags
 	OsVersionStructure      OSVersionStructure
 	SuppliedDomainData      string
 	SuppliedWorkstationData string
+
+	SuppliedDomainRaw      []byte
+	SuppliedWorkstationRaw []byte
+
+	// CodePage decodes and encodes the OEM strings, DefaultCodePage when
+	// zero. The parser sets it to ParseOptions.CodePage.
+	CodePage CodePage
+
+	// Warnings is only filled in with ParseOptions.Lenient.
+	Warnings []*ParseError
+
+	original []byte
 }
 
 func (N NTLMType1) Parse(buffer []byte) (NTLMMessage, error) {
-	var flag = binary.LittleEndian.Uint32(buffer[12:16])
+	return N.parse(buffer, ParseOptions{})
+}
+
+func (N NTLMType1) parse(buffer []byte, opts ParseOptions) (NTLMMessage, error) {
+	var r = opts.newReader(buffer, NEGOTIATE_MESSAGE)
+	var flag = NegotiateFlags(r.uint32("NegotiateFlags", 12))
 	result := &NTLMType1{
 		MessageType: NEGOTIATE_MESSAGE,
-		Flags:       getFlags(flag),
+		Flags:       flag,
+		CodePage:    opts.CodePage,
+		original:    append([]byte(nil), buffer...),
 	}
 
 	if len(buffer) == 16 {
 		// NTLM version 1.
-		return result, nil
+		result.Warnings = r.warnings
+		return result, r.err
 	}
 
-	result.SuppliedDomain = getSecBuf(buffer, 16)
-	result.SuppliedWorkstation = getSecBuf(buffer, 24)
+	result.SuppliedDomain = r.secBuf("DomainNameFields", 16)
+	result.SuppliedWorkstation = r.secBuf("WorkstationFields", 24)
 
 	if result.SuppliedDomain.Offset != 32 {
 		// NTLM version 3: OS Version structure.
-		result.OsVersionStructure = getOSVersionStructure(buffer, 32)
+		result.OsVersionStructure = r.version("Version", 32)
 	}
 
-	result.SuppliedDomainData = getSecBufData(
-		buffer,
-		result.SuppliedDomain,
+	// the supplied domain and workstation are always OEM encoded
+	result.SuppliedDomainData, result.SuppliedDomainRaw = getSecBufDataWithFlag(
+		r.payload("DomainName", result.SuppliedDomain),
+		0,
+		opts.codePage(),
 	)
 
-	result.SuppliedWorkstationData = getSecBufData(
-		buffer,
-		result.SuppliedWorkstation,
+	result.SuppliedWorkstationData, result.SuppliedWorkstationRaw = getSecBufDataWithFlag(
+		r.payload("WorkstationName", result.SuppliedWorkstation),
+		0,
+		opts.codePage(),
 	)
 
+	if r.err != nil {
+		return nil, r.err
+	}
+	result.Warnings = r.warnings
 	return result, nil
 }
-
-func getSecBufData(buf []byte, secBuf SecurityBuffer) string {
-	return string(buf[secBuf.Offset : secBuf.Offset+secBuf.Length])
-}
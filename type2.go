@@ -4,7 +4,7 @@ import (
 	"bytes"
 	"encoding/binary"
 	"encoding/hex"
-	"math"
+	"time"
 	"unicode/utf16"
 )
 
@@ -15,6 +15,7 @@ type TargetInfo struct {
 	Type    int
 	Length  int
 	Content string
+	Value   AvPair
 }
 
 type TargetInfoWrapper struct {
@@ -38,39 +39,64 @@ type NTLMType2 struct {
 	TargetInfoSecBuf SecurityBuffer
 
 	MessageType        NTLMMessageType
-	Flags              string
+	Flags              NegotiateFlags
 	Challenge          string
 	Context            string
 	OsVersionStructure OSVersionStructure
 	TargetNameData     string
-	TargetInfoData     []TargetInfo
+	TargetInfoData     AvPairList
+
+	TargetNameRaw []byte
+
+	// CodePage decodes and encodes the OEM strings, DefaultCodePage when
+	// zero. The parser sets it to ParseOptions.CodePage.
+	CodePage CodePage
+
+	// Warnings is only filled in with ParseOptions.Lenient.
+	Warnings []*ParseError
+
+	original []byte
 }
 
 func (N NTLMType2) Parse(buffer []byte) (NTLMMessage, error) {
-	var targetNameSecBuf = getSecBuf(buffer, 12)
-	var flag = binary.LittleEndian.Uint32(buffer[20:24])
-	targetNameData := getSecBufDataWithFlag(buffer, targetNameSecBuf, flag)
+	return N.parse(buffer, ParseOptions{})
+}
+
+func (N NTLMType2) parse(buffer []byte, opts ParseOptions) (NTLMMessage, error) {
+	var r = opts.newReader(buffer, CHALLENGE_MESSAGE)
+	var targetNameSecBuf = r.secBuf("TargetNameFields", 12)
+	var flag = NegotiateFlags(r.uint32("NegotiateFlags", 20))
+	targetNameData, targetNameRaw := getSecBufDataWithFlag(r.payload("TargetName", targetNameSecBuf), flag, opts.codePage())
 	var result = &NTLMType2{
 		MessageType:      CHALLENGE_MESSAGE,
 		TargetNameSecBuf: targetNameSecBuf,
-		Flags:            getFlags(flag),
-		Challenge:        hex.EncodeToString(buffer[24:32]),
+		Flags:            flag,
+		Challenge:        hex.EncodeToString(r.bytes("ServerChallenge", 24, 8)),
 		TargetNameData:   targetNameData,
+		TargetNameRaw:    targetNameRaw,
+		CodePage:         opts.CodePage,
+		original:         append([]byte(nil), buffer...),
 	}
 
 	if targetNameSecBuf.Offset != 32 {
 		// NTLM v2
-		result.Context = hex.EncodeToString(buffer[32:40])
-		result.TargetInfoSecBuf = getSecBuf(buffer, 40)
+		result.Context = hex.EncodeToString(r.bytes("Reserved", 32, 8))
+		result.TargetInfoSecBuf = r.secBuf("TargetInfoFields", 40)
 
-		result.TargetInfoData = getTargetInfo(buffer, result.TargetInfoSecBuf)
+		if targetInfo := r.payload("TargetInfo", result.TargetInfoSecBuf); targetInfo != nil {
+			result.TargetInfoData = r.avPairs("TargetInfo", result.TargetInfoSecBuf.Offset, len(targetInfo))
+		}
 	}
 
-	if targetNameSecBuf.Offset != 48 {
+	if targetNameSecBuf.Offset != 32 && targetNameSecBuf.Offset != 48 {
 		// NTLM version 3: OS Version structure
-		result.OsVersionStructure = getOSVersionStructure(buffer, 48)
+		result.OsVersionStructure = r.version("Version", 48)
 	}
 
+	if r.err != nil {
+		return nil, r.err
+	}
+	result.Warnings = r.warnings
 	return result, nil
 }
 
@@ -102,6 +128,43 @@ func (N NTLMType2) TargetInfoWrapper() TargetInfoWrapper {
 	return result
 }
 
+func (N NTLMType2) AvPairs() []AvPair {
+	return N.TargetInfoData.AvPairs()
+}
+
+func (N NTLMType2) NbComputerName() (string, bool) {
+	return N.TargetInfoData.NbComputerName()
+}
+
+func (N NTLMType2) NbDomainName() (string, bool) {
+	return N.TargetInfoData.NbDomainName()
+}
+
+func (N NTLMType2) DnsComputerName() (string, bool) {
+	return N.TargetInfoData.DnsComputerName()
+}
+
+func (N NTLMType2) DnsDomainName() (string, bool) {
+	return N.TargetInfoData.DnsDomainName()
+}
+
+func (N NTLMType2) DnsTreeName() (string, bool) {
+	return N.TargetInfoData.DnsTreeName()
+}
+
+func (N NTLMType2) Timestamp() (time.Time, bool) {
+	return N.TargetInfoData.Timestamp()
+}
+
+func stringToUCS2(str string) []byte {
+	var words = utf16.Encode([]rune(str))
+	var result = make([]byte, 0, len(words)*2)
+	for _, w := range words {
+		result = binary.LittleEndian.AppendUint16(result, w)
+	}
+	return result
+}
+
 func bytesToUCS2(data []byte) string {
 	words := make([]uint16, len(data)/2)
 	err := binary.Read(bytes.NewReader(data), binary.LittleEndian, &words)
@@ -112,29 +175,9 @@ func bytesToUCS2(data []byte) string {
 	return string(utf16.Decode(words))
 }
 
-func getTargetInfo(buffer []byte, secBuf SecurityBuffer) []TargetInfo {
-	var result []TargetInfo
-	var offsetBuffer = buffer[secBuf.Offset : secBuf.Offset+secBuf.Length]
-	var offset = 0
-	for offset < secBuf.Length {
-		var item = TargetInfo{
-			Type:   int(binary.LittleEndian.Uint16(offsetBuffer[offset+0 : offset+2])),
-			Length: int(binary.LittleEndian.Uint16(offsetBuffer[offset+2 : offset+4])),
-		}
-
-		if item.Type <= 5 {
-			item.Content = bytesToUCS2(buffer[secBuf.Offset+offset+4 : secBuf.Offset+offset+4+item.Length])
-		}
-
-		if item.Type == 7 {
-			var low = binary.LittleEndian.Uint32(offsetBuffer[offset+4 : offset+8])
-			var high = binary.LittleEndian.Uint32(offsetBuffer[offset+8 : offset+12])
-			var date = fileTimeToDate(uint64(high)*uint64(math.Pow(2, 32)) + uint64(low))
-			item.Content = date.UTC().Format(`2006-01-02T15:04:05.999Z`) // 2020-11-18T19:08:09.844Z
-		}
-		result = append(result, item)
-		offset += 2 + 2 + item.Length
-	}
-
-	return result
+// parseAvPairs decodes an AV_PAIR list, stopping after MsvAvEOL.
+func parseAvPairs(buf []byte) (AvPairList, error) {
+	var r = newReader(buf, "")
+	var result = r.avPairs("AvPairs", 0, len(buf))
+	return result, r.err
 }
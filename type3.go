@@ -4,14 +4,46 @@ import (
 	"encoding/binary"
 	"encoding/hex"
 	"sort"
+	"time"
 )
 
 type LMResponseData struct {
 	Hex string
 }
 
+// SessionKeyData is the EncryptedRandomSessionKey, it is only meaningful
+// when NTLMSSP_NEGOTIATE_KEY_EXCH has been negotiated (KeyExch).
+type SessionKeyData struct {
+	Raw     []byte
+	Hex     string
+	KeyExch bool
+}
+
 type NTLMResponseData struct {
 	Hex string
+
+	// NTLMv2Response is nil for NTLMv1 (24-byte) responses.
+	NTLMv2Response *NTLMv2Response
+}
+
+// NTLMv2Response is the NTProofStr followed by the NTLMv2_CLIENT_CHALLENGE
+//
+// reference: https://learn.microsoft.com/en-us/openspecs/windows_protocols/ms-nlmp/d43e2224-6fc3-449d-9f37-b90b55a29c80
+// https://learn.microsoft.com/en-us/openspecs/windows_protocols/ms-nlmp/aee311d6-21a7-4470-92a5-c4ecb022a87b
+type NTLMv2Response struct {
+	NTProofStr      string     `json:"ntProofStr"`
+	RespType        int        `json:"respType"`
+	HiRespType      int        `json:"hiRespType"`
+	Timestamp       time.Time  `json:"timestamp"`
+	ClientChallenge string     `json:"clientChallenge"`
+	AvPairs         AvPairList `json:"avPairs"`
+
+	// MsvAvFlags is the value of the MsvAvFlags AV pair, 0 when absent.
+	MsvAvFlags uint32 `json:"msvAvFlags"`
+}
+
+func (N NTLMResponseData) IsNTLMv2() bool {
+	return N.NTLMv2Response != nil
 }
 
 type NTLMType3v1 struct {
@@ -30,24 +62,53 @@ type NTLMType3v1 struct {
 	TargetNameData      string
 	UserNameData        string
 	WorkstationNameData string
+
+	TargetNameRaw      []byte
+	UserNameRaw        []byte
+	WorkstationNameRaw []byte
+
+	// CodePage decodes and encodes the OEM strings, DefaultCodePage when
+	// zero. The parser sets it to ParseOptions.CodePage.
+	CodePage CodePage
+
+	// Warnings is only filled in with ParseOptions.Lenient.
+	Warnings []*ParseError
+
+	original []byte
 }
 
 func (N NTLMType3v1) Parse(buffer []byte) (NTLMMessage, error) {
+	return N.parse(buffer, ParseOptions{})
+}
+
+func (N NTLMType3v1) parse(buffer []byte, opts ParseOptions) (NTLMMessage, error) {
+	var r = opts.newReader(buffer, AUTHENTICATE_MESSAGE)
 	var (
-		lmResponse      = getSecBuf(buffer, 12)
-		ntlmResponse    = getSecBuf(buffer, 20)
-		targetName      = getSecBuf(buffer, 28)
-		userName        = getSecBuf(buffer, 36)
-		workstationName = getSecBuf(buffer, 44)
+		lmResponse      = r.secBuf("LmChallengeResponseFields", 12)
+		ntlmResponse    = r.secBuf("NtChallengeResponseFields", 20)
+		targetName      = r.secBuf("DomainNameFields", 28)
+		userName        = r.secBuf("UserNameFields", 36)
+		workstationName = r.secBuf("WorkstationFields", 44)
 	)
 
-	var flag = binary.LittleEndian.Uint32(buffer[60:64])
+	var offsets = []SecurityBuffer{lmResponse, ntlmResponse, targetName, userName, workstationName}
+	sort.Slice(offsets, func(i, j int) bool {
+		return offsets[i].Offset < offsets[j].Offset
+	})
+	var firstOffset = offsets[0].Offset
+
+	// NTLM version 1 has no NegotiateFlags, bytes 60-63 are payload and the
+	// strings are taken as UCS-2
+	var flag = NTLMSSP_NEGOTIATE_UNICODE
+	if firstOffset != 52 {
+		flag = NegotiateFlags(r.uint32("NegotiateFlags", 60))
+	}
 
-	var lmResponseData = getLmResponseData(buffer, lmResponse)
-	var ntlmResponseData = getNtlmResponseData(buffer, ntlmResponse)
-	var targetNameData = getSecBufDataWithFlag(buffer, targetName, flag)
-	var userNameData = getSecBufDataWithFlag(buffer, userName, flag)
-	var workstationNameData = getSecBufDataWithFlag(buffer, workstationName, flag)
+	var lmResponseData = getLmResponseData(r.payload("LmChallengeResponse", lmResponse))
+	var ntlmResponseData = getNtlmResponseData(r, "NtChallengeResponse", ntlmResponse)
+	var targetNameData, targetNameRaw = getSecBufDataWithFlag(r.payload("DomainName", targetName), flag, opts.codePage())
+	var userNameData, userNameRaw = getSecBufDataWithFlag(r.payload("UserName", userName), flag, opts.codePage())
+	var workstationNameData, workstationNameRaw = getSecBufDataWithFlag(r.payload("Workstation", workstationName), flag, opts.codePage())
 
 	var type3v1 = &NTLMType3v1{
 		MessageType:         AUTHENTICATE_MESSAGE,
@@ -62,16 +123,20 @@ func (N NTLMType3v1) Parse(buffer []byte) (NTLMMessage, error) {
 		TargetNameData:      targetNameData,
 		UserNameData:        userNameData,
 		WorkstationNameData: workstationNameData,
+		TargetNameRaw:       targetNameRaw,
+		UserNameRaw:         userNameRaw,
+		WorkstationNameRaw:  workstationNameRaw,
+		CodePage:            opts.CodePage,
+		original:            append([]byte(nil), buffer...),
 	}
 
-	var offsets = []SecurityBuffer{lmResponse, ntlmResponse, targetName, userName, workstationName}
-	sort.Slice(offsets, func(i, j int) bool {
-		return offsets[i].Offset < offsets[j].Offset
-	})
-	var firstOffset = offsets[0].Offset
+	if r.err != nil {
+		return nil, r.err
+	}
 
 	// NTLM version 1
 	if firstOffset == 52 {
+		type3v1.Warnings = r.warnings
 		return type3v1, nil
 	}
 
@@ -80,9 +145,14 @@ func (N NTLMType3v1) Parse(buffer []byte) (NTLMMessage, error) {
 		NTLMType3v1: *type3v1,
 	}
 	type3v2.Version = 2
-	type3v2.SessionKey = getSecBuf(buffer, 52)
-	type3v2.Flags = getFlags(flag)
+	type3v2.SessionKey = r.secBuf("EncryptedRandomSessionKeyFields", 52)
+	type3v2.SessionKeyData = getSessionKeyData(r.payload("EncryptedRandomSessionKey", type3v2.SessionKey), flag)
+	type3v2.Flags = flag
+	if r.err != nil {
+		return nil, r.err
+	}
 	if firstOffset == 64 { // NTLM version 2
+		type3v2.Warnings = r.warnings
 		return type3v2, nil
 	}
 
@@ -91,31 +161,101 @@ func (N NTLMType3v1) Parse(buffer []byte) (NTLMMessage, error) {
 		NTLMType3v2: *type3v2,
 	}
 	type3v3.Version = 3
-	type3v3.OsVersionStructure = getOSVersionStructure(buffer, 64)
+	type3v3.OsVersionStructure = r.version("Version", 64)
+
+	if ntlmv2 := ntlmResponseData.NTLMv2Response; ntlmv2 != nil {
+		type3v3.MICPresent = AvFlags(ntlmv2.MsvAvFlags).Has(AvFlagsMICProvided)
+	}
 
+	// the MIC is only there when it doesn't overlap the payload
+	var secBufs = []SecurityBuffer{lmResponse, ntlmResponse, targetName, userName, workstationName, type3v3.SessionKey}
+	if len(buffer) >= 88 && !overlapsPayload(secBufs, 72, 16) {
+		type3v3.MIC = hex.EncodeToString(buffer[72:88])
+	}
+
+	if r.err != nil {
+		return nil, r.err
+	}
+	type3v3.Warnings = r.warnings
 	return type3v3, nil
 }
 
-func getNtlmResponseData(buffer []byte, secBuf SecurityBuffer) NTLMResponseData {
-	var buf = buffer[secBuf.Offset : secBuf.Offset+secBuf.Length]
-	return NTLMResponseData{Hex: hex.EncodeToString(buf)}
+func getNtlmResponseData(r *reader, field string, secBuf SecurityBuffer) NTLMResponseData {
+	var buf = r.payload(field, secBuf)
+	var result = NTLMResponseData{Hex: hex.EncodeToString(buf)}
+	if len(buf) > 24 {
+		result.NTLMv2Response = getNtlmV2Response(r, field, secBuf.Offset, buf)
+	}
+	return result
+}
+
+// getNtlmV2Response decodes buf, the response found at offset in the message.
+func getNtlmV2Response(r *reader, field string, offset int, buf []byte) *NTLMv2Response {
+	// NTProofStr (16) + RespType (1) + HiRespType (1) + Reserved (6) +
+	// TimeStamp (8) + ChallengeFromClient (8) + Reserved (4)
+	const avPairsOffset = 16 + 1 + 1 + 6 + 8 + 8 + 4
+	if len(buf) < avPairsOffset {
+		return nil
+	}
+
+	var avPairs = r.avPairs(field, offset+avPairsOffset, len(buf)-avPairsOffset)
+	var avFlags, _ = avPairs.Flags()
+
+	return &NTLMv2Response{
+		NTProofStr:      hex.EncodeToString(buf[0:16]),
+		RespType:        int(buf[16]),
+		HiRespType:      int(buf[17]),
+		Timestamp:       fileTimeToDate(binary.LittleEndian.Uint64(buf[24:32])),
+		ClientChallenge: hex.EncodeToString(buf[32:40]),
+		AvPairs:         avPairs,
+		MsvAvFlags:      uint32(avFlags),
+	}
 }
 
-func getLmResponseData(buffer []byte, secBuf SecurityBuffer) LMResponseData {
-	var buf = buffer[secBuf.Offset : secBuf.Offset+secBuf.Length]
+func getLmResponseData(buf []byte) LMResponseData {
 	return LMResponseData{Hex: hex.EncodeToString(buf)}
 }
 
+func getSessionKeyData(buf []byte, flag NegotiateFlags) SessionKeyData {
+	return SessionKeyData{
+		Raw:     append([]byte(nil), buf...),
+		Hex:     hex.EncodeToString(buf),
+		KeyExch: flag.Has(NTLMSSP_NEGOTIATE_KEY_EXCH),
+	}
+}
+
 type NTLMType3v2 struct {
 	NTLMType3v1
 
 	SessionKey SecurityBuffer
 
-	Flags string
+	Flags          NegotiateFlags
+	SessionKeyData SessionKeyData
 }
 
 type NTLMType3v3 struct {
 	NTLMType3v2
 
 	OsVersionStructure OSVersionStructure
+
+	// MIC is empty when the 16 bytes at offset 72 belong to the payload.
+	MIC string
+
+	// MICPresent is taken from the MsvAvFlags AV pair (0x00000002) of the
+	// NTLMv2 response, a MIC that is announced but empty has been dropped.
+	MICPresent bool
+}
+
+// overlapsPayload reports whether [offset, offset+length) intersects the
+// payload referenced by any non-empty security buffer.
+func overlapsPayload(secBufs []SecurityBuffer, offset, length int) bool {
+	for _, secBuf := range secBufs {
+		if secBuf.Length == 0 {
+			continue
+		}
+		if secBuf.Offset < offset+length && offset < secBuf.Offset+secBuf.Length {
+			return true
+		}
+	}
+	return false
 }
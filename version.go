@@ -0,0 +1,124 @@
+package ntlm_parser
+
+import (
+	"fmt"
+	"strings"
+)
+
+// NTLMSSP_REVISION_W2K3 is the only NTLMRevisionCurrent defined by MS-NLMP.
+const NTLMSSP_REVISION_W2K3 = 0x0F
+
+// OSVersionStructure is the VERSION structure
+//
+// reference: https://learn.microsoft.com/en-us/openspecs/windows_protocols/ms-nlmp/b1a6ceb2-f8ad-462b-b5af-f18527c48175
+type OSVersionStructure struct {
+	MajorVersion        int
+	MinorVersion        int
+	BuildNumber         int
+	Reserved            [3]byte
+	NTLMRevisionCurrent int
+}
+
+type osProduct struct {
+	major, minor, build int
+	name                string
+}
+
+// osProducts, a build number shared by a client and a server release is
+// listed once per product.
+//
+// reference: https://learn.microsoft.com/en-us/windows/win32/sysinfo/operating-system-version
+// https://www.gaijin.at/en/infos/windows-version-numbers
+// https://learn.microsoft.com/en-us/windows/release-health/release-information
+// https://learn.microsoft.com/en-us/windows-server/get-started/windows-server-release-info
+var osProducts = []osProduct{
+	{5, 0, 2195, "Windows 2000"},
+	{5, 1, 2600, "Windows XP"},
+	{5, 2, 3790, "Windows XP x64"},
+	{5, 2, 3790, "Windows Server 2003"},
+	{6, 0, 6000, "Windows Vista"},
+	{6, 0, 6001, "Windows Vista SP1"},
+	{6, 0, 6001, "Windows Server 2008"},
+	{6, 0, 6002, "Windows Vista SP2"},
+	{6, 0, 6002, "Windows Server 2008 SP2"},
+	{6, 0, 6003, "Windows Server 2008 SP2"},
+	{6, 1, 0, "Samba"},
+	{6, 1, 7600, "Windows 7"},
+	{6, 1, 7600, "Windows Server 2008 R2"},
+	{6, 1, 7601, "Windows 7 SP1"},
+	{6, 1, 7601, "Windows Server 2008 R2 SP1"},
+	{6, 2, 9200, "Windows 8"},
+	{6, 2, 9200, "Windows Server 2012"},
+	{6, 3, 9600, "Windows 8.1"},
+	{6, 3, 9600, "Windows Server 2012 R2"},
+	{10, 0, 10240, "Windows 10 1507"},
+	{10, 0, 10586, "Windows 10 1511"},
+	{10, 0, 14393, "Windows 10 1607"},
+	{10, 0, 14393, "Windows Server 2016"},
+	{10, 0, 15063, "Windows 10 1703"},
+	{10, 0, 16299, "Windows 10 1709"},
+	{10, 0, 16299, "Windows Server 1709"},
+	{10, 0, 17134, "Windows 10 1803"},
+	{10, 0, 17134, "Windows Server 1803"},
+	{10, 0, 17763, "Windows 10 1809"},
+	{10, 0, 17763, "Windows Server 2019"},
+	{10, 0, 18362, "Windows 10 1903"},
+	{10, 0, 18362, "Windows Server 1903"},
+	{10, 0, 18363, "Windows 10 1909"},
+	{10, 0, 18363, "Windows Server 1909"},
+	{10, 0, 19041, "Windows 10 2004"},
+	{10, 0, 19041, "Windows Server 2004"},
+	{10, 0, 19042, "Windows 10 20H2"},
+	{10, 0, 19042, "Windows Server 20H2"},
+	{10, 0, 19043, "Windows 10 21H1"},
+	{10, 0, 19044, "Windows 10 21H2"},
+	{10, 0, 19045, "Windows 10 22H2"},
+	{10, 0, 20348, "Windows Server 2022"},
+	{10, 0, 22000, "Windows 11 21H2"},
+	{10, 0, 22621, "Windows 11 22H2"},
+	{10, 0, 22631, "Windows 11 23H2"},
+	{10, 0, 25398, "Windows Server 23H2"},
+	{10, 0, 26100, "Windows 11 24H2"},
+	{10, 0, 26100, "Windows Server 2025"},
+}
+
+// osFamilies is used when the build number is unknown.
+var osFamilies = map[[2]int]string{
+	{5, 0}:  "Windows 2000",
+	{5, 1}:  "Windows XP",
+	{5, 2}:  "Windows XP x64 or Windows Server 2003",
+	{6, 0}:  "Windows Vista or Windows Server 2008",
+	{6, 1}:  "Windows 7 or Windows Server 2008 R2",
+	{6, 2}:  "Windows 8 or Windows Server 2012",
+	{6, 3}:  "Windows 8.1 or Windows Server 2012 R2",
+	{10, 0}: "Windows 10, Windows 11 or Windows Server 2016 or later",
+}
+
+// Products returns every product that reports this version, more than one
+// when client and server releases share a build number.
+func (o OSVersionStructure) Products() []string {
+	var result []string
+	for _, p := range osProducts {
+		if p.major == o.MajorVersion && p.minor == o.MinorVersion && p.build == o.BuildNumber {
+			result = append(result, p.name)
+		}
+	}
+	return result
+}
+
+// LongString e.g. "Windows 10 1607 or Windows Server 2016 (10.0.14393.15)"
+func (o OSVersionStructure) LongString() string {
+	var v = strings.Join(o.Products(), " or ")
+	if v == "" {
+		v = osFamilies[[2]int{o.MajorVersion, o.MinorVersion}]
+	}
+	if v == "" {
+		v = "Other"
+	}
+
+	return fmt.Sprintf("%s (%s)", v, o.ShortString())
+}
+
+func (o OSVersionStructure) ShortString() string {
+	return fmt.Sprintf("%d.%d.%d.%d", o.MajorVersion, o.MinorVersion, o.BuildNumber, o.NTLMRevisionCurrent)
+}
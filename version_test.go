@@ -0,0 +1,46 @@
+package ntlm_parser
+
+import (
+	"testing"
+)
+
+func TestOSVersionStructureLongString(t *testing.T) {
+	tests := []struct {
+		name    string
+		version OSVersionStructure
+		want    string
+	}{
+		{
+			name:    "shared build",
+			version: OSVersionStructure{MajorVersion: 10, MinorVersion: 0, BuildNumber: 14393, NTLMRevisionCurrent: 15},
+			want:    "Windows 10 1607 or Windows Server 2016 (10.0.14393.15)",
+		},
+		{
+			name:    "client only",
+			version: OSVersionStructure{MajorVersion: 10, MinorVersion: 0, BuildNumber: 22631, NTLMRevisionCurrent: 15},
+			want:    "Windows 11 23H2 (10.0.22631.15)",
+		},
+		{
+			name:    "samba",
+			version: OSVersionStructure{MajorVersion: 6, MinorVersion: 1, BuildNumber: 0, NTLMRevisionCurrent: 15},
+			want:    "Samba (6.1.0.15)",
+		},
+		{
+			name:    "unknown build",
+			version: OSVersionStructure{MajorVersion: 6, MinorVersion: 3, BuildNumber: 9601, NTLMRevisionCurrent: 15},
+			want:    "Windows 8.1 or Windows Server 2012 R2 (6.3.9601.15)",
+		},
+		{
+			name:    "other",
+			version: OSVersionStructure{MajorVersion: 4, MinorVersion: 0, BuildNumber: 1381},
+			want:    "Other (4.0.1381.0)",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.version.LongString(); got != tt.want {
+				t.Errorf("LongString() got = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,223 @@
+package ntlm_parser
+
+import (
+	"bytes"
+	"encoding/hex"
+	"reflect"
+)
+
+// Parsed messages keep the buffer they were parsed from. Bytes returns a
+// copy of that buffer when the message is unmodified, truncated or not,
+// and otherwise starts from it and only re-encodes the fields that no
+// longer match it, keeping the payload order, padding, unreferenced bytes
+// and Allocated values.
+
+// Original returns the buffer the message was parsed from, nil when the
+// message was built by hand. It must not be modified.
+func (N NTLMType1) Original() []byte {
+	return N.original
+}
+
+func (N NTLMType2) Original() []byte {
+	return N.original
+}
+
+func (N NTLMType3v1) Original() []byte {
+	return N.original
+}
+
+// patchWriter starts from a copy of original. payload keeps the original
+// security buffer when the data is unchanged, overwrites it in place when
+// the length is the same and appends the data otherwise.
+func patchWriter(original []byte) *writer {
+	return &writer{
+		buf:      append([]byte(nil), original...),
+		original: original,
+	}
+}
+
+// previous returns the original payload of the security buffer at offset
+// the way the lenient parser reads it, the part that is there when it runs
+// past the end. It is nil when the writer doesn't start from a parsed
+// message.
+func (w *writer) previous(offset int) []byte {
+	if w.original == nil || offset+8 > len(w.original) {
+		return nil
+	}
+	var r = ParseOptions{Lenient: true}.newReader(w.original, "")
+	return r.payload("", getSecBuf(w.original, offset))
+}
+
+// patch is payload for a writer returned by patchWriter. A security buffer
+// out of range is only rewritten when its data has changed.
+func (w *writer) patch(offset int, data []byte) SecurityBuffer {
+	var secBuf = getSecBuf(w.original, offset)
+	var previous = w.previous(offset)
+	switch {
+	case bytes.Equal(previous, data):
+		return secBuf
+	case secBuf.within(len(w.original)) && len(previous) == len(data):
+		copy(w.buf[secBuf.Offset:], data)
+		return secBuf
+	}
+	return w.append(offset, data)
+}
+
+// unmodified reports whether msg, a pointer to a parsed message, is still
+// what its Original buffer parses to, its Warnings aside.
+func unmodified(msg NTLMMessage) bool {
+	var parsed, ok = msg.(interface{ Original() []byte })
+	if !ok || reflect.TypeOf(msg).Kind() != reflect.Pointer || parsed.Original() == nil {
+		return false
+	}
+	var value = reflect.ValueOf(msg).Elem()
+	var opts = ParseOptions{CodePage: value.FieldByName("CodePage").Interface().(CodePage), Lenient: true, MaxMessageSize: -1}
+	var original, err = opts.FromBytes(parsed.Original())
+	if err != nil || reflect.TypeOf(original) != reflect.TypeOf(msg) {
+		return false
+	}
+	reflect.ValueOf(original).Elem().FieldByName("Warnings").Set(value.FieldByName("Warnings"))
+	return reflect.DeepEqual(original, msg)
+}
+
+// type1HeaderSize returns the header size of a parsed NEGOTIATE message, 0
+// when it is too short to be patched.
+func type1HeaderSize(buf []byte) int {
+	switch {
+	case len(buf) == 16:
+		return 16
+	case len(buf) < 32:
+		return 0
+	case getSecBuf(buf, 16).Offset == 32:
+		return 32
+	case len(buf) < 40:
+		return 0
+	}
+	return 40
+}
+
+// type2HeaderSize returns the header size of a parsed CHALLENGE message, 0
+// when it is too short to be patched.
+func type2HeaderSize(buf []byte) int {
+	if len(buf) < 32 {
+		return 0
+	}
+	var headerSize = 56
+	switch getSecBuf(buf, 12).Offset {
+	case 32:
+		headerSize = 32
+	case 48:
+		headerSize = 48
+	}
+	if len(buf) < headerSize {
+		return 0
+	}
+	return headerSize
+}
+
+// type3HeaderSize returns the header size of a parsed AUTHENTICATE message,
+// 52, 64, 72 or 88 with a MIC, the same way parse tells them apart. It is 0
+// when the message is too short to be patched.
+func type3HeaderSize(buf []byte) int {
+	if len(buf) < 52 {
+		return 0
+	}
+	var secBufs []SecurityBuffer
+	var firstOffset = -1
+	for _, offset := range []int{12, 20, 28, 36, 44} {
+		var secBuf = getSecBuf(buf, offset)
+		if firstOffset == -1 || secBuf.Offset < firstOffset {
+			firstOffset = secBuf.Offset
+		}
+		secBufs = append(secBufs, secBuf)
+	}
+
+	switch {
+	case firstOffset == 52:
+		return 52
+	case len(buf) < 64:
+		return 0
+	case firstOffset == 64:
+		return 64
+	case len(buf) < 72:
+		return 0
+	case len(buf) >= 88 && !overlapsPayload(append(secBufs, getSecBuf(buf, 52)), 72, 16):
+		return 88
+	}
+	return 72
+}
+
+// type3Writer patches original when it has the wanted header size.
+func type3Writer(original []byte, headerSize int) *writer {
+	if type3HeaderSize(original) == headerSize {
+		return patchWriter(original)
+	}
+	return newWriter(3, headerSize)
+}
+
+// patchAvPairs encodes list, reusing the bytes of the AV pairs of previous
+// that are unchanged. The padding after the last pair is kept as long as
+// no pair has been added or removed.
+func patchAvPairs(list AvPairList, previous []byte) ([]byte, error) {
+	if previous == nil {
+		return encodeAvPairs(list)
+	}
+
+	var r = ParseOptions{Lenient: true}.newReader(previous, CHALLENGE_MESSAGE)
+	var old = r.avPairs("TargetInfo", 0, len(previous))
+	if reflect.DeepEqual(list, old) {
+		return previous, nil
+	}
+
+	var result []byte
+	var offset int
+	for i, info := range list {
+		if i < len(old) && reflect.DeepEqual(info, old[i]) {
+			result = append(result, previous[offset:offset+4+old[i].Length]...)
+		} else {
+			var data, err = encodeAvPairs(AvPairList{info})
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, data...)
+		}
+		if i < len(old) {
+			offset += 4 + old[i].Length
+		}
+	}
+	if len(list) == len(old) {
+		result = append(result, previous[offset:]...)
+	}
+	return result, nil
+}
+
+// patch encodes N, keeping the reserved bytes and unchanged AV pairs of
+// previous, the response it was parsed from.
+func (N NTLMv2Response) patch(previous []byte) ([]byte, error) {
+	var r = ParseOptions{Lenient: true}.newReader(previous, AUTHENTICATE_MESSAGE)
+	var old = getNtlmV2Response(r, "NtChallengeResponse", 0, previous)
+	if old == nil {
+		return N.Bytes()
+	}
+	if reflect.DeepEqual(&N, old) {
+		return previous, nil
+	}
+
+	var avPairs, err = patchAvPairs(N.AvPairs, previous[44:])
+	if err != nil {
+		return nil, err
+	}
+	var result = append([]byte(nil), previous[:44]...)
+	var w = &writer{buf: result}
+	N.writeHeader(w)
+	if old.Timestamp.Equal(N.Timestamp) {
+		copy(w.buf[24:32], previous[24:32])
+	}
+	w.buf = append(w.buf, avPairs...)
+	return w.bytes()
+}
+
+// changed reports whether str isn't the hex encoding of previous.
+func changed(str string, previous []byte) bool {
+	return previous == nil || str != hex.EncodeToString(previous)
+}